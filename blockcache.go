@@ -0,0 +1,159 @@
+package watch
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/gcs"
+	"github.com/lightninglabs/neutrino"
+)
+
+// blockCache keeps recently fetched blocks and indexes their transactions,
+// so that lookups of several transactions from the same block download it
+// once. It is safe for concurrent use.
+type blockCache struct {
+	mu     sync.Mutex
+	size   int
+	ll     *list.List
+	blocks map[chainhash.Hash]*list.Element
+	txs    map[chainhash.Hash]*btcutil.Tx
+}
+
+func newBlockCache(size int) *blockCache {
+	return &blockCache{
+		size:   size,
+		ll:     list.New(),
+		blocks: make(map[chainhash.Hash]*list.Element),
+		txs:    make(map[chainhash.Hash]*btcutil.Tx),
+	}
+}
+
+func (c *blockCache) getBlock(hash chainhash.Hash) *btcutil.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, has := c.blocks[hash]
+	if !has {
+		return nil
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*btcutil.Block)
+}
+
+func (c *blockCache) getTx(txid chainhash.Hash) *btcutil.Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.txs[txid]
+}
+
+func (c *blockCache) put(block *btcutil.Block) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, has := c.blocks[*block.Hash()]; has {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.blocks[*block.Hash()] = c.ll.PushFront(block)
+	for _, tx := range block.Transactions() {
+		c.txs[*tx.Hash()] = tx
+	}
+
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		old := e.Value.(*btcutil.Block)
+		delete(c.blocks, *old.Hash())
+		for _, tx := range old.Transactions() {
+			// The same tx may be in another block after a reorg.
+			if c.txs[*tx.Hash()] == tx {
+				delete(c.txs, *tx.Hash())
+			}
+		}
+	}
+}
+
+// blockSource is the part of neutrino.ChainService and neutrino.ChainSource
+// used to download blocks and filters.
+type blockSource interface {
+	GetBlock(chainhash.Hash, ...neutrino.QueryOption) (*btcutil.Block, error)
+	GetCFilter(chainhash.Hash, wire.FilterType, ...neutrino.QueryOption) (*gcs.Filter, error)
+}
+
+// fetchFilter downloads the regular filter of the block. It returns ErrClosed
+// if quit is closed while waiting for a query slot.
+func fetchFilter(ctx context.Context, src blockSource, o *options, quit <-chan struct{}, hash *chainhash.Hash, options ...neutrino.QueryOption) (*gcs.Filter, error) {
+	release, err := o.acquireQuery(ctx, quit)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := src.GetCFilter(*hash, wire.GCSFilterRegular, options...)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	if data, err := filter.NBytes(); err == nil {
+		o.downloads.addFilter(len(data))
+	}
+	return filter, nil
+}
+
+// fetchBlock returns the block from the cache or downloads it. It returns
+// ErrClosed if quit is closed while waiting for a query slot.
+func fetchBlock(ctx context.Context, src blockSource, c *blockCache, o *options, quit <-chan struct{}, hash *chainhash.Hash, options ...neutrino.QueryOption) (*btcutil.Block, error) {
+	if block := c.getBlock(*hash); block != nil {
+		return block, nil
+	}
+	release, err := o.acquireQuery(ctx, quit)
+	if err != nil {
+		return nil, err
+	}
+	block, err := src.GetBlock(*hash, options...)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	o.downloads.addBlock(block.MsgBlock().SerializeSize())
+	c.put(block)
+	return block, nil
+}
+
+// rescanSource is the ChainSource of rescans. Their block and filter queries
+// go through fetchBlock and fetchFilter, so they count against
+// WithMaxConcurrentQueries and in DownloadStats, and fetched blocks are
+// cached for GetBlock.
+type rescanSource struct {
+	neutrino.ChainSource
+	blocks *blockCache
+	opts   *options
+	quit   <-chan struct{}
+}
+
+func newRescanSource(cs *neutrino.ChainService, blocks *blockCache, o *options, quit <-chan struct{}) *rescanSource {
+	return &rescanSource{
+		ChainSource: &neutrino.RescanChainSource{ChainService: cs},
+		blocks:      blocks,
+		opts:        o,
+		quit:        quit,
+	}
+}
+
+func (s *rescanSource) GetBlock(hash chainhash.Hash, options ...neutrino.QueryOption) (*btcutil.Block, error) {
+	return fetchBlock(context.Background(), s.ChainSource, s.blocks, s.opts, s.quit, &hash, options...)
+}
+
+func (s *rescanSource) GetCFilter(hash chainhash.Hash, filterType wire.FilterType, options ...neutrino.QueryOption) (*gcs.Filter, error) {
+	if filterType != wire.GCSFilterRegular {
+		return s.ChainSource.GetCFilter(hash, filterType, options...)
+	}
+	return fetchFilter(context.Background(), s.ChainSource, s.opts, s.quit, &hash, options...)
+}
@@ -0,0 +1,151 @@
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/neutrino"
+)
+
+func TestBlockCache(t *testing.T) {
+	makeBlock := func(nonce uint32) *btcutil.Block {
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{Nonce: nonce})
+		msgBlock.AddTransaction(makeTestTx(nonce).MsgTx())
+		return btcutil.NewBlock(msgBlock)
+	}
+	b1, b2, b3 := makeBlock(1), makeBlock(2), makeBlock(3)
+
+	c := newBlockCache(2)
+	c.put(b1)
+	c.put(b2)
+	// Touch b1, so b2 is evicted instead of it.
+	if c.getBlock(*b1.Hash()) != b1 {
+		t.Fatalf("getBlock(b1) missed.")
+	}
+	c.put(b3)
+
+	if c.getBlock(*b2.Hash()) != nil {
+		t.Errorf("b2 was not evicted.")
+	}
+	if c.getTx(*b2.Transactions()[0].Hash()) != nil {
+		t.Errorf("tx of b2 was not evicted.")
+	}
+	for _, b := range []*btcutil.Block{b1, b3} {
+		if c.getBlock(*b.Hash()) != b {
+			t.Errorf("getBlock(%s) missed.", b.Hash())
+		}
+		tx := b.Transactions()[0]
+		if c.getTx(*tx.Hash()) != tx {
+			t.Errorf("getTx(%s) missed.", tx.Hash())
+		}
+	}
+}
+
+func TestAcquireQuery(t *testing.T) {
+	o := newOptions([]Option{WithMaxConcurrentQueries(2)})
+	acquire := func(ctx context.Context, quit <-chan struct{}) func() {
+		release, err := o.acquireQuery(ctx, quit)
+		if err != nil {
+			t.Fatalf("acquireQuery: %v.", err)
+		}
+		return release
+	}
+	release1 := acquire(context.Background(), nil)
+	release2 := acquire(context.Background(), nil)
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := o.acquireQuery(context.Background(), nil)
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("Third query ran while two were running.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release1()
+	<-acquired
+
+	// Waiting for the slot stops on quit and on ctx.
+	release3 := acquire(context.Background(), nil)
+	quit := make(chan struct{})
+	close(quit)
+	if _, err := o.acquireQuery(context.Background(), quit); err != ErrClosed {
+		t.Errorf("acquireQuery after quit returned %v, want ErrClosed.", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := o.acquireQuery(ctx, nil); err != context.Canceled {
+		t.Errorf("acquireQuery with a cancelled ctx returned %v, want context.Canceled.", err)
+	}
+	release2()
+	release3()
+
+	o = newOptions([]Option{WithMaxConcurrentQueries(0)})
+	for i := 0; i < 10; i++ {
+		acquire(context.Background(), nil)
+	}
+}
+
+func TestDownloadCounters(t *testing.T) {
+	c := &downloadCounters{}
+	c.addBlock(1000)
+	c.addBlock(500)
+	c.addFilter(20)
+	want := DownloadStats{Blocks: 2, BlockBytes: 1500, Filters: 1, FilterBytes: 20}
+	if got := c.stats(); got != want {
+		t.Errorf("stats() = %+v, want %+v.", got, want)
+	}
+}
+
+// countingSource is a neutrino.ChainSource serving one block.
+type countingSource struct {
+	neutrino.ChainSource
+	block *btcutil.Block
+	calls int
+}
+
+func (s *countingSource) GetBlock(chainhash.Hash, ...neutrino.QueryOption) (*btcutil.Block, error) {
+	s.calls++
+	return s.block, nil
+}
+
+func TestRescanSource(t *testing.T) {
+	block := btcutil.NewBlock(wire.NewMsgBlock(&wire.BlockHeader{Nonce: 1}))
+	src := &countingSource{block: block}
+	o := newOptions([]Option{WithMaxConcurrentQueries(1)})
+	rs := &rescanSource{ChainSource: src, blocks: newBlockCache(1), opts: o}
+
+	for i := 0; i < 2; i++ {
+		got, err := rs.GetBlock(*block.Hash())
+		if err != nil || got != block {
+			t.Fatalf("GetBlock = %v, %v, want the block.", got, err)
+		}
+	}
+	if src.calls != 1 {
+		t.Errorf("Source was queried %d times, want once, then the cache.", src.calls)
+	}
+	if got := o.downloads.stats().Blocks; got != 1 {
+		t.Errorf("DownloadStats counted %d blocks, want 1.", got)
+	}
+
+	// A rescan waiting for a query slot stops on its quit.
+	release, err := o.acquireQuery(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	quit := make(chan struct{})
+	close(quit)
+	rs = &rescanSource{ChainSource: src, blocks: newBlockCache(1), opts: o, quit: quit}
+	if _, err := rs.GetBlock(*block.Hash()); err != ErrClosed {
+		t.Errorf("GetBlock while the slot is taken returned %v after quit, want ErrClosed.", err)
+	}
+}
@@ -0,0 +1,69 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightninglabs/neutrino"
+)
+
+// ErrCheckpointMismatch is returned, wrapped, if the block at the height of
+// the checkpoint set by WithCheckpoint has another hash, i.e. peers follow
+// another chain. Restarting does not help, so the watcher stops instead.
+var ErrCheckpointMismatch = errors.New("checkpoint does not match the chain of peers")
+
+// checkpoint is a block expected on the chain, set by WithCheckpoint.
+type checkpoint struct {
+	height int32
+	hash   chainhash.Hash
+}
+
+// verifyCheckpoint compares the checkpoint with headers received from peers.
+// It returns false if headers do not reach its height yet.
+func verifyCheckpoint(cs *neutrino.ChainService, o *options) (bool, error) {
+	if o.checkpoint == nil {
+		return true, nil
+	}
+	header, err := cs.BestBlock()
+	if err != nil {
+		return false, err
+	}
+	if header.Height < o.checkpoint.height {
+		return false, nil
+	}
+	hash, err := cs.GetBlockHash(int64(o.checkpoint.height))
+	if err != nil {
+		return false, fmt.Errorf("GetBlockHash(%d) failed: %w", o.checkpoint.height, err)
+	}
+	if err := checkBlock(o, o.checkpoint.height, hash); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkBlock returns ErrCheckpointMismatch if the block at the height of the
+// checkpoint has another hash, e.g. for a block delivered by a rescan after
+// headers reached the checkpoint.
+func checkBlock(o *options, height int32, hash *chainhash.Hash) error {
+	if o.checkpoint == nil || height != o.checkpoint.height || *hash == o.checkpoint.hash {
+		return nil
+	}
+	o.logError("Checkpoint mismatch", "height", height, "want", o.checkpoint.hash, "hash", hash)
+	return fmt.Errorf("%w: at height %d want %s, got %s", ErrCheckpointMismatch, height, o.checkpoint.hash, hash)
+}
+
+// verifySyncedCheckpoint verifies the checkpoint after sync and logs the
+// result.
+func verifySyncedCheckpoint(cs *neutrino.ChainService, o *options) error {
+	verified, err := verifyCheckpoint(cs, o)
+	if err != nil || o.checkpoint == nil {
+		return err
+	}
+	if verified {
+		o.logInfo("Checkpoint verified", "height", o.checkpoint.height, "hash", o.checkpoint.hash)
+	} else {
+		o.logInfo("Checkpoint is above the tip, not verified yet", "height", o.checkpoint.height)
+	}
+	return nil
+}
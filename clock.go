@@ -0,0 +1,22 @@
+package watch
+
+import (
+	"time"
+)
+
+// clock abstracts time, so that waiting and backoff can be tested without
+// real sleeps.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/neutrino"
+)
+
+// Cohort is a group of addresses created together. Blocks after StartHeight
+// are scanned for them.
+type Cohort struct {
+	Addresses   []string
+	StartHeight int32
+}
+
+// AddCohorts watches addresses of cohorts, backfilling each from its start
+// height. The rescan is rewound once to the lowest start height below the
+// scanned height, without disconnect notifications. Blocks rescanned this way
+// are not filtered to the new addresses: they are delivered again with all
+// their relevant transactions to the handlers, sinks, event handlers,
+// subscribers and callbacks such as WithMatchedBlockCallback and
+// WithOnBlockProcessed, so these must tolerate duplicates. Stored matches and
+// address stats are overwritten with the same values.
+func (w *Watcher) AddCohorts(cohorts ...Cohort) error {
+	var aaa []btcutil.Address
+	for _, c := range cohorts {
+		ca, err := w.convertAddresses(c.Addresses...)
+		if err != nil {
+			return err
+		}
+		aaa = append(aaa, ca...)
+	}
+	for _, c := range cohorts {
+		if err := registerAddresses(w.database(), c.StartHeight, c.Addresses); err != nil {
+			return fmt.Errorf("registerAddresses: %w", err)
+		}
+	}
+
+	scanned := atomic.LoadInt32(&w.scannedHeight)
+	rewind := int32(-1)
+	for _, c := range cohorts {
+		if c.StartHeight < scanned && (rewind == -1 || c.StartHeight < rewind) {
+			rewind = c.StartHeight
+		}
+	}
+
+	w.mu.Lock()
+	for _, c := range cohorts {
+		w.addresses = append(w.addresses, c.Addresses...)
+	}
+	watching := w.watching
+	if !watching {
+		// StartWatching will start from the lowest start height.
+		for _, c := range cohorts {
+			if w.backfillFrom == nil || c.StartHeight < *w.backfillFrom {
+				height := c.StartHeight
+				w.backfillFrom = &height
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	var taproot [][]byte
+	for _, c := range cohorts {
+		taproot = append(taproot, w.watchTaproot(c.Addresses)...)
+	}
+	options := []neutrino.UpdateOption{neutrino.AddAddrs(aaa...), neutrino.AddInputs(scriptInputs(taproot)...)}
+	if watching && rewind != -1 {
+		options = append(options, neutrino.Rewind(uint32(rewind)), neutrino.DisableDisconnectedNtfns(true))
+	}
+	return w.updateRescan(options...)
+}
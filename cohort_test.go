@@ -0,0 +1,39 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestAddCohortsBeforeStart(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+
+	const addr1, addr2 = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", "1BitcoinEaterAddressDontSendf59kuE"
+	err := watcher.AddCohorts(
+		Cohort{Addresses: []string{addr1}, StartHeight: 600000},
+		Cohort{Addresses: []string{addr2}, StartHeight: 500000},
+	)
+	if err != nil {
+		t.Fatalf("AddCohorts: %v.", err)
+	}
+	if len(watcher.addresses) != 2 {
+		t.Errorf("Watched addresses are %v, want both.", watcher.addresses)
+	}
+	if watcher.backfillFrom == nil || *watcher.backfillFrom != 500000 {
+		t.Errorf("Backfill is not scheduled from height 500000.")
+	}
+	stats, err := watcher.AddressActivity(addr1)
+	if err != nil {
+		t.Fatalf("AddressActivity: %v.", err)
+	}
+	if stats.RegisteredHeight != 600000 {
+		t.Errorf("Registered height of %s is %d, want 600000.", addr1, stats.RegisteredHeight)
+	}
+
+	if err := watcher.AddCohorts(Cohort{Addresses: []string{"bad"}}); err == nil {
+		t.Errorf("AddCohorts accepted a bad address.")
+	}
+}
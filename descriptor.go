@@ -0,0 +1,221 @@
+package watch
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+const (
+	descriptorInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// descriptorChecksum returns the BIP380 checksum of a descriptor without
+// the checksum.
+func descriptorChecksum(desc string) (string, error) {
+	generator := [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+	chk := uint64(1)
+	polymod := func(value uint64) {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+		for i, g := range generator {
+			if (top>>uint(i))&1 != 0 {
+				chk ^= g
+			}
+		}
+	}
+	var groups []uint64
+	for _, c := range desc {
+		v := strings.IndexRune(descriptorInputCharset, c)
+		if v < 0 {
+			return "", fmt.Errorf("invalid character %q", c)
+		}
+		polymod(uint64(v & 31))
+		groups = append(groups, uint64(v>>5))
+		if len(groups) == 3 {
+			polymod(groups[0]*9 + groups[1]*3 + groups[2])
+			groups = nil
+		}
+	}
+	switch len(groups) {
+	case 1:
+		polymod(groups[0])
+	case 2:
+		polymod(groups[0]*3 + groups[1])
+	}
+	for i := 0; i < 8; i++ {
+		polymod(0)
+	}
+	chk ^= 1
+	sum := make([]byte, 8)
+	for i := range sum {
+		sum[i] = descriptorChecksumCharset[(chk>>(5*uint(7-i)))&31]
+	}
+	return string(sum), nil
+}
+
+// parseDescriptor returns pkScripts of a descriptor, expanding a range up to
+// rangeEnd inclusive.
+func parseDescriptor(desc string, rangeEnd uint32, params *chaincfg.Params) ([][]byte, error) {
+	if i := strings.IndexByte(desc, '#'); i != -1 {
+		want, err := descriptorChecksum(desc[:i])
+		if err != nil {
+			return nil, err
+		}
+		if desc[i+1:] != want {
+			return nil, fmt.Errorf("bad checksum %q, want %q", desc[i+1:], want)
+		}
+		desc = desc[:i]
+	}
+
+	unwrap := func(prefix string) (string, bool) {
+		if strings.HasPrefix(desc, prefix+"(") && strings.HasSuffix(desc, ")") {
+			return desc[len(prefix)+1 : len(desc)-1], true
+		}
+		return "", false
+	}
+	if arg, ok := unwrap("addr"); ok {
+		addr, err := btcutil.DecodeAddress(arg, params)
+		if err != nil {
+			return nil, &sentinelError{ErrInvalidAddress, fmt.Errorf("%s: btcutil.DecodeAddress: %w", arg, err)}
+		}
+		if !addr.IsForNet(params) {
+			return nil, fmt.Errorf("%w: %s is not for %s", ErrInvalidAddress, arg, params.Name)
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, fmt.Errorf("txscript.PayToAddrScript: %w", err)
+		}
+		return [][]byte{script}, nil
+	}
+	if arg, ok := unwrap("raw"); ok {
+		script, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("hex.DecodeString: %w", err)
+		}
+		return [][]byte{script}, nil
+	}
+
+	var addrType derivedType
+	var key string
+	if arg, ok := unwrap("sh"); ok && strings.HasPrefix(arg, "wpkh(") && strings.HasSuffix(arg, ")") {
+		addrType, key = derivedP2SHP2WPKH, arg[len("wpkh("):len(arg)-1]
+	} else if arg, ok := unwrap("wpkh"); ok {
+		addrType, key = derivedP2WPKH, arg
+	} else if arg, ok := unwrap("pkh"); ok {
+		addrType, key = derivedP2PKH, arg
+	} else {
+		return nil, errors.New("unsupported descriptor, want pkh, wpkh, sh(wpkh), addr or raw")
+	}
+	pubKeys, err := descriptorKeys(key, rangeEnd, params)
+	if err != nil {
+		return nil, err
+	}
+	scripts := make([][]byte, 0, len(pubKeys))
+	for _, pubKey := range pubKeys {
+		if addrType != derivedP2PKH && len(pubKey) != btcec.PubKeyBytesLenCompressed {
+			return nil, errors.New("segwit descriptors need compressed keys")
+		}
+		addr, err := pubKeyAddress(pubKey, addrType, params)
+		if err != nil {
+			return nil, err
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, fmt.Errorf("txscript.PayToAddrScript: %w", err)
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// descriptorKeys returns serialized public keys of a key expression: a hex
+// public key or an extended public key with an unhardened path, optionally
+// ending with /*. A key origin in brackets is ignored.
+func descriptorKeys(key string, rangeEnd uint32, params *chaincfg.Params) ([][]byte, error) {
+	if strings.HasPrefix(key, "[") {
+		i := strings.IndexByte(key, ']')
+		if i == -1 {
+			return nil, errors.New("unterminated key origin")
+		}
+		key = key[i+1:]
+	}
+
+	path := strings.Split(key, "/")
+	if len(path) == 1 {
+		pubKey, err := hex.DecodeString(key)
+		if err == nil {
+			_, err = btcec.ParsePubKey(pubKey, btcec.S256())
+		}
+		if err == nil {
+			return [][]byte{pubKey}, nil
+		}
+	}
+
+	ext, err := hdkeychain.NewKeyFromString(path[0])
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", path[0], err)
+	}
+	if ext.IsPrivate() {
+		return nil, errors.New("private keys are not accepted, pass the public one")
+	}
+	if !ext.IsForNet(params) {
+		return nil, fmt.Errorf("extended key is not for %s", params.Name)
+	}
+	ranged := path[len(path)-1] == "*"
+	if ranged {
+		path = path[:len(path)-1]
+	}
+	for _, elem := range path[1:] {
+		index, err := strconv.ParseUint(elem, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("path element %q is not an unhardened index", elem)
+		}
+		if ext, err = ext.Child(uint32(index)); err != nil {
+			return nil, fmt.Errorf("Child(%d): %w", index, err)
+		}
+	}
+
+	children := []*hdkeychain.ExtendedKey{ext}
+	if ranged {
+		children = children[:0]
+		for index := uint32(0); index <= rangeEnd; index++ {
+			child, err := ext.Child(index)
+			if err != nil {
+				return nil, fmt.Errorf("Child(%d): %w", index, err)
+			}
+			children = append(children, child)
+		}
+	}
+	pubKeys := make([][]byte, 0, len(children))
+	for _, child := range children {
+		pubKey, err := child.ECPubKey()
+		if err != nil {
+			return nil, fmt.Errorf("ECPubKey: %w", err)
+		}
+		pubKeys = append(pubKeys, pubKey.SerializeCompressed())
+	}
+	return pubKeys, nil
+}
+
+// AddDescriptor watches outputs described by an output descriptor. Supported
+// are pkh, wpkh and sh(wpkh) of a hex public key or an extended public key
+// with an unhardened path, and addr and raw. A trailing * in the path is
+// expanded to indexes 0 to rangeEnd inclusive; rangeEnd is ignored otherwise.
+// The checksum after # is optional but verified if present.
+func (w *Watcher) AddDescriptor(descriptor string, rangeEnd uint32) error {
+	scripts, err := parseDescriptor(descriptor, rangeEnd, w.params)
+	if err != nil {
+		return fmt.Errorf("parseDescriptor: %w", err)
+	}
+	return w.AddScripts(scripts...)
+}
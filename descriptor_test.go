@@ -0,0 +1,82 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	// From Bitcoin Core doc/descriptors.md.
+	const desc = "wpkh([d34db33f/84h/0h/0h]xpub6DJ2dNUysrn5Vt36jH2KLBT2i1auw1tTSSomg8PhqNiUtx8QX2SvC9nrHu81fT41fvDUnhMjEzQgXnQjKEu3oaqMSzhSrHMxyyoEAmUHQbY/0/*)"
+	got, err := descriptorChecksum(desc)
+	if err != nil {
+		t.Fatalf("descriptorChecksum: %v.", err)
+	}
+	if got != "cjjspncu" {
+		t.Errorf("descriptorChecksum returned %s, want cjjspncu.", got)
+	}
+}
+
+func TestParseDescriptor(t *testing.T) {
+	// The account key of testZpub.
+	const xpub = "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+	params := &chaincfg.MainNetParams
+
+	cases := []struct {
+		desc     string
+		rangeEnd uint32
+		want     []string
+	}{
+		{
+			desc:     "wpkh([73c5da0a/84h/0h/0h]" + xpub + "/0/*)",
+			rangeEnd: 1,
+			want:     []string{testZpubReceiving, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+		},
+		{
+			desc: "wpkh(" + xpub + "/1/0)",
+			want: []string{testZpubChange},
+		},
+		{
+			desc: "pkh(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)",
+			want: []string{"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"},
+		},
+		{
+			desc: "addr(3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs)",
+			want: []string{"3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"},
+		},
+	}
+	for _, tc := range cases {
+		checksum, err := descriptorChecksum(tc.desc)
+		if err != nil {
+			t.Fatalf("descriptorChecksum(%s): %v.", tc.desc, err)
+		}
+		scripts, err := parseDescriptor(tc.desc+"#"+checksum, tc.rangeEnd, params)
+		if err != nil {
+			t.Errorf("parseDescriptor(%s): %v.", tc.desc, err)
+			continue
+		}
+		if len(scripts) != len(tc.want) {
+			t.Errorf("parseDescriptor(%s) returned %d scripts, want %d.", tc.desc, len(scripts), len(tc.want))
+			continue
+		}
+		for i, script := range scripts {
+			_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, params)
+			if err != nil || len(addrs) != 1 || addrs[0].EncodeAddress() != tc.want[i] {
+				t.Errorf("parseDescriptor(%s) script %d pays to %v, want %s.", tc.desc, i, addrs, tc.want[i])
+			}
+		}
+	}
+
+	for _, desc := range []string{
+		"wpkh(" + xpub + "/0/*)#00000000",
+		"wpkh(" + xpub + "/0h/*)",
+		"tr(" + xpub + "/0/*)",
+		"wpkh(04" + "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" + "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8)",
+	} {
+		if _, err := parseDescriptor(desc, 1, params); err == nil {
+			t.Errorf("parseDescriptor(%s) succeeded, want error.", desc)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package watch
+
+import (
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TxInfo is metadata of a relevant transaction.
+type TxInfo struct {
+	// VSize is the virtual size in vbytes.
+	VSize  int64
+	Weight int64
+
+	// Fee is set only if HasFee is true. The fee is known only when
+	// outputs spent by all inputs are resolvable without extra downloads:
+	// they are in the same block (FullWatcher has the whole block) or in
+	// a recently fetched block. It is never known for coinbase.
+	Fee    btcutil.Amount
+	HasFee bool
+
+	// RBF is true if the tx signals replaceability, see IsRBFSignaling.
+	RBF bool
+}
+
+// Payment is an output of a relevant transaction paying to a watched address
+// or script.
+type Payment struct {
+	Vout uint32
+
+	// Address is empty if the script has no standard address.
+	Address  string
+	PkScript []byte
+	Amount   btcutil.Amount
+}
+
+// Event describes a relevant transaction of a connected block.
+type Event struct {
+	Height    int32
+	BlockHash chainhash.Hash
+	Tx        *btcutil.Tx
+	Info      TxInfo
+
+	// Payments lists all outputs of Tx paying to watched addresses and
+	// scripts, in order of outputs. FullWatcher lists all outputs.
+	Payments []Payment
+
+	// Spends lists outpoints watched by AddOutPoints or RegisterSpend
+	// which Tx spends, in order of inputs.
+	Spends []wire.OutPoint
+}
+
+// txSpends returns outpoints in watched spent by tx.
+func txSpends(tx *btcutil.Tx, watched map[wire.OutPoint]bool) []wire.OutPoint {
+	var spends []wire.OutPoint
+	for _, txIn := range tx.MsgTx().TxIn {
+		if watched[txIn.PreviousOutPoint] {
+			spends = append(spends, txIn.PreviousOutPoint)
+		}
+	}
+	return spends
+}
+
+// txPayments returns outputs of tx paying to scripts in watched, or all
+// outputs if watched is nil.
+func txPayments(tx *btcutil.Tx, params *chaincfg.Params, watched map[string]bool) []Payment {
+	var payments []Payment
+	for i, txOut := range tx.MsgTx().TxOut {
+		if watched != nil && !watched[string(txOut.PkScript)] {
+			continue
+		}
+		payment := Payment{
+			Vout:     uint32(i),
+			PkScript: txOut.PkScript,
+			Amount:   btcutil.Amount(txOut.Value),
+		}
+		if a := paymentAddress(txOut.PkScript, params); a != nil {
+			payment.Address = a.EncodeAddress()
+		}
+		payments = append(payments, payment)
+	}
+	return payments
+}
+
+// TxConfirmation tells that a tx watched by WatchConfirmations reached its
+// target.
+type TxConfirmation struct {
+	TxID          chainhash.Hash
+	Height        int32
+	Confirmations int32
+}
+
+// prevOutFunc returns the output spent by the outpoint or nil if unknown.
+type prevOutFunc func(op wire.OutPoint) *wire.TxOut
+
+func txInfo(tx *btcutil.Tx, prevOut prevOutFunc) TxInfo {
+	weight := blockchain.GetTransactionWeight(tx)
+	info := TxInfo{
+		VSize:  (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor,
+		Weight: weight,
+	}
+	if blockchain.IsCoinBase(tx) {
+		return info
+	}
+	info.RBF = IsRBFSignaling(tx)
+
+	var in int64
+	for _, txIn := range tx.MsgTx().TxIn {
+		txOut := prevOut(txIn.PreviousOutPoint)
+		if txOut == nil {
+			return info
+		}
+		in += txOut.Value
+	}
+	var out int64
+	for _, txOut := range tx.MsgTx().TxOut {
+		out += txOut.Value
+	}
+	info.Fee = btcutil.Amount(in - out)
+	info.HasFee = true
+	return info
+}
+
+// blockPrevOut resolves outpoints using transactions of the block and then
+// recently fetched blocks.
+func blockPrevOut(blockTxs []*btcutil.Tx, cache *blockCache) prevOutFunc {
+	txs := make(map[chainhash.Hash]*btcutil.Tx, len(blockTxs))
+	for _, tx := range blockTxs {
+		txs[*tx.Hash()] = tx
+	}
+	return func(op wire.OutPoint) *wire.TxOut {
+		tx := txs[op.Hash]
+		if tx == nil {
+			tx = cache.getTx(op.Hash)
+		}
+		if tx == nil || int(op.Index) >= len(tx.MsgTx().TxOut) {
+			return nil
+		}
+		return tx.MsgTx().TxOut[op.Index]
+	}
+}
+
+// deliverEvents delivers an event for each relevant transaction of the block
+// to the event handler and sinks. It returns false if quit was closed before
+// all events were delivered to sinks. Payments are outputs paying to scripts
+// in watched, or all outputs if watched is nil. Spends are inputs spending
+// watchedOutPoints.
+func deliverEvents(o *options, quit <-chan struct{}, params *chaincfg.Params, watched map[string]bool, watchedOutPoints map[wire.OutPoint]bool, height int32, blockHash *chainhash.Hash, relevantTxs []*btcutil.Tx, prevOut prevOutFunc) bool {
+	if o.eventHandler == nil && len(o.sinks) == 0 {
+		return true
+	}
+	for _, tx := range relevantTxs {
+		event := Event{
+			Height:    height,
+			BlockHash: *blockHash,
+			Tx:        tx,
+			Info:      txInfo(tx, prevOut),
+			Payments:  txPayments(tx, params, watched),
+			Spends:    txSpends(tx, watchedOutPoints),
+		}
+		if o.eventHandler != nil {
+			o.eventHandler(event)
+		}
+		for _, sink := range o.sinks {
+			if !deliverToSink(o, sink, event, quit) {
+				return false
+			}
+		}
+	}
+	return true
+}
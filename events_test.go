@@ -0,0 +1,85 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestTxInfo(t *testing.T) {
+	funding := makeTestTx(1)
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: *funding.Hash(), Index: 0}, nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(700, []byte{0x51}))
+	spending := btcutil.NewTx(msgTx)
+
+	prevOut := blockPrevOut([]*btcutil.Tx{funding, spending}, newBlockCache(0))
+
+	info := txInfo(spending, prevOut)
+	if !info.HasFee || info.Fee != 300 {
+		t.Errorf("Fee is %v (known: %v), want 300.", info.Fee, info.HasFee)
+	}
+	if info.Weight != int64(4*msgTx.SerializeSize()) || info.VSize != int64(msgTx.SerializeSize()) {
+		t.Errorf("Weight is %d and vsize is %d for tx of %d bytes.", info.Weight, info.VSize, msgTx.SerializeSize())
+	}
+
+	if info.RBF {
+		t.Errorf("Tx with final sequences signals RBF.")
+	}
+	msgTx.TxIn[0].Sequence = wire.MaxTxInSequenceNum - 2
+	if info := txInfo(btcutil.NewTx(msgTx), prevOut); !info.RBF {
+		t.Errorf("Tx with sequence %x does not signal RBF.", msgTx.TxIn[0].Sequence)
+	}
+
+	// The input of funding is unknown.
+	if info := txInfo(funding, prevOut); info.HasFee {
+		t.Errorf("Fee of funding is %v, want unknown.", info.Fee)
+	}
+}
+
+func TestTxPayments(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	var scripts [][]byte
+	for _, addr := range []string{"3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", "1BitcoinEaterAddressDontSendf59kuE"} {
+		a, err := btcutil.DecodeAddress(addr, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := txscript.PayToAddrScript(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts = append(scripts, script)
+	}
+	// A batched payment to both addresses and a raw script, with change.
+	change := []byte{0x52}
+	raw := []byte{0x51}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(100, scripts[0]))
+	msgTx.AddTxOut(wire.NewTxOut(200, change))
+	msgTx.AddTxOut(wire.NewTxOut(300, scripts[1]))
+	msgTx.AddTxOut(wire.NewTxOut(400, raw))
+	tx := btcutil.NewTx(msgTx)
+
+	watched := map[string]bool{string(scripts[0]): true, string(scripts[1]): true, string(raw): true}
+	payments := txPayments(tx, params, watched)
+	if len(payments) != 3 {
+		t.Fatalf("Got %d payments, want 3.", len(payments))
+	}
+	if p := payments[0]; p.Vout != 0 || p.Address != "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs" || p.Amount != 100 {
+		t.Errorf("First payment is %+v.", p)
+	}
+	if p := payments[1]; p.Vout != 2 || p.Address != "1BitcoinEaterAddressDontSendf59kuE" || p.Amount != 300 {
+		t.Errorf("Second payment is %+v.", p)
+	}
+	if p := payments[2]; p.Vout != 3 || p.Address != "" || p.Amount != 400 {
+		t.Errorf("Third payment is %+v.", p)
+	}
+
+	if payments := txPayments(tx, params, nil); len(payments) != 4 {
+		t.Errorf("Got %d payments without watched set, want 4.", len(payments))
+	}
+}
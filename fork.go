@@ -0,0 +1,60 @@
+package watch
+
+import (
+	"time"
+)
+
+const (
+	tipHintInterval = time.Minute
+
+	// tipHintRounds is how many checks in a row must find us behind the
+	// hint, so a hint racing ahead of a new block does not trigger.
+	tipHintRounds = 3
+)
+
+// forkDetector counts consecutive checks which found our tip behind the hint.
+type forkDetector struct {
+	threshold int32
+	behind    int
+}
+
+// observe returns true once our tip has been behind the hint by more than the
+// threshold for tipHintRounds checks in a row. It returns true again only
+// after we catch up.
+func (d *forkDetector) observe(ourHeight, hintHeight int32) bool {
+	if hintHeight-ourHeight <= d.threshold {
+		d.behind = 0
+		return false
+	}
+	d.behind++
+	return d.behind == tipHintRounds
+}
+
+// watchTipHint compares our tip with the hint set by WithTipHint until quit
+// is closed.
+func watchTipHint(o *options, quit <-chan struct{}, currentHeight func() (int32, error)) {
+	d := &forkDetector{threshold: o.tipHintThreshold}
+	for {
+		select {
+		case <-quit:
+			return
+		case <-o.clock.After(tipHintInterval):
+		}
+		hintHeight, err := o.tipHint()
+		if err != nil {
+			o.logWarn("Tip hint failed", "err", err)
+			continue
+		}
+		ourHeight, err := currentHeight()
+		if err != nil {
+			o.logWarn("CurrentHeight failed", "err", err)
+			continue
+		}
+		if d.observe(ourHeight, hintHeight) {
+			o.logWarn("Our tip is behind the hint, we may be on a forked chain", "height", ourHeight, "hint", hintHeight)
+			if o.onPossibleForkedChain != nil {
+				o.onPossibleForkedChain(ourHeight, hintHeight)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package watch
+
+import (
+	"testing"
+)
+
+func TestForkDetector(t *testing.T) {
+	d := &forkDetector{threshold: 2}
+	steps := []struct {
+		ours, hint int32
+		want       bool
+	}{
+		{ours: 100, hint: 102, want: false},
+		{ours: 100, hint: 103, want: false},
+		{ours: 100, hint: 104, want: false},
+		// A single check within the threshold resets the count.
+		{ours: 103, hint: 104, want: false},
+		{ours: 103, hint: 110, want: false},
+		{ours: 103, hint: 111, want: false},
+		{ours: 103, hint: 112, want: true},
+		// Reported once until we catch up.
+		{ours: 103, hint: 113, want: false},
+		{ours: 113, hint: 113, want: false},
+		{ours: 113, hint: 120, want: false},
+		{ours: 113, hint: 120, want: false},
+		{ours: 113, hint: 120, want: true},
+	}
+	for i, s := range steps {
+		if got := d.observe(s.ours, s.hint); got != s.want {
+			t.Errorf("Step %d: observe(%d, %d) = %v, want %v.", i, s.ours, s.hint, got, s.want)
+		}
+	}
+}
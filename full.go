@@ -1,11 +1,14 @@
 package watch
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
@@ -20,24 +23,127 @@ type FullWatcher struct {
 	params        *chaincfg.Params
 	blockCallback func(*btcutil.Block)
 	fullClose     chan struct{}
+	closeOnce     sync.Once
+	closeDone     chan struct{}
+	closeErr      error
+	opts          *options
+	blocks        *blockCache
+	dir           string
+
+	// blockStream is returned by Blocks. It is closed by Close after the
+	// watching goroutine, which sends to it, has exited.
+	blockStream chan *btcutil.Block
+
+	mu       sync.Mutex
+	loopDone chan struct{}
+	skip     map[int32]bool
+	skipped  []int32
+
+	// watched has pkScripts of addresses passed to AddAddresses. If it is
+	// empty, all transactions are delivered. outPoints are outputs paying
+	// to watched scripts, to detect spends of them. They are stored in db,
+	// see putProcessedOutPoints.
+	watched   map[string]bool
+	outPoints map[wire.OutPoint]bool
+
+	// tracker detects reorgs. It is used by the watching goroutine only.
+	tracker chainTracker
+}
+
+// errBeyondTip is returned by getBlock if the height is not mined yet.
+var errBeyondTip = errors.New("height is beyond the tip")
+
+// ErrNoProcessedHeight is returned by ResumeWatching if no block was
+// processed in the directory yet.
+var ErrNoProcessedHeight = errors.New("no processed height stored, use StartWatching")
+
+func NewFullWatcher(torSocks string, testnet bool, dir string, blockCallback func(*btcutil.Block), opts ...Option) (*FullWatcher, error) {
+	return NewFullWatcherForNetwork(torSocks, boolNetwork(testnet), dir, blockCallback, opts...)
+}
+
+// NewFullWatcherForNetwork is NewFullWatcher for any network.
+func NewFullWatcherForNetwork(torSocks string, network Network, dir string, blockCallback func(*btcutil.Block), opts ...Option) (*FullWatcher, error) {
+	params, err := network.Params()
+	if err != nil {
+		return nil, err
+	}
+	return NewFullWatcherWithParams(torSocks, params, dir, blockCallback, opts...)
 }
 
-func NewFullWatcher(torSocks string, testnet bool, dir string, blockCallback func(*btcutil.Block)) (*FullWatcher, error) {
-	cs, db, params, err := makeService(nil, torSocks, testnet, dir)
+// NewFullWatcherWithParams is NewFullWatcher for a chain with custom
+// parameters, see NewWithParams.
+func NewFullWatcherWithParams(torSocks string, params *chaincfg.Params, dir string, blockCallback func(*btcutil.Block), opts ...Option) (*FullWatcher, error) {
+	o := newOptions(opts)
+	cs, db, err := makeService(nil, torSocks, params, dir, nil, o)
 	if err != nil {
 		return nil, err
 	}
-	return &FullWatcher{
+	outPoints, err := loadOutPoints(db)
+	if err != nil {
+		cs.Stop()
+		db.Close()
+		return nil, fmt.Errorf("loadOutPoints: %w", err)
+	}
+	var blockStream chan *btcutil.Block
+	if o.blockStream {
+		blockStream = make(chan *btcutil.Block, o.blockStreamBuffer)
+	}
+	w := &FullWatcher{
 		cs:            cs,
 		db:            db,
 		params:        params,
 		blockCallback: blockCallback,
 		fullClose:     make(chan struct{}),
-	}, nil
+		opts:          o,
+		blocks:        newBlockCache(o.txCacheSize),
+		dir:           dir,
+		blockStream:   blockStream,
+		outPoints:     outPoints,
+	}
+	if o.tipHint != nil {
+		go watchTipHint(o, w.fullClose, w.CurrentHeight)
+	}
+	if o.healthChecks() {
+		go watchHealth(o, w.fullClose, w.healthSample)
+	}
+	return w, nil
 }
 
+// Close stops the watcher. Later calls return the result of the first one.
 func (w *FullWatcher) Close() error {
-	close(w.fullClose)
+	return w.CloseContext(context.Background())
+}
+
+// CloseContext is Close returning ctx.Err() if ctx is done before the
+// shutdown completes. The shutdown continues in the background then. Later
+// calls wait for the same shutdown.
+func (w *FullWatcher) CloseContext(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		close(w.fullClose)
+		w.closeDone = make(chan struct{})
+		go func() {
+			w.closeErr = w.close()
+			close(w.closeDone)
+		}()
+	})
+	select {
+	case <-w.closeDone:
+		return w.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *FullWatcher) close() error {
+	w.mu.Lock()
+	loopDone := w.loopDone
+	w.mu.Unlock()
+	if loopDone != nil {
+		<-loopDone
+	}
+	if w.blockStream != nil {
+		close(w.blockStream)
+	}
 	if err := w.cs.Stop(); err != nil {
 		return err
 	}
@@ -47,20 +153,51 @@ func (w *FullWatcher) Close() error {
 	return nil
 }
 
+func (w *FullWatcher) isClosed() bool {
+	select {
+	case <-w.fullClose:
+		return true
+	default:
+		return false
+	}
+}
+
 func (w *FullWatcher) WaitForSync() error {
+	return w.WaitForSyncContext(context.Background())
+}
+
+// WaitForSyncContext is WaitForSync which returns ctx.Err() when ctx is done.
+func (w *FullWatcher) WaitForSyncContext(ctx context.Context) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
 	for !w.cs.IsCurrent() {
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.fullClose:
+			return ErrClosed
+		case <-w.opts.clock.After(w.opts.pollInterval()):
+		}
 
 		header, err := w.cs.BestBlock()
 		if err != nil {
 			return err
 		}
-		log.Printf("%d %s", header.Height, header.Hash)
+		w.opts.logInfo("Syncing", "height", header.Height, "hash", header.Hash)
+		reportSyncProgress(w.cs, w.opts, header.Height)
+
+		if _, err := verifyCheckpoint(w.cs, w.opts); err != nil {
+			return err
+		}
 	}
-	return nil
+	return verifySyncedCheckpoint(w.cs, w.opts)
 }
 
 func (w *FullWatcher) CurrentHeight() (int32, error) {
+	if w.isClosed() {
+		return 0, ErrClosed
+	}
 	header, err := w.cs.BestBlock()
 	if err != nil {
 		return 0, err
@@ -68,14 +205,157 @@ func (w *FullWatcher) CurrentHeight() (int32, error) {
 	return header.Height, nil
 }
 
-func (w *FullWatcher) StartWatching(startBlock int32, handlers rpcclient.NotificationHandlers) {
+// CurrentTip returns the hash and height of the best block header.
+func (w *FullWatcher) CurrentTip() (*chainhash.Hash, int32, error) {
+	if w.isClosed() {
+		return nil, 0, ErrClosed
+	}
+	header, err := w.cs.BestBlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	return &header.Hash, header.Height, nil
+}
+
+// IsSynced reports whether block headers are synced, without waiting like
+// WaitForSync.
+func (w *FullWatcher) IsSynced() bool {
+	return w.cs.IsCurrent()
+}
+
+// SyncStatus returns the height of the best block header and whether block
+// headers are synced.
+func (w *FullWatcher) SyncStatus() (current int32, synced bool, err error) {
+	current, err = w.CurrentHeight()
+	if err != nil {
+		return 0, false, err
+	}
+	return current, w.cs.IsCurrent(), nil
+}
+
+// ChainService returns the underlying neutrino service for read-only queries
+// which the package does not wrap. Do not start, stop or reconfigure it.
+func (w *FullWatcher) ChainService() *neutrino.ChainService {
+	return w.cs
+}
+
+// DefaultConfirmations returns the number of confirmations considered final,
+// see WithDefaultConfirmations.
+func (w *FullWatcher) DefaultConfirmations() int32 {
+	return w.opts.defaultConfirmations
+}
+
+// Params returns parameters of the network the watcher follows.
+func (w *FullWatcher) Params() *chaincfg.Params {
+	return w.params
+}
+
+// PrepareTxOutputs is like package-level PrepareTxOutputs for the network of
+// the watcher.
+func (w *FullWatcher) PrepareTxOutputs(tx *btcutil.Tx) map[string]btcutil.Amount {
+	return NewOutputParser(w.params).ParseOutputs(tx)
+}
+
+// PrepareTxScriptOutputs is like package-level PrepareTxScriptOutputs for the
+// network of the watcher.
+func (w *FullWatcher) PrepareTxScriptOutputs(tx *btcutil.Tx) map[string]btcutil.Amount {
+	return NewOutputParser(w.params).ParseScriptOutputs(tx)
+}
+
+// PrepareTxOutputRefs is like package-level PrepareTxOutputRefs for the
+// network of the watcher.
+func (w *FullWatcher) PrepareTxOutputRefs(tx *btcutil.Tx) map[string][]OutputRef {
+	return NewOutputParser(w.params).ParseOutputRefs(tx)
+}
+
+// NetworkMarker returns the name of the network the directory was created for.
+func (w *FullWatcher) NetworkMarker() (string, error) {
+	return readNetworkMarker(w.opts.markerDir(w.dir))
+}
+
+// CacheStats returns sizes of neutrino's block and filter caches, or
+// ErrClosed after Close.
+func (w *FullWatcher) CacheStats() (CacheStats, error) {
+	if w.isClosed() {
+		return CacheStats{}, ErrClosed
+	}
+	return cacheStats(w.cs, w.opts), nil
+}
+
+// DownloadStats returns the traffic of blocks and filters since the watcher
+// was created.
+func (w *FullWatcher) DownloadStats() DownloadStats {
+	return w.opts.downloads.stats()
+}
+
+// GetBlock returns the block with the hash, downloading it unless it was
+// fetched recently.
+func (w *FullWatcher) GetBlock(hash *chainhash.Hash) (*btcutil.Block, error) {
+	if w.isClosed() {
+		return nil, ErrClosed
+	}
+	return fetchBlock(context.Background(), w.cs, w.blocks, w.opts, w.fullClose, hash)
+}
+
+// GetBlockDeposits returns payments to addrs in the block at the height.
+func (w *FullWatcher) GetBlockDeposits(height int32, addrs []string) ([]DepositRecord, error) {
+	for _, addr := range addrs {
+		if _, err := decodeAddress(addr, w.params); err != nil {
+			return nil, err
+		}
+	}
+	tipHeight, err := w.CurrentHeight()
+	if err != nil {
+		return nil, err
+	}
+	blockHash, err := w.cs.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, fmt.Errorf("GetBlockHash(%d) failed: %w", height, err)
+	}
+	block, err := w.GetBlock(blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("for height %d GetBlock failed: %w", height, err)
+	}
+	return blockDeposits(w.params, block, height, tipHeight, addrs), nil
+}
+
+// StartWatching delivers blocks from startBlock, or from the next block if it
+// is StartFromTip, in a goroutine. If a block does not build on the previous
+// one, blocks which left the chain are delivered to OnBlockDisconnected and
+// OnFilteredBlockDisconnected from the newest down, and blocks of the new
+// chain follow from the fork up. Only the last 100 delivered blocks are
+// remembered to find the fork, also not across restarts.
+func (w *FullWatcher) StartWatching(startBlock int32, handlers rpcclient.NotificationHandlers) error {
 	if err := w.WaitForSync(); err != nil {
-		panic(err)
+		return err
 	}
 
 	height := startBlock
+	if startBlock == StartFromTip {
+		bestHeight, err := w.CurrentHeight()
+		if err != nil {
+			return err
+		}
+		height = bestHeight + 1
+	}
+
+	loopDone := make(chan struct{})
+	w.mu.Lock()
+	if w.loopDone != nil {
+		w.mu.Unlock()
+		return ErrAlreadyWatching
+	}
+	w.loopDone = loopDone
+	w.mu.Unlock()
 
 	go func() {
+		defer close(loopDone)
+		var p *prefetcher
+		if w.opts.downloadWorkers > 1 {
+			p = newPrefetcher(w, w.opts.downloadWorkers, handlers)
+			defer p.wait()
+		}
+		failures := 0
 		for {
 			select {
 			case <-w.fullClose:
@@ -83,62 +363,350 @@ func (w *FullWatcher) StartWatching(startBlock int32, handlers rpcclient.Notific
 			default:
 			}
 
-			if err := w.getBlock(height, handlers); err != nil {
+			if w.skipHeight(height) {
+				w.opts.logInfo("Skipping height", "height", height)
+				p.drop(height)
+				height++
+				continue
+			}
+
+			if err := w.getBlock(height, handlers, p); err != nil {
+				if errors.Is(err, errBeyondTip) {
+					continue
+				}
+				if errors.Is(err, ErrCheckpointMismatch) {
+					w.opts.logError("Stopped watching, peers follow another chain", "height", height, "err", err)
+					return
+				}
+				var reorg *reorgError
+				if errors.As(err, &reorg) {
+					// Downloads above the fork may be of the old chain.
+					p.reset()
+					height = reorg.forkHeight + 1
+					failures = 0
+					continue
+				}
 				select {
 				case <-w.fullClose:
 					return
 				default:
 				}
-				log.Println(err)
-				time.Sleep(time.Second)
+				delay := w.opts.retryDelay(failures)
+				failures++
+				w.opts.logWarn("Processing block failed, retrying", "height", height, "err", err, "delay", delay)
+				select {
+				case <-w.fullClose:
+					return
+				case <-w.opts.clock.After(delay):
+				}
 				continue
 			}
 
+			failures = 0
 			height++
 		}
 	}()
+
+	return nil
+}
+
+// ResumeWatching is StartWatching from the block after the last one processed
+// in the directory, so that no block is skipped or delivered twice across
+// restarts, except the block which was being processed during a crash.
+func (w *FullWatcher) ResumeWatching(handlers rpcclient.NotificationHandlers) error {
+	height, found, err := loadProcessedHeight(w.db)
+	if err != nil {
+		return fmt.Errorf("loadProcessedHeight: %w", err)
+	}
+	if !found {
+		return ErrNoProcessedHeight
+	}
+	return w.StartWatching(height+1, handlers)
 }
 
-func (w *FullWatcher) getBlock(height int32, handlers rpcclient.NotificationHandlers) error {
+// getBlock fetches the block at the height, using p if not nil, and
+// processes it.
+func (w *FullWatcher) getBlock(height int32, handlers rpcclient.NotificationHandlers, p *prefetcher) error {
 	bestHeight, err := w.CurrentHeight()
 	if err != nil {
 		return fmt.Errorf("BestBlock failed: %w", err)
 	}
 	if height > bestHeight {
-		time.Sleep(time.Second)
-		return nil
+		select {
+		case <-w.opts.clock.After(time.Second):
+		case <-w.fullClose:
+		}
+		return errBeyondTip
+	}
+
+	var f *fetchedBlock
+	if p != nil {
+		f, err = p.get(height, bestHeight)
+	} else {
+		f, err = w.fetchHeight(height, needsHeader(handlers))
+	}
+	if err != nil {
+		return err
 	}
+	return w.processBlock(height, f, handlers)
+}
+
+// fetchedBlock is a block downloaded by fetchHeight.
+type fetchedBlock struct {
+	hash   *chainhash.Hash
+	block  *btcutil.Block
+	header *wire.BlockHeader
+}
+
+func needsHeader(handlers rpcclient.NotificationHandlers) bool {
+	return handlers.OnBlockConnected != nil || handlers.OnFilteredBlockConnected != nil
+}
 
+// fetchHeight downloads the block at the height and, if needHeader, its header.
+func (w *FullWatcher) fetchHeight(height int32, needHeader bool) (*fetchedBlock, error) {
 	blockHash, err := w.cs.GetBlockHash(int64(height))
 	if err != nil {
-		return fmt.Errorf("GetBlockHash(%d) failed: %w", height, err)
+		return nil, fmt.Errorf("GetBlockHash(%d) failed: %w", height, err)
 	}
-	block, err := w.cs.GetBlock(*blockHash)
+	block, err := w.GetBlock(blockHash)
 	if err != nil {
-		return fmt.Errorf("for height %d GetBlock failed: %v.", height, err)
+		return nil, fmt.Errorf("for height %d GetBlock failed: %w", height, err)
 	}
 	var header *wire.BlockHeader
-	if handlers.OnBlockConnected != nil || handlers.OnFilteredBlockConnected != nil {
+	if needHeader {
 		header, err = w.cs.GetBlockHeader(blockHash)
 		if err != nil {
-			return fmt.Errorf("for height %d GetBlockHeader(%s) failed: %v.", height, blockHash, err)
+			return nil, fmt.Errorf("for height %d GetBlockHeader(%s) failed: %w", height, blockHash, err)
+		}
+	}
+	return &fetchedBlock{hash: blockHash, block: block, header: header}, nil
+}
+
+// processBlock calls the callbacks and handlers for the block.
+func (w *FullWatcher) processBlock(height int32, f *fetchedBlock, handlers rpcclient.NotificationHandlers) error {
+	blockHash, block, header := f.hash, f.block, f.header
+
+	if err := checkBlock(w.opts, height, blockHash); err != nil {
+		return err
+	}
+	if !w.tracker.follows(height, &block.MsgBlock().Header) {
+		forkHeight, err := w.disconnectStale(handlers)
+		if err != nil {
+			return err
 		}
+		return &reorgError{forkHeight: forkHeight}
 	}
 
+	prevOut := blockPrevOut(block.Transactions(), w.blocks)
+	relevantTxs, watched, change := w.relevantTxs(block.Transactions(), prevOut)
+
 	if w.blockCallback != nil {
 		w.blockCallback(block)
 	}
+	if !w.streamBlock(block) {
+		return ErrClosed
+	}
 	if handlers.OnBlockConnected != nil {
 		handlers.OnBlockConnected(blockHash, height, header.Timestamp)
 	}
 	if handlers.OnFilteredBlockConnected != nil {
-		handlers.OnFilteredBlockConnected(height, header, block.Transactions())
+		handlers.OnFilteredBlockConnected(height, header, relevantTxs)
 	}
+	if !deliverEvents(w.opts, w.fullClose, w.params, watched, nil, height, blockHash, relevantTxs, prevOut) {
+		return ErrClosed
+	}
+	if w.opts.onBlockProcessed != nil {
+		w.opts.onBlockProcessed(height, *blockHash)
+	}
+
+	// Stored after handlers return, so a crash or a retry redelivers the
+	// block with the outpoints it was matched against.
+	if err := w.applyOutPoints(height, change); err != nil {
+		return err
+	}
+	w.tracker.add(height, &block.MsgBlock().Header, change)
 
 	return nil
 }
 
+// SkipHeight makes the watcher move past the height without processing it,
+// e.g. if its block can not be fetched. Handlers are not called for it.
+func (w *FullWatcher) SkipHeight(height int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.skip == nil {
+		w.skip = make(map[int32]bool)
+	}
+	w.skip[height] = true
+}
+
+// SkippedHeights returns heights skipped due to SkipHeight, in order.
+func (w *FullWatcher) SkippedHeights() []int32 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]int32(nil), w.skipped...)
+}
+
+// skipHeight reports whether the height must be skipped and records it.
+func (w *FullWatcher) skipHeight(height int32) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.skip[height] {
+		return false
+	}
+	delete(w.skip, height)
+	w.skipped = append(w.skipped, height)
+	return true
+}
+
+// Blocks returns a channel receiving each processed block in order of heights.
+// It is nil unless WithBlockStream is set and is closed by Close.
+func (w *FullWatcher) Blocks() <-chan *btcutil.Block {
+	return w.blockStream
+}
+
+// streamBlock sends the block to the stream, if any. It returns false if the
+// watcher was closed while waiting for a slow consumer.
+func (w *FullWatcher) streamBlock(block *btcutil.Block) bool {
+	if w.blockStream == nil {
+		return true
+	}
+	if w.opts.blockStreamDrop {
+		select {
+		case w.blockStream <- block:
+		default:
+			w.opts.logWarn("Blocks consumer is slow, dropped block", "hash", block.Hash())
+		}
+		return true
+	}
+	select {
+	case w.blockStream <- block:
+		return true
+	case <-w.fullClose:
+		return false
+	}
+}
+
+// ValidateAddress checks that addr can be watched on the network of the
+// watcher, without watching it.
+func (w *FullWatcher) ValidateAddress(addr string) error {
+	_, err := decodeAddress(addr, w.params)
+	return err
+}
+
+// AddAddresses makes the watcher deliver only transactions paying to or
+// spending from watched addresses. Without addresses all transactions are
+// delivered. Spends are detected for outputs received in processed blocks,
+// which are stored in the database and survive restarts, or in recently
+// fetched blocks. Addresses themselves are not stored, pass them again after
+// a restart.
 func (w *FullWatcher) AddAddresses(addrs ...string) error {
-	// TODO: implement
+	aaa := make([]btcutil.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		a, err := decodeAddress(addr, w.params)
+		if err != nil {
+			return err
+		}
+		aaa = append(aaa, a)
+	}
+	scripts, err := addressScripts(aaa)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watched == nil {
+		w.watched = make(map[string]bool)
+	}
+	for _, script := range scripts {
+		w.watched[string(script)] = true
+	}
+	return nil
+}
+
+// outPointChange is how a block changed the outpoints of FullWatcher.
+// Outputs received and spent in the same block are in neither list.
+type outPointChange struct {
+	added, spent []wire.OutPoint
+}
+
+// undo returns the change reverting c.
+func (c outPointChange) undo() outPointChange {
+	return outPointChange{added: c.spent, spent: c.added}
+}
+
+// relevantTxs returns transactions paying to or spending from watched
+// scripts, a copy of the scripts and how the block changes the outpoints,
+// which applyOutPoints applies once the block is delivered. If no addresses
+// are watched, it returns all transactions and nil.
+func (w *FullWatcher) relevantTxs(txs []*btcutil.Tx, prevOut prevOutFunc) ([]*btcutil.Tx, map[string]bool, outPointChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.watched) == 0 {
+		return txs, nil, outPointChange{}
+	}
+
+	var relevant []*btcutil.Tx
+	var change outPointChange
+	// received has outputs of this block, which may be spent in it.
+	received := make(map[wire.OutPoint]bool)
+	for _, tx := range txs {
+		isRelevant := false
+		for _, txIn := range tx.MsgTx().TxIn {
+			op := txIn.PreviousOutPoint
+			if received[op] {
+				delete(received, op)
+				isRelevant = true
+			} else if w.outPoints[op] {
+				change.spent = append(change.spent, op)
+				isRelevant = true
+			} else if txOut := prevOut(op); txOut != nil && w.watched[string(txOut.PkScript)] {
+				isRelevant = true
+			}
+		}
+		for i, txOut := range tx.MsgTx().TxOut {
+			if w.watched[string(txOut.PkScript)] {
+				received[wire.OutPoint{Hash: *tx.Hash(), Index: uint32(i)}] = true
+				isRelevant = true
+			}
+		}
+		if isRelevant {
+			relevant = append(relevant, tx)
+		}
+	}
+	// Kept in the order of the block.
+	for _, tx := range txs {
+		for i := range tx.MsgTx().TxOut {
+			if op := (wire.OutPoint{Hash: *tx.Hash(), Index: uint32(i)}); received[op] {
+				change.added = append(change.added, op)
+			}
+		}
+	}
+
+	watched := make(map[string]bool, len(w.watched))
+	for script := range w.watched {
+		watched[script] = true
+	}
+	return relevant, watched, change
+}
+
+// applyOutPoints stores the changes in order together with the processed
+// height, so that a crash does not leave one without the other, and then
+// applies them in memory.
+func (w *FullWatcher) applyOutPoints(height int32, changes ...outPointChange) error {
+	if err := putProcessedOutPoints(w.db, height, changes); err != nil {
+		return fmt.Errorf("putProcessedOutPoints: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range changes {
+		for _, op := range c.added {
+			w.outPoints[op] = true
+		}
+		for _, op := range c.spent {
+			delete(w.outPoints, op)
+		}
+	}
 	return nil
 }
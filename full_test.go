@@ -0,0 +1,622 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcwallet/walletdb"
+	"github.com/lightninglabs/neutrino/headerfs"
+)
+
+func newTestFullWatcher(t *testing.T, opts ...Option) (*FullWatcher, func()) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := NewFullWatcher("", false, tmpDir, nil, opts...)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("NewFullWatcher: %v.", err)
+	}
+	return watcher, func() {
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestFullWatcherClosed(t *testing.T) {
+	watcher, cleanup := newTestFullWatcher(t)
+	defer cleanup()
+
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if err := watcher.StartWatching(0, rpcclient.NotificationHandlers{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("StartWatching after Close returned %v, want ErrClosed.", err)
+	}
+	if err := watcher.WaitForSync(); !errors.Is(err, ErrClosed) {
+		t.Errorf("WaitForSync after Close returned %v, want ErrClosed.", err)
+	}
+	if _, err := watcher.CurrentHeight(); !errors.Is(err, ErrClosed) {
+		t.Errorf("CurrentHeight after Close returned %v, want ErrClosed.", err)
+	}
+	if _, err := watcher.GetBlock(watcher.params.GenesisHash); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetBlock after Close returned %v, want ErrClosed.", err)
+	}
+	if _, err := watcher.CacheStats(); !errors.Is(err, ErrClosed) {
+		t.Errorf("CacheStats after Close returned %v, want ErrClosed.", err)
+	}
+	if _, err := watcher.fetchHeight(0, false); !errors.Is(err, ErrClosed) {
+		t.Errorf("fetchHeight after Close returned %v, want it to wrap ErrClosed.", err)
+	}
+}
+
+func TestNetworkMarker(t *testing.T) {
+	watcher, cleanup := newTestFullWatcher(t)
+	defer cleanup()
+
+	name, err := watcher.NetworkMarker()
+	if err != nil {
+		t.Fatalf("NetworkMarker: %v.", err)
+	}
+	if name != "mainnet" {
+		t.Errorf("NetworkMarker is %q, want mainnet.", name)
+	}
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+
+	if _, err := NewFullWatcher("", true, watcher.dir, nil); err == nil {
+		t.Errorf("NewFullWatcher for testnet in a mainnet directory succeeded.")
+	}
+}
+
+func TestChainReset(t *testing.T) {
+	params := chaincfg.TestNet3Params
+	checkpoint := params.Checkpoints[1]
+	other := chainhash.Hash{1}
+	cases := []struct {
+		tip    int32
+		stored map[int64]*chainhash.Hash
+		height int32
+		want   bool
+	}{
+		{tip: 0, stored: map[int64]*chainhash.Hash{0: params.GenesisHash}},
+		{tip: 0, stored: map[int64]*chainhash.Hash{0: &other}, want: true},
+		// A tip far behind the network is not a reset.
+		{tip: checkpoint.Height - 1, stored: map[int64]*chainhash.Hash{0: params.GenesisHash, int64(params.Checkpoints[0].Height): params.Checkpoints[0].Hash}},
+		{tip: checkpoint.Height, stored: map[int64]*chainhash.Hash{0: params.GenesisHash, int64(params.Checkpoints[0].Height): params.Checkpoints[0].Hash, int64(checkpoint.Height): &other}, height: checkpoint.Height, want: true},
+	}
+	for _, c := range cases {
+		height, got, err := chainReset(&params, c.tip, func(height int64) (*chainhash.Hash, error) {
+			hash, ok := c.stored[height]
+			if !ok {
+				return nil, fmt.Errorf("no header at height %d", height)
+			}
+			return hash, nil
+		})
+		if err != nil {
+			t.Errorf("chainReset at tip %d: %v.", c.tip, err)
+			continue
+		}
+		if got != c.want || height != c.height {
+			t.Errorf("chainReset at tip %d = %d, %v, want %d, %v.", c.tip, height, got, c.height, c.want)
+		}
+	}
+	if _, _, err := chainReset(&params, 0, func(int64) (*chainhash.Hash, error) { return nil, errors.New("broken") }); err == nil {
+		t.Errorf("chainReset ignored a failed lookup.")
+	}
+}
+
+func TestBlockStream(t *testing.T) {
+	watcher, cleanup := newTestFullWatcher(t, WithBlockStream(1, true))
+	defer cleanup()
+
+	block := btcutil.NewBlock(wire.NewMsgBlock(&wire.BlockHeader{}))
+	if !watcher.streamBlock(block) || !watcher.streamBlock(block) {
+		t.Errorf("streamBlock returned false.")
+	}
+	if len(watcher.Blocks()) != 1 {
+		t.Errorf("Stream has %d blocks, want 1 after dropping the second.", len(watcher.Blocks()))
+	}
+
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	<-watcher.Blocks()
+	if _, ok := <-watcher.Blocks(); ok {
+		t.Errorf("Stream is not closed after Close.")
+	}
+
+	watcher.opts.blockStreamDrop = false
+	watcher.blockStream = make(chan *btcutil.Block)
+	if watcher.streamBlock(block) {
+		t.Errorf("Blocking streamBlock after Close returned true.")
+	}
+}
+
+func TestSkipHeight(t *testing.T) {
+	w := &FullWatcher{}
+	w.SkipHeight(20)
+	w.SkipHeight(10)
+	for _, height := range []int32{9, 10, 11, 20} {
+		want := height == 10 || height == 20
+		if got := w.skipHeight(height); got != want {
+			t.Errorf("skipHeight(%d) = %v, want %v.", height, got, want)
+		}
+	}
+	if got := w.SkippedHeights(); !reflect.DeepEqual(got, []int32{10, 20}) {
+		t.Errorf("SkippedHeights() = %v, want [10 20].", got)
+	}
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	genesis := *chaincfg.MainNetParams.GenesisHash
+	watcher, cleanup := newTestFullWatcher(t, WithCheckpoint(0, genesis))
+	defer cleanup()
+	defer watcher.Close()
+
+	if verified, err := verifyCheckpoint(watcher.cs, watcher.opts); !verified || err != nil {
+		t.Errorf("verifyCheckpoint of genesis = %v, %v, want verified.", verified, err)
+	}
+	WithCheckpoint(0, chainhash.Hash{1})(watcher.opts)
+	if _, err := verifyCheckpoint(watcher.cs, watcher.opts); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("verifyCheckpoint of a wrong hash returned %v, want ErrCheckpointMismatch.", err)
+	}
+	WithCheckpoint(1000, chainhash.Hash{1})(watcher.opts)
+	if verified, err := verifyCheckpoint(watcher.cs, watcher.opts); verified || err != nil {
+		t.Errorf("verifyCheckpoint above the tip = %v, %v, want not verified.", verified, err)
+	}
+
+	if err := checkBlock(watcher.opts, 1000, &genesis); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("checkBlock of a wrong block at the checkpoint returned %v, want ErrCheckpointMismatch.", err)
+	}
+	if err := checkBlock(watcher.opts, 999, &genesis); err != nil {
+		t.Errorf("checkBlock below the checkpoint returned %v, want nil.", err)
+	}
+}
+
+// cancelClock is fakeClock which cancels a context when waiting.
+type cancelClock struct {
+	fakeClock
+	cancel func()
+}
+
+func (c *cancelClock) After(d time.Duration) <-chan time.Time {
+	c.cancel()
+	return c.fakeClock.After(d)
+}
+
+func TestWaitForSyncContext(t *testing.T) {
+	watcher, cleanup := newTestFullWatcher(t, WithSyncPollInterval(250*time.Millisecond), WithLogger(&testLogger{}))
+	defer cleanup()
+	defer watcher.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &cancelClock{cancel: cancel}
+	watcher.opts.clock = clock
+
+	// Without peers the chain never becomes current.
+	if err := watcher.WaitForSyncContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForSyncContext returned %v, want context.Canceled.", err)
+	}
+	if len(clock.delays) == 0 || clock.delays[0] != 250*time.Millisecond {
+		t.Errorf("First poll delay is not 250ms.")
+	}
+
+	if got := newOptions([]Option{WithSyncPollInterval(0)}).pollInterval(); got != 10*time.Second {
+		t.Errorf("Poll interval of zero is %v, want 10s.", got)
+	}
+}
+
+func TestFullWatcherRelevantTxs(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTx := func(prev wire.OutPoint, pkScript []byte) *btcutil.Tx {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.AddTxIn(wire.NewTxIn(&prev, nil, nil))
+		msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+		return btcutil.NewTx(msgTx)
+	}
+	deposit := newTx(wire.OutPoint{Index: 1}, pkScript)
+	other := newTx(wire.OutPoint{Index: 2}, []byte{0x51})
+	spend := newTx(wire.OutPoint{Hash: *deposit.Hash()}, []byte{0x51})
+	noPrevOut := func(wire.OutPoint) *wire.TxOut { return nil }
+
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	w := &FullWatcher{params: &chaincfg.MainNetParams, db: db, outPoints: map[wire.OutPoint]bool{}}
+	relevantTxs := func(txs ...*btcutil.Tx) ([]*btcutil.Tx, map[string]bool) {
+		got, watched, change := w.relevantTxs(txs, noPrevOut)
+		if err := w.applyOutPoints(0, change); err != nil {
+			t.Fatalf("applyOutPoints: %v.", err)
+		}
+		return got, watched
+	}
+	txs := []*btcutil.Tx{deposit, other}
+	if got, watched := relevantTxs(txs...); !reflect.DeepEqual(got, txs) || watched != nil {
+		t.Errorf("without addresses relevantTxs = %v, %v, want all transactions.", got, watched)
+	}
+
+	if err := w.AddAddresses(addr); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	if err := w.AddAddresses("invalid"); err == nil {
+		t.Errorf("AddAddresses of an invalid address succeeded.")
+	}
+	got, watched := relevantTxs(txs...)
+	if want := []*btcutil.Tx{deposit}; !reflect.DeepEqual(got, want) {
+		t.Errorf("relevantTxs = %v, want the deposit.", got)
+	}
+	if !watched[string(pkScript)] || len(watched) != 1 {
+		t.Errorf("relevantTxs returned watched scripts %v.", watched)
+	}
+
+	// The received output survives a restart.
+	outPoints, err := loadOutPoints(db)
+	if err != nil {
+		t.Fatalf("loadOutPoints: %v.", err)
+	}
+	w = &FullWatcher{params: &chaincfg.MainNetParams, db: db, outPoints: outPoints}
+	if err := w.AddAddresses(addr); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	// A block which is retried after a failed delivery matches again.
+	if got, _, _ := w.relevantTxs([]*btcutil.Tx{other, spend}, noPrevOut); !reflect.DeepEqual(got, []*btcutil.Tx{spend}) {
+		t.Errorf("relevantTxs after a restart = %v, want the spend.", got)
+	}
+	if got, _ := relevantTxs(other, spend); !reflect.DeepEqual(got, []*btcutil.Tx{spend}) {
+		t.Errorf("relevantTxs of a retried block = %v, want the spend.", got)
+	}
+	if outPoints, err := loadOutPoints(db); err != nil || len(outPoints) != 0 {
+		t.Errorf("After the spend loadOutPoints = %v, %v, want none.", outPoints, err)
+	}
+}
+
+func TestProcessedHeightSurvivesCrash(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbFile := filepath.Join(tmpDir, "wallet.db")
+	db, err := walletdb.Create("bdb", dbFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const crashHeight = 4
+	var delivered []int32
+	handlers := rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			if height == crashHeight {
+				panic("crash")
+			}
+			delivered = append(delivered, height)
+		},
+	}
+	// Blocks are linked, so they are not taken for a reorg.
+	var prev chainhash.Hash
+	process := func(w *FullWatcher, height int32) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("handler panicked: %v", r)
+			}
+		}()
+		block := btcutil.NewBlock(&wire.MsgBlock{
+			Header:       wire.BlockHeader{PrevBlock: prev, Nonce: uint32(height)},
+			Transactions: []*wire.MsgTx{makeTestTx(uint32(height)).MsgTx()},
+		})
+		f := &fetchedBlock{hash: block.Hash(), block: block, header: &block.MsgBlock().Header}
+		if err := w.processBlock(height, f, handlers); err != nil {
+			return err
+		}
+		prev = *block.Hash()
+		return nil
+	}
+
+	w := &FullWatcher{db: db, params: &chaincfg.MainNetParams, opts: newOptions(nil), blocks: newBlockCache(1)}
+	for height := int32(1); height <= crashHeight; height++ {
+		if err := process(w, height); err != nil {
+			if height != crashHeight {
+				t.Fatalf("processBlock(%d): %v.", height, err)
+			}
+			break
+		}
+	}
+	db.Close()
+
+	// Reopen as after a restart of the process.
+	db, err = walletdb.Open("bdb", dbFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	height, found, err := loadProcessedHeight(db)
+	if err != nil || !found {
+		t.Fatalf("loadProcessedHeight = %d, %v, %v.", height, found, err)
+	}
+	w = &FullWatcher{db: db, params: &chaincfg.MainNetParams, opts: newOptions(nil), blocks: newBlockCache(1)}
+	for height := height + 1; height <= crashHeight+1; height++ {
+		if height == crashHeight {
+			handlers.OnFilteredBlockConnected = func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+				delivered = append(delivered, height)
+			}
+		}
+		if err := process(w, height); err != nil {
+			t.Fatalf("processBlock(%d) after restart: %v.", height, err)
+		}
+	}
+	if want := []int32{1, 2, 3, 4, 5}; !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered heights %v, want %v.", delivered, want)
+	}
+}
+
+func TestFullWatcherReorg(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	w, err := NewFullWatcherForNetwork("", Regtest, tmpDir, nil)
+	if err != nil {
+		t.Fatalf("NewFullWatcherForNetwork: %v.", err)
+	}
+	defer w.Close()
+
+	var events []string
+	handlers := rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			events = append(events, fmt.Sprintf("connected %d %d", height, header.Nonce))
+		},
+		OnFilteredBlockDisconnected: func(height int32, header *wire.BlockHeader) {
+			events = append(events, fmt.Sprintf("disconnected %d %d", height, header.Nonce))
+		},
+	}
+	// extend mines blocks on prev, tagging them with the fork in Nonce,
+	// and makes them the header chain.
+	extend := func(prev *wire.BlockHeader, height int32, n int, fork uint32) []*btcutil.Block {
+		var blocks []*btcutil.Block
+		for i := 0; i < n; i++ {
+			block := btcutil.NewBlock(&wire.MsgBlock{
+				Header:       wire.BlockHeader{PrevBlock: prev.BlockHash(), Nonce: fork},
+				Transactions: []*wire.MsgTx{makeTestTx(uint32(height + int32(i))).MsgTx()},
+			})
+			header := block.MsgBlock().Header
+			err := w.cs.BlockHeaders.WriteHeaders(headerfs.BlockHeader{BlockHeader: &header, Height: uint32(height + int32(i))})
+			if err != nil {
+				t.Fatalf("WriteHeaders: %v.", err)
+			}
+			blocks = append(blocks, block)
+			prev = &header
+		}
+		return blocks
+	}
+	process := func(height int32, block *btcutil.Block) error {
+		f := &fetchedBlock{hash: block.Hash(), block: block, header: &block.MsgBlock().Header}
+		return w.processBlock(height, f, handlers)
+	}
+
+	chainA := extend(&chaincfg.RegressionNetParams.GenesisBlock.Header, 1, 4, 1)
+	for i, block := range chainA {
+		if err := process(int32(i+1), block); err != nil {
+			t.Fatalf("processBlock(%d): %v.", i+1, err)
+		}
+	}
+
+	// Blocks 3 and 4 are replaced by 3 blocks of another chain.
+	for i := 0; i < 2; i++ {
+		if _, err := w.cs.BlockHeaders.RollbackLastBlock(); err != nil {
+			t.Fatalf("RollbackLastBlock: %v.", err)
+		}
+	}
+	chainB := extend(&chainA[1].MsgBlock().Header, 3, 3, 2)
+	var reorg *reorgError
+	if err := process(5, chainB[2]); !errors.As(err, &reorg) || reorg.forkHeight != 2 {
+		t.Fatalf("processBlock of the new chain returned %v, want a reorg at height 2.", err)
+	}
+	if height, _, _ := loadProcessedHeight(w.db); height != 2 {
+		t.Errorf("Processed height is %d after the reorg, want 2.", height)
+	}
+	for i, block := range chainB {
+		if err := process(int32(i+3), block); err != nil {
+			t.Fatalf("processBlock(%d) of the new chain: %v.", i+3, err)
+		}
+	}
+
+	want := []string{
+		"connected 1 1", "connected 2 1", "connected 3 1", "connected 4 1",
+		"disconnected 4 1", "disconnected 3 1",
+		"connected 3 2", "connected 4 2", "connected 5 2",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Events are %q, want %q.", events, want)
+	}
+}
+
+func TestFullWatcherReorgOutPoints(t *testing.T) {
+	const addr = "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	w, err := NewFullWatcherForNetwork("", Regtest, tmpDir, nil)
+	if err != nil {
+		t.Fatalf("NewFullWatcherForNetwork: %v.", err)
+	}
+	defer w.Close()
+	if err := w.AddAddresses(addr); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	a, err := btcutil.DecodeAddress(addr, w.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTx := func(prev wire.OutPoint, pkScript []byte) *wire.MsgTx {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.AddTxIn(wire.NewTxIn(&prev, nil, nil))
+		msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+		return msgTx
+	}
+	deposit := newTx(wire.OutPoint{Index: 1}, pkScript)
+	orphan := newTx(wire.OutPoint{Index: 2}, pkScript)
+	spendA := newTx(wire.OutPoint{Hash: deposit.TxHash()}, []byte{0x51})
+	spendB := newTx(wire.OutPoint{Hash: deposit.TxHash()}, []byte{0x52})
+
+	relevant := map[int32]int{}
+	handlers := rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			relevant[height] = len(txs)
+		},
+	}
+	// mine makes a block with txs on prev the header tip.
+	mine := func(prev *wire.BlockHeader, height int32, fork uint32, txs ...*wire.MsgTx) *btcutil.Block {
+		block := btcutil.NewBlock(&wire.MsgBlock{
+			Header:       wire.BlockHeader{PrevBlock: prev.BlockHash(), Nonce: fork},
+			Transactions: append([]*wire.MsgTx{makeTestTx(uint32(height)).MsgTx()}, txs...),
+		})
+		header := block.MsgBlock().Header
+		if err := w.cs.BlockHeaders.WriteHeaders(headerfs.BlockHeader{BlockHeader: &header, Height: uint32(height)}); err != nil {
+			t.Fatalf("WriteHeaders: %v.", err)
+		}
+		return block
+	}
+	process := func(height int32, block *btcutil.Block) error {
+		f := &fetchedBlock{hash: block.Hash(), block: block, header: &block.MsgBlock().Header}
+		return w.processBlock(height, f, handlers)
+	}
+
+	a1 := mine(&chaincfg.RegressionNetParams.GenesisBlock.Header, 1, 1, deposit)
+	a2 := mine(&a1.MsgBlock().Header, 2, 1, spendA, orphan)
+	for i, block := range []*btcutil.Block{a1, a2} {
+		if err := process(int32(i+1), block); err != nil {
+			t.Fatalf("processBlock(%d): %v.", i+1, err)
+		}
+	}
+
+	// Block 2 is replaced by another chain, which spends the deposit in
+	// block 3 instead.
+	if _, err := w.cs.BlockHeaders.RollbackLastBlock(); err != nil {
+		t.Fatalf("RollbackLastBlock: %v.", err)
+	}
+	b2 := mine(&a1.MsgBlock().Header, 2, 2)
+	b3 := mine(&b2.MsgBlock().Header, 3, 2, spendB)
+	var reorg *reorgError
+	if err := process(3, b3); !errors.As(err, &reorg) || reorg.forkHeight != 1 {
+		t.Fatalf("processBlock of the new chain returned %v, want a reorg at height 1.", err)
+	}
+	want := map[wire.OutPoint]bool{{Hash: deposit.TxHash()}: true}
+	if outPoints, err := loadOutPoints(w.db); err != nil || !reflect.DeepEqual(outPoints, want) {
+		t.Errorf("Stored outpoints after the reorg are %v, %v, want only the deposit.", outPoints, err)
+	}
+	if !reflect.DeepEqual(w.outPoints, want) {
+		t.Errorf("Outpoints after the reorg are %v, want only the deposit.", w.outPoints)
+	}
+
+	for i, block := range []*btcutil.Block{b2, b3} {
+		if err := process(int32(i+2), block); err != nil {
+			t.Fatalf("processBlock(%d) of the new chain: %v.", i+2, err)
+		}
+	}
+	if relevant[3] != 1 {
+		t.Errorf("Block 3 of the new chain has %d relevant transactions, want the spend.", relevant[3])
+	}
+	if len(w.outPoints) != 0 {
+		t.Errorf("Outpoints after the spend are %v, want none.", w.outPoints)
+	}
+}
+
+func TestChainTrackerGap(t *testing.T) {
+	var c chainTracker
+	h1 := &wire.BlockHeader{Nonce: 1}
+	c.add(10, h1, outPointChange{})
+	h2 := &wire.BlockHeader{Nonce: 2}
+	if c.follows(11, h2) {
+		t.Errorf("A block not building on the last one follows it.")
+	}
+	// Height 11 was skipped.
+	c.add(12, h2, outPointChange{})
+	if len(c.blocks) != 1 || !c.follows(13, &wire.BlockHeader{PrevBlock: h2.BlockHash()}) {
+		t.Errorf("Tracking did not start over after a gap: %v.", c.blocks)
+	}
+	for height := int32(13); height < 13+2*maxReorgDepth; height++ {
+		c.add(height, &wire.BlockHeader{Nonce: uint32(height)}, outPointChange{})
+	}
+	if len(c.blocks) != maxReorgDepth {
+		t.Errorf("Tracking %d blocks, want %d.", len(c.blocks), maxReorgDepth)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	watcher, cleanup := newTestFullWatcher(t)
+	defer cleanup()
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if err := watcher.Close(); err != nil {
+		t.Errorf("second Close returned %v, want nil.", err)
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	watcher, cleanup := newTestFullWatcher(t)
+	defer cleanup()
+
+	// The watching loop is stuck.
+	loopDone := make(chan struct{})
+	watcher.mu.Lock()
+	watcher.loopDone = loopDone
+	watcher.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := watcher.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("CloseContext returned %v, want context.DeadlineExceeded.", err)
+	}
+	if !watcher.isClosed() {
+		t.Errorf("watcher is not closed after CloseContext timed out.")
+	}
+
+	close(loopDone)
+	if err := watcher.Close(); err != nil {
+		t.Errorf("Close after the loop exited returned %v, want nil.", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	o := newOptions([]Option{WithRetryBackoff(time.Second, 10*time.Second)})
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := o.retryDelay(attempt); delay < want/2 || delay > want {
+				t.Fatalf("retryDelay(%d) = %s, want between %s and %s.", attempt, delay, want/2, want)
+			}
+		}
+	}
+	if delay := newOptions([]Option{WithRetryBackoff(0, 0)}).retryDelay(3); delay != 0 {
+		t.Errorf("retryDelay without backoff = %s, want 0.", delay)
+	}
+}
@@ -0,0 +1,73 @@
+package watch
+
+import (
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// gatedBlock is a connected block held until it has enough confirmations.
+type gatedBlock struct {
+	height int32
+	header *wire.BlockHeader
+	txs    []*btcutil.Tx
+}
+
+// confirmations returns how many confirmations the block needs: min, or
+// coinbaseMaturity if one of its txs is a coinbase, which can not be spent
+// before.
+func (b gatedBlock) confirmations(min, coinbaseMaturity int32) int32 {
+	for _, tx := range b.txs {
+		if blockchain.IsCoinBase(tx) && coinbaseMaturity > min {
+			return coinbaseMaturity
+		}
+	}
+	return min
+}
+
+// confirmationGate delays blocks until they are buried under
+// minConfirmations-1 blocks, see WithMinConfirmations. Blocks with a
+// relevant coinbase tx are delayed until it matures, and later blocks wait
+// behind them to keep the order. It is used by the rescan goroutine and by
+// StartWatching while no rescan runs.
+type confirmationGate struct {
+	minConfirmations int32
+	coinbaseMaturity int32
+	pending          []gatedBlock
+}
+
+// connect adds the block, replacing pending blocks at the height and above,
+// and returns blocks which got enough confirmations, in order of heights.
+func (g *confirmationGate) connect(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) []gatedBlock {
+	g.disconnect(height)
+	g.pending = append(g.pending, gatedBlock{height: height, header: header, txs: txs})
+	var ready []gatedBlock
+	for len(g.pending) != 0 && height-g.pending[0].height+1 >= g.pending[0].confirmations(g.minConfirmations, g.coinbaseMaturity) {
+		ready = append(ready, g.pending[0])
+		g.pending = g.pending[1:]
+	}
+	return ready
+}
+
+// disconnect drops pending blocks at the height and above, so their
+// transactions are never delivered. It returns true if the block at the height
+// was pending, i.e. it was not delivered.
+func (g *confirmationGate) disconnect(height int32) bool {
+	held := false
+	for len(g.pending) != 0 && g.pending[len(g.pending)-1].height >= height {
+		held = held || g.pending[len(g.pending)-1].height == height
+		g.pending = g.pending[:len(g.pending)-1]
+	}
+	return held
+}
+
+// rewind drops all pending blocks and returns the height to start a rescan
+// from, below startBlock if needed, so that the dropped blocks are connected
+// again.
+func (g *confirmationGate) rewind(startBlock int32) int32 {
+	if len(g.pending) != 0 && g.pending[0].height-1 < startBlock {
+		startBlock = g.pending[0].height - 1
+	}
+	g.pending = nil
+	return startBlock
+}
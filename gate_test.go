@@ -0,0 +1,123 @@
+package watch
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestConfirmationGate(t *testing.T) {
+	gate := &confirmationGate{minConfirmations: 3}
+	payment, reorged := makeTestTx(1), makeTestTx(2)
+
+	var delivered []*btcutil.Tx
+	connect := func(height int32, want []int32, txs ...*btcutil.Tx) {
+		var heights []int32
+		for _, b := range gate.connect(height, &wire.BlockHeader{}, txs) {
+			heights = append(heights, b.height)
+			delivered = append(delivered, b.txs...)
+		}
+		if !reflect.DeepEqual(heights, want) {
+			t.Errorf("after connecting %d ready %v, want %v.", height, heights, want)
+		}
+	}
+
+	connect(10, nil, payment)
+	connect(11, nil, reorged)
+	connect(12, []int32{10})
+	gate.disconnect(12)
+	gate.disconnect(11)
+	connect(11, nil)
+	connect(12, nil)
+	connect(13, []int32{11})
+	connect(14, []int32{12})
+
+	if want := []*btcutil.Tx{payment}; !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered %v, want only the payment.", delivered)
+	}
+
+	// A block with a coinbase is held until it matures, and the next block
+	// waits behind it.
+	gate.coinbaseMaturity = 5
+	connect(15, []int32{13}, makeTestCoinbase(15))
+	connect(16, []int32{14})
+	connect(17, nil)
+	connect(18, nil)
+	connect(19, []int32{15, 16, 17})
+}
+
+func TestConfirmationGateHandlers(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{
+		db:     db,
+		params: &chaincfg.MainNetParams,
+		opts:   newOptions([]Option{WithLogger(&testLogger{})}),
+		blocks: newBlockCache(10),
+		gate:   &confirmationGate{minConfirmations: 3},
+	}
+
+	var events []string
+	ntfn := watcher.rescanHandlers(rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			events = append(events, fmt.Sprintf("connect %d/%d", height, header.Nonce))
+		},
+		OnFilteredBlockDisconnected: func(height int32, header *wire.BlockHeader) {
+			events = append(events, fmt.Sprintf("disconnect %d/%d", height, header.Nonce))
+		},
+	}, make(chan struct{}))
+	// Headers of the old chain have nonce 1, of the new chain nonce 2.
+	connect := func(height int32, nonce uint32) {
+		ntfn.OnFilteredBlockConnected(height, &wire.BlockHeader{Nonce: nonce}, nil)
+	}
+	disconnect := func(height int32, nonce uint32) {
+		ntfn.OnFilteredBlockDisconnected(height, &wire.BlockHeader{Nonce: nonce})
+	}
+
+	connect(10, 1)
+	connect(11, 1)
+	connect(12, 1)
+	connect(13, 1)
+	// A reorg of two blocks, which only the first one was delivered of.
+	disconnect(13, 1)
+	disconnect(12, 1)
+	disconnect(11, 1)
+	connect(11, 2)
+	connect(12, 2)
+	connect(13, 2)
+	connect(14, 2)
+
+	want := []string{"connect 10/1", "connect 11/1", "disconnect 11/1", "connect 11/2", "connect 12/2"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Handlers got %v, want %v.", events, want)
+	}
+
+	// A restart after 14 rescans the held blocks 13 and 14.
+	if start := watcher.gate.rewind(14); start != 12 {
+		t.Errorf("Rescan restarts after %d, want 12.", start)
+	}
+	if start := watcher.gate.rewind(14); start != 14 {
+		t.Errorf("Rescan restarts after %d without held blocks, want 14.", start)
+	}
+}
+
+func TestBlockConfirmations(t *testing.T) {
+	for _, tc := range []struct {
+		opts []Option
+		want int32
+	}{
+		{nil, 1},
+		{[]Option{WithMinConfirmations(3)}, 3},
+		{[]Option{WithMinConfirmations(0)}, defaultConfirmations},
+		{[]Option{WithMinConfirmations(0), WithDefaultConfirmations(2)}, 2},
+	} {
+		if got := newOptions(tc.opts).blockConfirmations(); got != tc.want {
+			t.Errorf("blockConfirmations with %d options = %d, want %d.", len(tc.opts), got, tc.want)
+		}
+	}
+}
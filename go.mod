@@ -9,4 +9,6 @@ require (
 	github.com/btcsuite/btcwallet/walletdb v1.2.0
 	github.com/lightninglabs/neutrino v0.11.0
 	github.com/lightningnetwork/lnd v0.8.2-beta
+	github.com/prometheus/client_golang v0.9.3
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
 )
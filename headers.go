@@ -0,0 +1,108 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/neutrino"
+)
+
+// blockHashAt returns the hash of the block at the height from the header
+// chain. Heights below zero or above the tip are rejected.
+func blockHashAt(cs *neutrino.ChainService, height int32) (*chainhash.Hash, error) {
+	if height < 0 {
+		return nil, fmt.Errorf("negative height %d", height)
+	}
+	tip, err := cs.BestBlock()
+	if err != nil {
+		return nil, fmt.Errorf("BestBlock: %w", err)
+	}
+	if height > tip.Height {
+		return nil, fmt.Errorf("height %d: %w (%d)", height, errBeyondTip, tip.Height)
+	}
+	hash, err := cs.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, fmt.Errorf("GetBlockHash(%d): %w", height, err)
+	}
+	return hash, nil
+}
+
+func blockHeader(cs *neutrino.ChainService, hash *chainhash.Hash) (*wire.BlockHeader, error) {
+	header, err := cs.GetBlockHeader(hash)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlockHeader(%s): %w", hash, err)
+	}
+	return header, nil
+}
+
+// waitForHeight polls currentHeight at the sync poll interval until it
+// reaches target.
+func waitForHeight(ctx context.Context, o *options, quit <-chan struct{}, currentHeight func() (int32, error), target int32) error {
+	for {
+		select {
+		case <-quit:
+			return ErrClosed
+		default:
+		}
+		height, err := currentHeight()
+		if err != nil {
+			return fmt.Errorf("CurrentHeight: %w", err)
+		}
+		if height >= target {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-quit:
+			return ErrClosed
+		case <-o.clock.After(o.pollInterval()):
+		}
+	}
+}
+
+// WaitForHeight waits until the header chain reaches the target height,
+// checking at the interval set by WithSyncPollInterval. It returns ctx.Err()
+// when ctx is done.
+func (w *Watcher) WaitForHeight(ctx context.Context, target int32) error {
+	return waitForHeight(ctx, w.opts, w.fullClose, w.CurrentHeight, target)
+}
+
+// WaitForHeight waits until the header chain reaches the target height,
+// checking at the interval set by WithSyncPollInterval. It returns ctx.Err()
+// when ctx is done.
+func (w *FullWatcher) WaitForHeight(ctx context.Context, target int32) error {
+	return waitForHeight(ctx, w.opts, w.fullClose, w.CurrentHeight, target)
+}
+
+// GetBlockHash returns the hash of the block at the height in the synced
+// header chain.
+func (w *Watcher) GetBlockHash(height int32) (*chainhash.Hash, error) {
+	return blockHashAt(w.chainService(), height)
+}
+
+// GetBlockHeader returns the header of a block in the synced header chain
+// without downloading the block.
+func (w *Watcher) GetBlockHeader(hash *chainhash.Hash) (*wire.BlockHeader, error) {
+	return blockHeader(w.chainService(), hash)
+}
+
+// GetBlockHash returns the hash of the block at the height in the synced
+// header chain.
+func (w *FullWatcher) GetBlockHash(height int32) (*chainhash.Hash, error) {
+	if w.isClosed() {
+		return nil, ErrClosed
+	}
+	return blockHashAt(w.cs, height)
+}
+
+// GetBlockHeader returns the header of a block in the synced header chain
+// without downloading the block.
+func (w *FullWatcher) GetBlockHeader(hash *chainhash.Hash) (*wire.BlockHeader, error) {
+	if w.isClosed() {
+		return nil, ErrClosed
+	}
+	return blockHeader(w.cs, hash)
+}
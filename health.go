@@ -0,0 +1,142 @@
+package watch
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	healthInterval = 10 * time.Second
+
+	// healthNoPeersTimeout is how long the watcher may have no connected
+	// peers.
+	healthNoPeersTimeout = 30 * time.Second
+
+	// healthStallTimeout is how long the header chain may stay at the same
+	// height while not synced.
+	healthStallTimeout = 2 * time.Minute
+
+	// healthMaxRestarts restarts within healthRestartWindow mean that
+	// rescans keep failing.
+	healthMaxRestarts   = 3
+	healthRestartWindow = 10 * time.Minute
+)
+
+// healthSample is the state of a watcher at one check.
+type healthSample struct {
+	peers    int
+	height   int32
+	synced   bool
+	restarts uint64
+
+	// err is set if the watcher stopped watching for good.
+	err error
+}
+
+// healthChecker tracks samples over time.
+type healthChecker struct {
+	started      bool
+	noPeersSince time.Time
+
+	height      int32
+	heightSince time.Time
+
+	restarts     uint64
+	restartTimes []time.Time
+}
+
+// observe returns why the watcher is unhealthy at now, or "" if it is
+// healthy.
+func (h *healthChecker) observe(now time.Time, s healthSample) string {
+	if !h.started {
+		h.started = true
+		h.height, h.heightSince = s.height, now
+		h.restarts = s.restarts
+	}
+
+	if s.peers > 0 {
+		h.noPeersSince = time.Time{}
+	} else if h.noPeersSince.IsZero() {
+		h.noPeersSince = now
+	}
+	if s.height != h.height || s.synced {
+		h.height, h.heightSince = s.height, now
+	}
+	for ; h.restarts < s.restarts; h.restarts++ {
+		h.restartTimes = append(h.restartTimes, now)
+	}
+	for len(h.restartTimes) > 0 && now.Sub(h.restartTimes[0]) > healthRestartWindow {
+		h.restartTimes = h.restartTimes[1:]
+	}
+
+	// Reasons do not change while the condition lasts, so the callback is
+	// not called again.
+	switch {
+	case s.err != nil:
+		return fmt.Sprintf("watching stopped: %v", s.err)
+	case !h.noPeersSince.IsZero() && now.Sub(h.noPeersSince) >= healthNoPeersTimeout:
+		return fmt.Sprintf("no peers for %s", healthNoPeersTimeout)
+	case now.Sub(h.heightSince) >= healthStallTimeout:
+		return fmt.Sprintf("not synced and stuck at height %d for %s", h.height, healthStallTimeout)
+	case len(h.restartTimes) >= healthMaxRestarts:
+		return fmt.Sprintf("%d restarts within %s", healthMaxRestarts, healthRestartWindow)
+	}
+	return ""
+}
+
+// watchHealth samples the watcher every healthInterval until quit is closed
+// and calls the callbacks set by WithHealthCallbacks when health changes.
+func watchHealth(o *options, quit <-chan struct{}, sample func() healthSample) {
+	h := &healthChecker{}
+	var reason string
+	for {
+		select {
+		case <-quit:
+			return
+		case <-o.clock.After(healthInterval):
+		}
+		newReason := h.observe(o.clock.Now(), sample())
+		if newReason == reason {
+			continue
+		}
+		reason = newReason
+		if reason == "" {
+			o.logInfo("Watcher is healthy again")
+			if o.onHealthy != nil {
+				o.onHealthy()
+			}
+			continue
+		}
+		o.logWarn("Watcher is unhealthy", "reason", reason)
+		if o.onUnhealthy != nil {
+			o.onUnhealthy(reason)
+		}
+	}
+}
+
+func (w *Watcher) healthSample() healthSample {
+	stats := w.Stats()
+	w.mu.Lock()
+	err := w.restartErr
+	w.mu.Unlock()
+	return healthSample{
+		peers:    stats.Peers,
+		height:   stats.Height,
+		synced:   stats.Synced,
+		restarts: stats.Restarts,
+		err:      err,
+	}
+}
+
+func (w *FullWatcher) healthSample() healthSample {
+	s := healthSample{synced: w.cs.IsCurrent()}
+	if best, err := w.cs.BestBlock(); err == nil {
+		s.height = best.Height
+	}
+	for _, sp := range w.cs.Peers() {
+		if sp.Connected() {
+			s.peers++
+		}
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package watch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	h := &healthChecker{}
+	steps := []struct {
+		after  time.Duration
+		sample healthSample
+		want   string
+	}{
+		{after: 0, sample: healthSample{peers: 0, height: 10}, want: ""},
+		{after: 20 * time.Second, sample: healthSample{peers: 0, height: 11}, want: ""},
+		{after: 30 * time.Second, sample: healthSample{peers: 0, height: 12}, want: "no peers for 30s"},
+		{after: 40 * time.Second, sample: healthSample{peers: 2, height: 13}, want: ""},
+		// Stalled while not synced.
+		{after: 2*time.Minute + 30*time.Second, sample: healthSample{peers: 2, height: 13}, want: ""},
+		{after: 2*time.Minute + 40*time.Second, sample: healthSample{peers: 2, height: 13}, want: "not synced and stuck at height 13 for 2m0s"},
+		// The same height is fine when synced.
+		{after: 3 * time.Minute, sample: healthSample{peers: 2, height: 13, synced: true}, want: ""},
+		{after: 6 * time.Minute, sample: healthSample{peers: 2, height: 13, synced: true}, want: ""},
+		// Restarts.
+		{after: 7 * time.Minute, sample: healthSample{peers: 2, height: 14, synced: true, restarts: 2}, want: ""},
+		{after: 8 * time.Minute, sample: healthSample{peers: 2, height: 14, synced: true, restarts: 3}, want: "3 restarts within 10m0s"},
+		{after: 18 * time.Minute, sample: healthSample{peers: 2, height: 15, synced: true, restarts: 3}, want: ""},
+		{after: 19 * time.Minute, sample: healthSample{peers: 2, height: 15, synced: true, restarts: 3, err: errors.New("boom")}, want: "watching stopped: boom"},
+	}
+	for i, s := range steps {
+		if got := h.observe(start.Add(s.after), s.sample); got != s.want {
+			t.Errorf("Step %d: observe returned %q, want %q.", i, got, s.want)
+		}
+	}
+}
+
+func TestWatchHealth(t *testing.T) {
+	var calls []string
+	o := newOptions([]Option{
+		WithLogger(&testLogger{}),
+		WithHealthCallbacks(func(reason string) {
+			calls = append(calls, "unhealthy: "+reason)
+		}, func() {
+			calls = append(calls, "healthy")
+		}),
+	})
+	o.clock = &fakeClock{now: time.Unix(1600000000, 0)}
+
+	// Four checks without peers, then peers forever.
+	quit := make(chan struct{})
+	var n int
+	sample := func() healthSample {
+		n++
+		if n <= 4 {
+			return healthSample{synced: true}
+		}
+		if n == 6 {
+			close(quit)
+		}
+		return healthSample{peers: 1, synced: true}
+	}
+	watchHealth(o, quit, sample)
+
+	want := []string{"unhealthy: no peers for 30s", "healthy"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Callbacks were %q, want %q.", calls, want)
+	}
+}
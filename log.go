@@ -1,12 +1,76 @@
 package watch
 
 import (
+	"fmt"
+	"log"
 	"os"
+	"strings"
 
 	"github.com/btcsuite/btclog"
 	"github.com/lightninglabs/neutrino"
 )
 
+// Logger receives log messages of a watcher. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// StructuredLogger receives log records with key/value pairs, such as
+// "height", "hash", "txid" and "err". *slog.Logger implements it.
+type StructuredLogger interface {
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// formatRecord renders a record for a Logger as "msg: key=value ...".
+func formatRecord(msg string, kv []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i == 0 {
+			b.WriteByte(':')
+		}
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	b.WriteByte('.')
+	return b.String()
+}
+
+func (o *options) logInfo(msg string, kv ...interface{}) {
+	if o.structuredLogger != nil {
+		o.structuredLogger.Info(msg, kv...)
+		return
+	}
+	o.logger.Printf("%s", formatRecord(msg, kv))
+}
+
+func (o *options) logWarn(msg string, kv ...interface{}) {
+	if o.structuredLogger != nil {
+		o.structuredLogger.Warn(msg, kv...)
+		return
+	}
+	o.logger.Printf("%s", formatRecord(msg, kv))
+}
+
+func (o *options) logError(msg string, kv ...interface{}) {
+	if o.structuredLogger != nil {
+		o.structuredLogger.Error(msg, kv...)
+		return
+	}
+	o.logger.Printf("%s", formatRecord(msg, kv))
+}
+
+// stdLogger logs to the standard logger.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// EnableNeutrinoLogs sets the logger of neutrino, which is global, so logs of
+// all watchers in the process are mixed. Logs of the watchers themselves are
+// per instance, see WithLogger and WithStructuredLogger.
 func EnableNeutrinoLogs(prefix string, level btclog.Level) {
 	logger := btclog.NewBackend(os.Stdout)
 	chainLogger := logger.Logger(prefix)
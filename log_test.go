@@ -0,0 +1,51 @@
+package watch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type record struct {
+	level, msg string
+	kv         []interface{}
+}
+
+type testStructuredLogger struct {
+	records []record
+}
+
+func (l *testStructuredLogger) Info(msg string, args ...interface{}) {
+	l.records = append(l.records, record{"info", msg, args})
+}
+
+func (l *testStructuredLogger) Warn(msg string, args ...interface{}) {
+	l.records = append(l.records, record{"warn", msg, args})
+}
+
+func (l *testStructuredLogger) Error(msg string, args ...interface{}) {
+	l.records = append(l.records, record{"error", msg, args})
+}
+
+func TestStructuredLogger(t *testing.T) {
+	err := errors.New("boom")
+
+	structured := &testStructuredLogger{}
+	printf := &testLogger{}
+	o := newOptions([]Option{WithLogger(printf), WithStructuredLogger(structured)})
+	o.logWarn("Soft restart failed", "height", int32(5), "err", err)
+	want := []record{{"warn", "Soft restart failed", []interface{}{"height", int32(5), "err", err}}}
+	if !reflect.DeepEqual(structured.records, want) {
+		t.Errorf("Structured records are %v, want %v.", structured.records, want)
+	}
+	if len(printf.lines) != 0 {
+		t.Errorf("Printf logger got %q, want nothing.", printf.lines)
+	}
+
+	o = newOptions([]Option{WithLogger(printf)})
+	o.logWarn("Soft restart failed", "height", int32(5), "err", err)
+	o.logInfo("Checkpoint verified")
+	if want := []string{"Soft restart failed: height=5 err=boom.", "Checkpoint verified."}; !reflect.DeepEqual(printf.lines, want) {
+		t.Errorf("Printf lines are %q, want %q.", printf.lines, want)
+	}
+}
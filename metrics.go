@@ -0,0 +1,69 @@
+//go:build prometheus
+// +build prometheus
+
+package watch
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// This file is built with -tags prometheus, so the library does not import
+// client_golang otherwise. go.mod requires it to build and test the file.
+
+type metricsCollector struct {
+	w *Watcher
+
+	height, synced, peers, addresses, watching, restarts *prometheus.Desc
+}
+
+func newMetricsCollector(w *Watcher) *metricsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("watch", "", name), help, nil, nil)
+	}
+	return &metricsCollector{
+		w:         w,
+		height:    desc("height", "Height of the best block header."),
+		synced:    desc("synced", "1 if block headers are synced."),
+		peers:     desc("connected_peers", "Number of connected peers."),
+		addresses: desc("watched_addresses", "Number of watched addresses."),
+		watching:  desc("watching", "1 if the rescan started by StartWatching is running."),
+		restarts:  desc("restarts_total", "Soft and hard restarts of the chain service."),
+	}
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.height
+	ch <- c.synced
+	ch <- c.peers
+	ch <- c.addresses
+	ch <- c.watching
+	ch <- c.restarts
+}
+
+// Collect takes one Stats snapshot per scrape.
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.w.Stats()
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+	}
+	gauge(c.height, float64(stats.Height))
+	gauge(c.synced, boolFloat(stats.Synced))
+	gauge(c.peers, float64(stats.Peers))
+	gauge(c.addresses, float64(stats.Addresses))
+	gauge(c.watching, boolFloat(stats.Watching))
+	ch <- prometheus.MustNewConstMetric(c.restarts, prometheus.CounterValue, float64(stats.Restarts))
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// RegisterMetrics registers gauges of Stats and a restart counter, named
+// watch_*. To register several watchers, give each its own labels with
+// prometheus.WrapRegistererWith.
+func (w *Watcher) RegisterMetrics(registerer prometheus.Registerer) error {
+	return registerer.Register(newMetricsCollector(w))
+}
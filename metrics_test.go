@@ -0,0 +1,67 @@
+//go:build prometheus
+// +build prometheus
+
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+	if err := watcher.AddAddresses("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := watcher.RegisterMetrics(registry); err != nil {
+		t.Fatalf("RegisterMetrics: %v.", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v.", err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		if gauge := metric.GetGauge(); gauge != nil {
+			values[family.GetName()] = gauge.GetValue()
+		} else {
+			values[family.GetName()] = metric.GetCounter().GetValue()
+		}
+	}
+	want := map[string]float64{
+		"watch_height":            0,
+		"watch_synced":            0,
+		"watch_connected_peers":   0,
+		"watch_watched_addresses": 1,
+		"watch_watching":          0,
+		"watch_restarts_total":    0,
+	}
+	for name, value := range want {
+		got, has := values[name]
+		if !has {
+			t.Errorf("Metric %s is not registered.", name)
+		} else if got != value {
+			t.Errorf("Metric %s is %v, want %v.", name, got, value)
+		}
+	}
+
+	// A second watcher needs its own labels.
+	if err := watcher.RegisterMetrics(registry); err == nil {
+		t.Errorf("Registering the same metrics twice succeeded.")
+	}
+}
@@ -0,0 +1,174 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightninglabs/neutrino"
+)
+
+// networkMarkerFile stores the name of the network the directory was created
+// for, so that the directory is not reused for another network by mistake.
+const networkMarkerFile = "network"
+
+func readNetworkMarker(dir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, networkMarkerFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// checkNetworkMarker creates the marker in a new directory or checks that an
+// existing marker matches params.
+func checkNetworkMarker(dir string, params *chaincfg.Params) error {
+	name, err := readNetworkMarker(dir)
+	if os.IsNotExist(err) {
+		markerFile := filepath.Join(dir, networkMarkerFile)
+		if err := ioutil.WriteFile(markerFile, []byte(params.Name+"\n"), 0600); err != nil {
+			return fmt.Errorf("WriteFile: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("readNetworkMarker: %w", err)
+	}
+	if name != params.Name {
+		return fmt.Errorf("directory %s is for network %s, not %s", dir, name, params.Name)
+	}
+	return nil
+}
+
+// chainReset reports whether headers stored up to tipHeight contradict the
+// genesis block or a checkpoint of params, i.e. they are of a chain which
+// was reset, and returns the height of the first mismatch. blockHash looks
+// up a stored header. The age of the tip does not matter, so a watcher which
+// was offline for long keeps its data.
+func chainReset(params *chaincfg.Params, tipHeight int32, blockHash func(height int64) (*chainhash.Hash, error)) (int32, bool, error) {
+	check := func(height int32, want *chainhash.Hash) (bool, error) {
+		hash, err := blockHash(int64(height))
+		if err != nil {
+			return false, fmt.Errorf("GetBlockHash(%d) failed: %w", height, err)
+		}
+		return *hash != *want, nil
+	}
+	if mismatch, err := check(0, params.GenesisHash); mismatch || err != nil {
+		return 0, mismatch, err
+	}
+	for _, c := range params.Checkpoints {
+		if c.Height > tipHeight {
+			break
+		}
+		if mismatch, err := check(c.Height, c.Hash); mismatch || err != nil {
+			return c.Height, mismatch, err
+		}
+	}
+	return 0, false, nil
+}
+
+// peerHeights returns heights of the best blocks announced by peers, zero
+// for peers which have not reported it yet.
+func peerHeights(cs *neutrino.ChainService) []int32 {
+	var heights []int32
+	for _, sp := range cs.Peers() {
+		heights = append(heights, sp.LastBlock())
+	}
+	return heights
+}
+
+// syncProgress computes arguments of SyncProgressCallback.
+func syncProgress(current int32, peerHeights []int32) (target int32, percent float64) {
+	target = -1
+	for _, height := range peerHeights {
+		if height > target {
+			target = height
+		}
+	}
+	if target <= 0 {
+		return -1, -1
+	}
+	percent = 100 * float64(current) / float64(target)
+	if percent > 100 {
+		percent = 100
+	}
+	return target, percent
+}
+
+func reportSyncProgress(cs *neutrino.ChainService, o *options, current int32) {
+	if o.syncProgress == nil {
+		return
+	}
+	target, percent := syncProgress(current, peerHeights(cs))
+	o.syncProgress(current, target, percent)
+}
+
+// Network is a bitcoin network a watcher can follow.
+//
+// Testnet4 addresses have the same format as testnet3 ones, so
+// PrepareTxOutputs(tx, true) decodes them. Its headers follow BIP 94
+// difficulty rules, which neutrino checks only partly, see testnet4.go.
+type Network int
+
+const (
+	Mainnet Network = iota
+	Testnet3
+	Signet
+	Regtest
+	Simnet
+	Testnet4
+)
+
+// boolNetwork maps the testnet flag of older constructors to a Network.
+func boolNetwork(testnet bool) Network {
+	if testnet {
+		return Testnet3
+	}
+	return Mainnet
+}
+
+// Params returns chain parameters of the network.
+func (n Network) Params() (*chaincfg.Params, error) {
+	switch n {
+	case Mainnet:
+		return &chaincfg.MainNetParams, nil
+	case Testnet3:
+		return &chaincfg.TestNet3Params, nil
+	case Signet:
+		return &signetParams, nil
+	case Regtest:
+		return &chaincfg.RegressionNetParams, nil
+	case Simnet:
+		return &chaincfg.SimNetParams, nil
+	case Testnet4:
+		return &testNet4Params, nil
+	}
+	return nil, fmt.Errorf("unknown network %d", int(n))
+}
+
+func (n Network) String() string {
+	params, err := n.Params()
+	if err != nil {
+		return fmt.Sprintf("Network(%d)", int(n))
+	}
+	return params.Name
+}
+
+// DefaultPeers returns known peers serving cfilters on the network. It is nil
+// for networks without public peers, where neutrino uses DNS seeds only.
+// The result is a copy, which the caller may modify.
+func DefaultPeers(n Network) []string {
+	switch n {
+	case Mainnet:
+		return append([]string(nil), MainNetPeers...)
+	case Testnet3:
+		return append([]string(nil), TestNet3Peers...)
+	case Testnet4:
+		return append([]string(nil), TestNet4Peers...)
+	}
+	return nil
+}
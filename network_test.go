@@ -0,0 +1,87 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestNetworkParams(t *testing.T) {
+	for network, name := range map[Network]string{
+		Mainnet:  "mainnet",
+		Testnet3: "testnet3",
+		Signet:   "signet",
+		Regtest:  "regtest",
+		Simnet:   "simnet",
+		Testnet4: "testnet4",
+	} {
+		params, err := network.Params()
+		if err != nil {
+			t.Fatalf("Params(%d): %v.", network, err)
+		}
+		if params.Name != name || network.String() != name {
+			t.Errorf("network %d is %s, want %s.", network, params.Name, name)
+		}
+	}
+	if _, err := Network(100).Params(); err == nil {
+		t.Errorf("Params of an unknown network succeeded.")
+	}
+
+	// See chainparams.cpp of Bitcoin Core.
+	want, _ := chainhash.NewHashFromStr("00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6")
+	if got := signetParams.GenesisBlock.BlockHash(); got != *want || *signetParams.GenesisHash != *want {
+		t.Errorf("signet genesis is %s, want %s.", got, want)
+	}
+	want, _ = chainhash.NewHashFromStr("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043")
+	if got := testNet4Params.GenesisBlock.BlockHash(); got != *want || *testNet4Params.GenesisHash != *want {
+		t.Errorf("testnet4 genesis is %s, want %s.", got, want)
+	}
+	root, _ := chainhash.NewHashFromStr("7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e")
+	if got := testNet4Params.GenesisBlock.Header.MerkleRoot; got != *root {
+		t.Errorf("testnet4 genesis merkle root is %s, want %s.", got, root)
+	}
+}
+
+func TestTestnet4Addresses(t *testing.T) {
+	const addr = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	a, err := btcutil.DecodeAddress(addr, &testNet4Params)
+	if err != nil {
+		t.Fatalf("DecodeAddress: %v.", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	tx := btcutil.NewTx(msgTx)
+	if got := PrepareTxOutputsWithParams(tx, &testNet4Params); got[addr] != 1000 {
+		t.Errorf("PrepareTxOutputsWithParams for testnet4 = %v, want 1000 to %s.", got, addr)
+	}
+	if got := PrepareTxOutputs(tx, true); got[addr] != 1000 {
+		t.Errorf("PrepareTxOutputs for testnet = %v, want 1000 to %s.", got, addr)
+	}
+}
+
+func TestSyncProgress(t *testing.T) {
+	cases := []struct {
+		current     int32
+		peerHeights []int32
+		target      int32
+		percent     float64
+	}{
+		{current: 100, peerHeights: nil, target: -1, percent: -1},
+		{current: 100, peerHeights: []int32{0, 0}, target: -1, percent: -1},
+		{current: 100, peerHeights: []int32{0, 400, 200}, target: 400, percent: 25},
+		{current: 500, peerHeights: []int32{400}, target: 400, percent: 100},
+	}
+	for _, tc := range cases {
+		target, percent := syncProgress(tc.current, tc.peerHeights)
+		if target != tc.target || percent != tc.percent {
+			t.Errorf("syncProgress(%d, %v) = %d, %v, want %d, %v.", tc.current, tc.peerHeights, target, percent, tc.target, tc.percent)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package watch
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/neutrino"
+)
+
+// Option configures a Watcher or a FullWatcher.
+type Option func(*options)
+
+type options struct {
+	blockCacheSize  uint64
+	filterCacheSize uint64
+	txCacheSize     int
+	eventHandler    func(Event)
+	sinks           []Sink
+	noFreelistSync  bool
+	dbOpenTimeout   time.Duration
+
+	userAgentName    string
+	userAgentVersion string
+
+	retention       int32
+	compactInterval time.Duration
+
+	disableAutoRestart bool
+
+	blockStream       bool
+	blockStreamBuffer int
+	blockStreamDrop   bool
+
+	onConfirmed func(TxConfirmation)
+
+	onDeriveAddress func(chain, index uint32, addr string) bool
+
+	clock clock
+
+	defaultConfirmations int32
+
+	tipHint               func() (int32, error)
+	tipHintThreshold      int32
+	onPossibleForkedChain func(ourHeight, hintHeight int32)
+
+	// querySem limits concurrent chain queries if not nil.
+	querySem chan struct{}
+
+	onBlockProcessed func(height int32, hash chainhash.Hash)
+
+	checkpoint *checkpoint
+
+	downloads *downloadCounters
+
+	syncPollInterval time.Duration
+
+	logger           Logger
+	structuredLogger StructuredLogger
+
+	dbFileName, dataSubdir string
+
+	downloadWorkers int
+
+	syncProgress SyncProgressCallback
+
+	hardRestartAfter int
+
+	subscribeBuffer int
+	subscribeDrop   bool
+
+	// minConfirmations is set by WithMinConfirmations if gateBlocks.
+	gateBlocks       bool
+	minConfirmations int32
+
+	maxPeers int
+	dnsSeeds bool
+
+	retryMin, retryMax time.Duration
+
+	stallPolls int
+
+	tor TorConfig
+
+	onUnhealthy func(reason string)
+	onHealthy   func()
+
+	onMatchedBlock func(height int32, block *btcutil.Block)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		blockCacheSize:  neutrino.DefaultBlockCacheSize,
+		filterCacheSize: neutrino.DefaultFilterCacheSize,
+		txCacheSize:     10,
+		noFreelistSync:  true,
+		clock:           realClock{},
+		downloads:       &downloadCounters{},
+		logger:          stdLogger{},
+		dbFileName:      defaultDBFileName,
+		dataSubdir:      defaultDataSubdir,
+
+		defaultConfirmations: defaultConfirmations,
+		hardRestartAfter:     3,
+		subscribeBuffer:      defaultSubscribeBuffer,
+		retryMin:             time.Second,
+		retryMax:             time.Minute,
+		stallPolls:           defaultStallPolls,
+		tor:                  defaultTorConfig,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithBlockCacheSize sets the maximum size in bytes of neutrino's block cache.
+func WithBlockCacheSize(size uint64) Option {
+	return func(o *options) {
+		o.blockCacheSize = size
+	}
+}
+
+// WithFilterCacheSize sets the maximum size in bytes of neutrino's filter
+// cache.
+func WithFilterCacheSize(size uint64) Option {
+	return func(o *options) {
+		o.filterCacheSize = size
+	}
+}
+
+// WithTxCacheSize sets the number of recently fetched blocks kept by the
+// watcher to look up their transactions without downloading them again.
+// Zero disables the cache.
+func WithTxCacheSize(blocks int) Option {
+	return func(o *options) {
+		o.txCacheSize = blocks
+	}
+}
+
+// WithEventHandler sets a handler called for each relevant transaction after
+// OnFilteredBlockConnected, with metadata of the transaction.
+func WithEventHandler(handler func(Event)) Option {
+	return func(o *options) {
+		o.eventHandler = handler
+	}
+}
+
+// WithSinks routes events to sinks with at-least-once semantics. A failed
+// delivery is retried with backoff and blocks processing of further blocks,
+// so the watcher never moves past an undelivered event. Events may be
+// delivered again, e.g. after a restart, so sinks must tolerate duplicates.
+func WithSinks(sinks ...Sink) Option {
+	return func(o *options) {
+		o.sinks = append(o.sinks, sinks...)
+	}
+}
+
+// WithNoFreelistSync sets whether the database skips syncing its freelist to
+// disk, which is the default. Skipping makes writes faster, especially on
+// spinning disks, but after a crash the freelist is rebuilt by scanning the
+// whole file on open. Pass false for the safer mode, where every commit also
+// writes the freelist, e.g. on servers where a slow or failed recovery costs
+// more than the slower sync. An existing database can be opened in either
+// mode. The option has no effect on a db passed to NewWithDB.
+func WithNoFreelistSync(noFreelistSync bool) Option {
+	return func(o *options) {
+		o.noFreelistSync = noFreelistSync
+	}
+}
+
+// WithDBOpenTimeout sets how long to wait for the database file lock, e.g.
+// held by another process. By default it waits forever.
+func WithDBOpenTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.dbOpenTimeout = timeout
+	}
+}
+
+// WithUserAgent sets the user agent advertised to peers. Empty name or version
+// keeps neutrino's default.
+func WithUserAgent(name, version string) Option {
+	return func(o *options) {
+		o.userAgentName = name
+		o.userAgentVersion = version
+	}
+}
+
+// WithRetention sets how many recent blocks keep their matched transactions
+// in the database. Older records are removed by Compact, so Replay can not
+// deliver them anymore. By default records are kept forever.
+func WithRetention(blocks int32) Option {
+	return func(o *options) {
+		o.retention = blocks
+	}
+}
+
+// WithAutoCompact runs Compact periodically. It has no effect without
+// WithRetention.
+func WithAutoCompact(interval time.Duration) Option {
+	return func(o *options) {
+		o.compactInterval = interval
+	}
+}
+
+// WithDisableAutoRestart stops the watcher instead of wiping its chain data
+// and restarting on a sync stall or rescan error, leaving recovery to the
+// caller. Afterwards WaitForSync and Add* methods return ErrNeedsRestart.
+func WithDisableAutoRestart(disable bool) Option {
+	return func(o *options) {
+		o.disableAutoRestart = disable
+	}
+}
+
+// WithBlockStream makes FullWatcher send blocks to the channel returned by
+// Blocks, buffering up to buffer blocks. When the buffer is full, a block is
+// dropped if drop is true, otherwise the watcher waits for the consumer.
+func WithBlockStream(buffer int, drop bool) Option {
+	return func(o *options) {
+		o.blockStream = true
+		o.blockStreamBuffer = buffer
+		o.blockStreamDrop = drop
+	}
+}
+
+// WithMatchedBlockCallback makes Watcher pass full blocks with relevant
+// transactions to callback before the handlers, so that they need not call
+// GetBlock. The rescan has just downloaded such blocks, so they are taken from
+// the cache set by WithTxCacheSize; if it is disabled, no blocks are passed.
+// Other blocks are not passed either. Blocks are passed as they connect, not
+// delayed by WithMinConfirmations, so a block may be passed and then be
+// disconnected before the handlers see it. FullWatcher takes blockCallback
+// instead.
+func WithMatchedBlockCallback(callback func(height int32, block *btcutil.Block)) Option {
+	return func(o *options) {
+		o.onMatchedBlock = callback
+	}
+}
+
+// WithOnConfirmed sets a handler called when a tx watched by
+// WatchConfirmations reaches its target.
+func WithOnConfirmed(handler func(TxConfirmation)) Option {
+	return func(o *options) {
+		o.onConfirmed = handler
+	}
+}
+
+// WithOnDeriveAddress sets a callback called before each address derived
+// automatically to extend the gap limit is watched. The address is at path
+// chain/index, where chain is 0 for receiving and 1 for change addresses.
+// Returning false vetoes the address and stops extending the gap limit of its
+// chain.
+func WithOnDeriveAddress(callback func(chain, index uint32, addr string) bool) Option {
+	return func(o *options) {
+		o.onDeriveAddress = callback
+	}
+}
+
+// approveDerived reports whether a derived address may be watched.
+func (o *options) approveDerived(chain, index uint32, addr string) bool {
+	if o.onDeriveAddress == nil {
+		return true
+	}
+	return o.onDeriveAddress(chain, index, addr)
+}
+
+const defaultConfirmations = 6
+
+// WithDefaultConfirmations sets the number of confirmations considered final
+// where a method is not given one explicitly. It is 6 by default.
+func WithDefaultConfirmations(confirmations int32) Option {
+	return func(o *options) {
+		o.defaultConfirmations = confirmations
+	}
+}
+
+// confirmations returns explicit if it is positive or the default otherwise.
+func (o *options) confirmations(explicit int32) int32 {
+	if explicit > 0 {
+		return explicit
+	}
+	return o.defaultConfirmations
+}
+
+// WithTipHint makes the watcher compare its tip every minute with the height
+// returned by hint, e.g. from a block explorer. If our tip stays behind by
+// more than threshold blocks for several checks, onForked is called, because
+// peers may be isolating us on a stale or minority chain.
+func WithTipHint(hint func() (int32, error), threshold int32, onForked func(ourHeight, hintHeight int32)) Option {
+	return func(o *options) {
+		o.tipHint = hint
+		o.tipHintThreshold = threshold
+		o.onPossibleForkedChain = onForked
+	}
+}
+
+// WithMaxConcurrentQueries limits the number of block and filter queries to
+// peers which the watcher runs at the same time, e.g. to spare a slow Tor
+// connection. The limit covers queries of the rescan and of RescanRange too,
+// but not header sync and queries neutrino makes on its own, e.g. to verify
+// filter headers. Zero means no limit.
+func WithMaxConcurrentQueries(n int) Option {
+	return func(o *options) {
+		o.querySem = nil
+		if n > 0 {
+			o.querySem = make(chan struct{}, n)
+		}
+	}
+}
+
+// acquireQuery waits until a chain query may run and returns a function
+// releasing it. It returns ErrClosed if quit is closed and ctx.Err() if ctx
+// is done first.
+func (o *options) acquireQuery(ctx context.Context, quit <-chan struct{}) (func(), error) {
+	if o.querySem == nil {
+		return func() {}, nil
+	}
+	select {
+	case o.querySem <- struct{}{}:
+	case <-quit:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-o.querySem
+	}, nil
+}
+
+// WithOnBlockProcessed sets a callback called after each connected block is
+// processed, whether it has relevant transactions or not, e.g. to advance the
+// caller's cursor.
+func WithOnBlockProcessed(callback func(height int32, hash chainhash.Hash)) Option {
+	return func(o *options) {
+		o.onBlockProcessed = callback
+	}
+}
+
+// WithCheckpoint sets a block known to be on the chain. WaitForSync fails
+// with ErrCheckpointMismatch as soon as headers from peers reach the height
+// with another block, instead of syncing onto a wrong chain. Restarts and
+// blocks delivered at the height are checked as well: on a mismatch the
+// Watcher stops and CaughtUp returns an error wrapping ErrCheckpointMismatch,
+// and the FullWatcher stops delivering blocks.
+func WithCheckpoint(height int32, hash chainhash.Hash) Option {
+	return func(o *options) {
+		o.checkpoint = &checkpoint{height: height, hash: hash}
+	}
+}
+
+const defaultSyncPollInterval = 10 * time.Second
+
+// WithSyncPollInterval sets how often WaitForSync checks sync progress, and
+// WaitForFilters and StartWatchingAndWait check theirs. It is 10 seconds by
+// default and if zero.
+func WithSyncPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.syncPollInterval = interval
+	}
+}
+
+func (o *options) pollInterval() time.Duration {
+	if o.syncPollInterval <= 0 {
+		return defaultSyncPollInterval
+	}
+	return o.syncPollInterval
+}
+
+// WithLogger routes log messages of the watcher to logger instead of the
+// standard logger. Neutrino's own logs are set by EnableNeutrinoLogs for the
+// whole process.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Default names of the database file and neutrino's data directory in the
+// directory passed to New.
+const (
+	defaultDBFileName = "wallet.db"
+	defaultDataSubdir = "data"
+)
+
+// WithDBFileName sets the name of the database file in the directory, so
+// several watchers can share one directory. It is wallet.db by default.
+func WithDBFileName(name string) Option {
+	return func(o *options) {
+		o.dbFileName = name
+	}
+}
+
+// WithDataSubdir sets the name of the subdirectory with neutrino's headers,
+// data by default. With a custom name, the network marker is kept in the
+// subdirectory instead of the directory, so several watchers of different
+// networks can share one directory given distinct names and database files.
+func WithDataSubdir(name string) Option {
+	return func(o *options) {
+		o.dataSubdir = name
+	}
+}
+
+func (o *options) dbFile(dir string) string {
+	return filepath.Join(dir, o.dbFileName)
+}
+
+func (o *options) dataDir(dir string) string {
+	return filepath.Join(dir, o.dataSubdir)
+}
+
+// markerDir returns the directory of the network marker. It is dir for the
+// default layout, which existing directories have.
+func (o *options) markerDir(dir string) string {
+	if o.dataSubdir == defaultDataSubdir {
+		return dir
+	}
+	return o.dataDir(dir)
+}
+
+// WithStructuredLogger routes log messages of the watcher to logger, with
+// values such as heights and errors as key/value pairs. It takes precedence
+// over WithLogger. Neutrino's logs are not affected.
+func WithStructuredLogger(logger StructuredLogger) Option {
+	return func(o *options) {
+		o.structuredLogger = logger
+	}
+}
+
+// WithDownloadWorkers makes FullWatcher download up to n blocks at the same
+// time, e.g. to speed up a backfill. Handlers are still called in order of
+// heights. It is 1 by default.
+func WithDownloadWorkers(n int) Option {
+	return func(o *options) {
+		o.downloadWorkers = n
+	}
+}
+
+// SyncProgressCallback receives the height of the best block header and the
+// best height reported by peers with the percentage of it. Both target and
+// percent are -1 if no peer reported its height yet.
+type SyncProgressCallback func(current, target int32, percent float64)
+
+// WithSyncProgress sets a callback called by WaitForSync on each poll, e.g. to
+// show a progress bar.
+func WithSyncProgress(callback SyncProgressCallback) Option {
+	return func(o *options) {
+		o.syncProgress = callback
+	}
+}
+
+// WithHardRestartAfter sets how many restarts in a row keep the database,
+// restarting only the chain service, before the watcher wipes it and syncs
+// from scratch. It is 3 by default. Zero wipes the database on each restart.
+func WithHardRestartAfter(n int) Option {
+	return func(o *options) {
+		o.hardRestartAfter = n
+	}
+}
+
+// WithSubscribeBuffer sets the buffer of channels returned by Subscribe, 100
+// blocks by default. When the buffer is full, an event is dropped if drop is
+// true, otherwise the watcher waits for the consumer.
+func WithSubscribeBuffer(buffer int, drop bool) Option {
+	return func(o *options) {
+		o.subscribeBuffer = buffer
+		o.subscribeDrop = drop
+	}
+}
+
+// WithMinConfirmations makes Watcher call OnFilteredBlockConnected of the
+// handlers passed to StartWatching only when the block has n confirmations,
+// i.e. n-1 blocks are mined on top of it, or params.CoinbaseMaturity if it
+// has a relevant coinbase tx. Blocks disconnected before that are never
+// delivered, and neither are their disconnects. A restarted rescan starts
+// below blocks which were held, so none is lost. Other notifications are not
+// delayed. Zero means DefaultConfirmations, see WithDefaultConfirmations, and
+// 1 delivers blocks as they are connected.
+func WithMinConfirmations(n int32) Option {
+	return func(o *options) {
+		o.gateBlocks = true
+		o.minConfirmations = n
+	}
+}
+
+// blockConfirmations returns the confirmations blocks need to be delivered
+// to handlers, see WithMinConfirmations.
+func (o *options) blockConfirmations() int32 {
+	if !o.gateBlocks {
+		return 1
+	}
+	return o.confirmations(o.minConfirmations)
+}
+
+// WithMaxPeers limits outbound connections to peers which neutrino discovers
+// through DNS seeds, e.g. to spare resources over Tor. Zero keeps neutrino's
+// default of 8. Peers passed to New are connected regardless and disable
+// discovery unless WithDNSSeeds is used, so then the option has no effect.
+func WithMaxPeers(n int) Option {
+	return func(o *options) {
+		o.maxPeers = n
+	}
+}
+
+// WithDNSSeeds makes neutrino discover peers through the DNS seeds of the
+// network in addition to the peers passed to New. By default only those peers
+// are connected to, which is reproducible but depends on a few hosts. Without
+// peers, e.g. in FullWatcher, DNS seeds are always used.
+func WithDNSSeeds(enable bool) Option {
+	return func(o *options) {
+		o.dnsSeeds = enable
+	}
+}
+
+// WithRetryBackoff sets the delays between retries of failed block downloads
+// of FullWatcher and between restarts in a row of Watcher. The delay starts
+// at initial and doubles up to max, with random jitter. It resets after a
+// block is processed. It is 1 second to 1 minute by default.
+func WithRetryBackoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.retryMin = initial
+		o.retryMax = max
+	}
+}
+
+// retryDelay returns the delay before retry attempt, starting from 0: a random
+// duration between half and all of the exponential delay.
+func (o *options) retryDelay(attempt int) time.Duration {
+	delay := o.retryMin
+	for i := 0; i < attempt && delay < o.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > o.retryMax {
+		delay = o.retryMax
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+const defaultStallPolls = 6
+
+// WithStallPolls sets how many consecutive polls of WaitForSync, see
+// WithSyncPollInterval, may see no progress of block and filter headers
+// before Watcher restarts. It is 6 by default and if zero or less, i.e. a
+// minute at the default poll interval.
+func WithStallPolls(polls int) Option {
+	return func(o *options) {
+		if polls <= 0 {
+			polls = defaultStallPolls
+		}
+		o.stallPolls = polls
+	}
+}
+
+// WithHealthCallbacks checks the watcher every 10 seconds and calls
+// onUnhealthy when it becomes unhealthy or the reason changes, and onHealthy
+// when it recovers. Either may be nil. The watcher is unhealthy if:
+//   - it stopped watching after a failed restart (Watcher only),
+//   - no peers have been connected for 30 seconds,
+//   - headers are not synced and the height has not changed for 2 minutes,
+//   - it restarted 3 times within 10 minutes (Watcher only).
+func WithHealthCallbacks(onUnhealthy func(reason string), onHealthy func()) Option {
+	return func(o *options) {
+		o.onUnhealthy = onUnhealthy
+		o.onHealthy = onHealthy
+	}
+}
+
+// healthChecks reports whether the health monitor should run.
+func (o *options) healthChecks() bool {
+	return o.onUnhealthy != nil || o.onHealthy != nil
+}
@@ -0,0 +1,122 @@
+package watch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// pauser queues handler calls while the watcher is paused. The zero value
+// is not paused.
+type pauser struct {
+	mu       sync.Mutex
+	paused   bool
+	draining bool
+	queue    []func()
+}
+
+// call runs f or queues it if paused or if the queue is being drained, to
+// keep the order.
+func (p *pauser) call(f func()) {
+	p.mu.Lock()
+	if p.paused || p.draining {
+		p.queue = append(p.queue, f)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	f()
+}
+
+func (p *pauser) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+// resume runs queued calls in order, including the ones queued while
+// draining, and then lets calls through.
+func (p *pauser) resume() {
+	p.mu.Lock()
+	if !p.paused || p.draining {
+		p.mu.Unlock()
+		return
+	}
+	p.paused = false
+	p.draining = true
+	for len(p.queue) != 0 {
+		queue := p.queue
+		p.queue = nil
+		p.mu.Unlock()
+		for _, f := range queue {
+			f()
+		}
+		p.mu.Lock()
+	}
+	p.draining = false
+	p.mu.Unlock()
+}
+
+// wrap returns handlers which go through the pauser.
+func (p *pauser) wrap(handlers rpcclient.NotificationHandlers) rpcclient.NotificationHandlers {
+	wrapped := handlers
+	if h := handlers.OnBlockConnected; h != nil {
+		wrapped.OnBlockConnected = func(hash *chainhash.Hash, height int32, t time.Time) {
+			p.call(func() { h(hash, height, t) })
+		}
+	}
+	if h := handlers.OnBlockDisconnected; h != nil {
+		wrapped.OnBlockDisconnected = func(hash *chainhash.Hash, height int32, t time.Time) {
+			p.call(func() { h(hash, height, t) })
+		}
+	}
+	if h := handlers.OnFilteredBlockConnected; h != nil {
+		wrapped.OnFilteredBlockConnected = func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			p.call(func() { h(height, header, txs) })
+		}
+	}
+	if h := handlers.OnFilteredBlockDisconnected; h != nil {
+		wrapped.OnFilteredBlockDisconnected = func(height int32, header *wire.BlockHeader) {
+			p.call(func() { h(height, header) })
+		}
+	}
+	if h := handlers.OnRecvTx; h != nil {
+		wrapped.OnRecvTx = func(tx *btcutil.Tx, details *btcjson.BlockDetails) {
+			p.call(func() { h(tx, details) })
+		}
+	}
+	if h := handlers.OnRedeemingTx; h != nil {
+		wrapped.OnRedeemingTx = func(tx *btcutil.Tx, details *btcjson.BlockDetails) {
+			p.call(func() { h(tx, details) })
+		}
+	}
+	return wrapped
+}
+
+// Pause stops calling the handlers passed to StartWatching. The rescan and
+// the peers keep running: blocks connected or disconnected while paused are
+// processed as usual (matched transactions are stored, confirmations and
+// subscribers are updated), and the handler calls are queued in memory
+// until Resume, so a long pause costs memory. Queued calls are dropped by
+// Close. Pause may be called before StartWatching.
+func (w *Watcher) Pause() {
+	w.pause.pause()
+}
+
+// Resume makes the queued handler calls in order and then continues calling
+// the handlers as blocks arrive, so no block is skipped. It returns when the
+// queue is drained. Resume does nothing if the watcher is not paused.
+func (w *Watcher) Resume() error {
+	select {
+	case <-w.fullClose:
+		return ErrClosed
+	default:
+	}
+	w.pause.resume()
+	return nil
+}
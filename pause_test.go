@@ -0,0 +1,47 @@
+package watch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestPauseResume(t *testing.T) {
+	var p pauser
+	var got []int32
+	handlers := p.wrap(rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			got = append(got, height)
+		},
+		OnFilteredBlockDisconnected: func(height int32, header *wire.BlockHeader) {
+			got = append(got, -height)
+		},
+	})
+
+	handlers.OnFilteredBlockConnected(1, nil, nil)
+	p.pause()
+	handlers.OnFilteredBlockConnected(2, nil, nil)
+	handlers.OnFilteredBlockDisconnected(2, nil)
+	handlers.OnFilteredBlockConnected(2, nil, nil)
+	if want := []int32{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("While paused got %v, want %v.", got, want)
+	}
+
+	p.resume()
+	handlers.OnFilteredBlockConnected(3, nil, nil)
+	if want := []int32{1, 2, -2, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After resume got %v, want %v.", got, want)
+	}
+}
+
+func TestResumeClosed(t *testing.T) {
+	w := &Watcher{fullClose: make(chan struct{})}
+	w.Pause()
+	close(w.fullClose)
+	if err := w.Resume(); err != ErrClosed {
+		t.Errorf("Resume after close returned %v, want ErrClosed.", err)
+	}
+}
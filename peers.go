@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightninglabs/neutrino"
+)
+
+// PeerInfo describes a peer connected to the chain service.
+type PeerInfo struct {
+	Address string
+	Inbound bool
+
+	// Height is the height of the best block the peer announced.
+	Height int32
+
+	// Ping is the round trip of the last ping, zero if none yet.
+	Ping     time.Duration
+	LastPing time.Time
+	LastRecv time.Time
+}
+
+func connectedPeers(cs *neutrino.ChainService) []PeerInfo {
+	peers := cs.Peers()
+	infos := make([]PeerInfo, 0, len(peers))
+	for _, sp := range peers {
+		if !sp.Connected() {
+			continue
+		}
+		infos = append(infos, PeerInfo{
+			Address:  sp.Addr(),
+			Inbound:  sp.Inbound(),
+			Height:   sp.LastBlock(),
+			Ping:     time.Duration(sp.LastPingMicros()) * time.Microsecond,
+			LastPing: sp.LastPingTime(),
+			LastRecv: sp.LastRecv(),
+		})
+	}
+	return infos
+}
+
+// ConnectedPeers returns peers the watcher is connected to.
+func (w *Watcher) ConnectedPeers() []PeerInfo {
+	return connectedPeers(w.chainService())
+}
+
+// ConnectedPeers returns peers the watcher is connected to.
+func (w *FullWatcher) ConnectedPeers() []PeerInfo {
+	return connectedPeers(w.cs)
+}
+
+// normalizePeerAddr checks that addr is host or host:port and adds the default
+// port of the network if it is missing.
+func normalizePeerAddr(addr string, params *chaincfg.Params) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// No port, or an IPv6 address without brackets.
+		host, port = addr, params.DefaultPort
+		if ip := net.ParseIP(addr); ip == nil && strings.ContainsAny(addr, ":[]") {
+			return "", fmt.Errorf("malformed peer address %q: %w", addr, err)
+		}
+	}
+	if host == "" || strings.ContainsAny(host, " /") {
+		return "", fmt.Errorf("malformed peer address %q: bad host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("malformed peer address %q: bad port", addr)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// addPeer connects to the peer, reconnecting if the connection is lost.
+func addPeer(cs *neutrino.ChainService, params *chaincfg.Params, addr string) (string, error) {
+	addr, err := normalizePeerAddr(addr, params)
+	if err != nil {
+		return "", err
+	}
+	if err := cs.ConnectNode(addr, true); err != nil {
+		return "", fmt.Errorf("ConnectNode: %w", err)
+	}
+	return addr, nil
+}
+
+// disconnectPeer disconnects the peer and stops reconnecting to it.
+func disconnectPeer(cs *neutrino.ChainService, params *chaincfg.Params, addr string) (string, error) {
+	addr, err := normalizePeerAddr(addr, params)
+	if err != nil {
+		return "", err
+	}
+	if err := cs.RemoveNodeByAddr(addr); err != nil {
+		// Not a persistent peer, e.g. found by DNS seeds.
+		if err := cs.DisconnectNodeByAddr(addr); err != nil {
+			return "", fmt.Errorf("DisconnectNodeByAddr: %w", err)
+		}
+	}
+	return addr, nil
+}
+
+// AddPeer connects to the peer, host or host:port, without a restart. After a
+// restart of the watcher, only peers passed to the constructor are used.
+func (w *Watcher) AddPeer(addr string) error {
+	_, err := addPeer(w.chainService(), w.params, addr)
+	return err
+}
+
+// DisconnectPeer disconnects from the peer, host or host:port. It may connect
+// again after a restart of the watcher if the peer was passed to New.
+func (w *Watcher) DisconnectPeer(addr string) error {
+	_, err := disconnectPeer(w.chainService(), w.params, addr)
+	return err
+}
+
+// AddPeer connects to the peer, host or host:port, without a restart.
+func (w *FullWatcher) AddPeer(addr string) error {
+	_, err := addPeer(w.cs, w.params, addr)
+	return err
+}
+
+// DisconnectPeer disconnects from the peer, host or host:port.
+func (w *FullWatcher) DisconnectPeer(addr string) error {
+	_, err := disconnectPeer(w.cs, w.params, addr)
+	return err
+}
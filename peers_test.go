@@ -0,0 +1,37 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestNormalizePeerAddr(t *testing.T) {
+	cases := []struct {
+		addr, want string
+	}{
+		{"btcd-mainnet.lightning.computer", "btcd-mainnet.lightning.computer:8333"},
+		{"1.2.3.4:18333", "1.2.3.4:18333"},
+		{"1.2.3.4", "1.2.3.4:8333"},
+		{"::1", "[::1]:8333"},
+		{"[::1]:8334", "[::1]:8334"},
+		{"", ""},
+		{"host:port", ""},
+		{"host:0", ""},
+		{"host:70000", ""},
+		{"a b:8333", ""},
+		{"[::1", ""},
+	}
+	for _, tc := range cases {
+		got, err := normalizePeerAddr(tc.addr, &chaincfg.MainNetParams)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("normalizePeerAddr(%q) = %q, want an error.", tc.addr, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("normalizePeerAddr(%q) = %q, %v, want %q.", tc.addr, got, err, tc.want)
+		}
+	}
+}
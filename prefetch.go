@@ -0,0 +1,83 @@
+package watch
+
+import (
+	"sync"
+
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// prefetcher downloads blocks of the next heights in parallel for the
+// watching loop of FullWatcher, which takes them in order of heights. It is
+// used by that goroutine only.
+type prefetcher struct {
+	w          *FullWatcher
+	workers    int32
+	needHeader bool
+
+	pending map[int32]chan fetchResult
+	wg      sync.WaitGroup
+}
+
+type fetchResult struct {
+	block *fetchedBlock
+	err   error
+}
+
+func newPrefetcher(w *FullWatcher, workers int, handlers rpcclient.NotificationHandlers) *prefetcher {
+	return &prefetcher{
+		w:          w,
+		workers:    int32(workers),
+		needHeader: needsHeader(handlers),
+		pending:    make(map[int32]chan fetchResult),
+	}
+}
+
+// get starts downloads of the height and following heights up to bestHeight
+// and waits for the block at the height. A failed download is retried by the
+// next call for the height.
+func (p *prefetcher) get(height, bestHeight int32) (*fetchedBlock, error) {
+	for h := height; h < height+p.workers && h <= bestHeight; h++ {
+		if _, has := p.pending[h]; !has {
+			p.start(h)
+		}
+	}
+	result := p.pending[height]
+	select {
+	case r := <-result:
+		delete(p.pending, height)
+		return r.block, r.err
+	case <-p.w.fullClose:
+		return nil, ErrClosed
+	}
+}
+
+func (p *prefetcher) start(height int32) {
+	// Buffered, so the worker exits even if the result is never taken.
+	result := make(chan fetchResult, 1)
+	p.pending[height] = result
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		block, err := p.w.fetchHeight(height, p.needHeader)
+		result <- fetchResult{block: block, err: err}
+	}()
+}
+
+// drop forgets the download of a skipped height. It is a no-op on nil p.
+func (p *prefetcher) drop(height int32) {
+	if p != nil {
+		delete(p.pending, height)
+	}
+}
+
+// reset forgets all downloads, e.g. after a reorg. It is a no-op on nil p.
+func (p *prefetcher) reset() {
+	if p != nil {
+		p.pending = make(map[int32]chan fetchResult)
+	}
+}
+
+// wait waits for running downloads to finish.
+func (p *prefetcher) wait() {
+	p.wg.Wait()
+}
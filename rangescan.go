@@ -0,0 +1,146 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/gcs/builder"
+	"github.com/lightninglabs/neutrino"
+	"github.com/lightninglabs/neutrino/headerfs"
+)
+
+// RescanRange scans blocks from start to end inclusive for watched items once
+// and returns when done. It does not affect watching started by
+// StartWatching and does not store matches. Blocks must be synced up to end,
+// see WaitForSync. It stops with an error at the first block which can not be
+// matched against watched scripts.
+func (w *Watcher) RescanRange(start, end int32, handlers rpcclient.NotificationHandlers) error {
+	if start < 1 || end < start {
+		return fmt.Errorf("invalid range [%d, %d]", start, end)
+	}
+	tipHeight, err := w.CurrentHeight()
+	if err != nil {
+		return err
+	}
+	if end > tipHeight {
+		return fmt.Errorf("end %d is beyond the tip %d", end, tipHeight)
+	}
+
+	w.mu.Lock()
+	aaa, err := w.convertAddresses(w.addresses...)
+	scripts := w.rawScripts()
+	inputs := w.inputs
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// quit stops the rescan on Close or if a block can not be scanned.
+	quit := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(quit) })
+	}
+	defer stop()
+	go func() {
+		select {
+		case <-w.fullClose:
+			stop()
+		case <-quit:
+		}
+	}()
+
+	var scanErr error
+	ntfn := handlers
+	ntfn.OnFilteredBlockConnected = func(height int32, header *wire.BlockHeader, relevantTxs []*btcutil.Tx) {
+		if scanErr != nil {
+			return
+		}
+		relevantTxs, scanErr = w.addScriptMatches(header, relevantTxs)
+		if scanErr != nil {
+			scanErr = fmt.Errorf("for height %d addScriptMatches failed: %w", height, scanErr)
+			stop()
+			return
+		}
+		if handlers.OnFilteredBlockConnected != nil {
+			handlers.OnFilteredBlockConnected(height, header, relevantTxs)
+		}
+	}
+
+	// Rescan delivers blocks after the start block.
+	rescan := neutrino.NewRescan(
+		newRescanSource(w.chainService(), w.blocks, w.opts, quit),
+		neutrino.QuitChan(quit),
+		neutrino.StartBlock(&headerfs.BlockStamp{Height: start - 1}),
+		neutrino.EndBlock(&headerfs.BlockStamp{Height: end}),
+		neutrino.NotificationHandlers(ntfn),
+		neutrino.WatchAddrs(aaa...),
+		neutrino.WatchInputs(scriptInputs(scripts)...),
+		neutrino.WatchInputs(inputs...),
+	)
+	err = <-rescan.Start()
+	if scanErr != nil {
+		return scanErr
+	}
+	if err != nil {
+		if err == neutrino.ErrRescanExit {
+			return ErrClosed
+		}
+		return fmt.Errorf("rescan: %w", err)
+	}
+	return nil
+}
+
+// ScanBlock returns transactions of the block at the height which pay to
+// watched addresses and scripts or spend watched outpoints, e.g. to backfill
+// an address added after the block was scanned. The block is downloaded only
+// if its filter matches. Matches are not stored and handlers are not called.
+func (w *Watcher) ScanBlock(height int32) ([]*btcutil.Tx, *wire.BlockHeader, error) {
+	cs := w.chainService()
+	blockHash, err := blockHashAt(cs, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := blockHeader(cs, blockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	watched, err := w.watchedScripts()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.mu.Lock()
+	filterScripts := make([][]byte, 0, len(watched)+len(w.inputs))
+	for _, input := range w.inputs {
+		filterScripts = append(filterScripts, input.PkScript)
+	}
+	w.mu.Unlock()
+	for script := range watched {
+		filterScripts = append(filterScripts, []byte(script))
+	}
+	if len(filterScripts) == 0 {
+		return nil, header, nil
+	}
+
+	filter, err := fetchFilter(context.Background(), cs, w.opts, w.fullClose, blockHash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("for height %d GetCFilter failed: %w", height, err)
+	}
+	matched, err := filter.MatchAny(builder.DeriveKey(blockHash), filterScripts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("for height %d filter.MatchAny failed: %w", height, err)
+	}
+	if !matched {
+		return nil, header, nil
+	}
+	block, err := fetchBlock(context.Background(), cs, w.blocks, w.opts, w.fullClose, blockHash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("for height %d GetBlock failed: %w", height, err)
+	}
+	return blockMatches(block.Transactions(), watched, w.watchedOutPoints()), header, nil
+}
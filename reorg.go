@@ -0,0 +1,129 @@
+package watch
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxReorgDepth is how many delivered blocks FullWatcher remembers to find
+// the fork point of a reorg. Deeper reorgs are handled as if the fork was
+// just below the oldest remembered block.
+const maxReorgDepth = 100
+
+// deliveredBlock is a block which FullWatcher delivered to handlers.
+type deliveredBlock struct {
+	height    int32
+	header    wire.BlockHeader
+	outPoints outPointChange
+}
+
+// chainTracker remembers recently delivered blocks of consecutive heights.
+// It is used by the watching goroutine of FullWatcher only.
+type chainTracker struct {
+	blocks []deliveredBlock
+}
+
+// add records the block delivered at the height and how it changed the
+// outpoints. If it does not follow the last block, e.g. after a skipped
+// height, tracking starts over from it.
+func (c *chainTracker) add(height int32, header *wire.BlockHeader, outPoints outPointChange) {
+	if n := len(c.blocks); n != 0 && c.blocks[n-1].height != height-1 {
+		c.blocks = nil
+	}
+	c.blocks = append(c.blocks, deliveredBlock{height: height, header: *header, outPoints: outPoints})
+	if len(c.blocks) > maxReorgDepth {
+		c.blocks = c.blocks[len(c.blocks)-maxReorgDepth:]
+	}
+}
+
+// follows reports whether the header builds on the block delivered at
+// height-1. It is true if that block is not known.
+func (c *chainTracker) follows(height int32, header *wire.BlockHeader) bool {
+	n := len(c.blocks)
+	if n == 0 || c.blocks[n-1].height != height-1 {
+		return true
+	}
+	return c.blocks[n-1].header.BlockHash() == header.PrevBlock
+}
+
+// stale returns delivered blocks which are not in the chain any more, from
+// the newest down, and the height of the last block which still is. hashAt
+// returns the hash of the block at a height in the current chain.
+func (c *chainTracker) stale(hashAt func(height int32) (*chainhash.Hash, error)) ([]deliveredBlock, int32, error) {
+	var stale []deliveredBlock
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		b := c.blocks[i]
+		hash, err := hashAt(b.height)
+		if err != nil {
+			return nil, 0, err
+		}
+		if *hash == b.header.BlockHash() {
+			return stale, b.height, nil
+		}
+		stale = append(stale, b)
+	}
+	return stale, c.blocks[0].height - 1, nil
+}
+
+// remove forgets blocks above the height.
+func (c *chainTracker) remove(height int32) {
+	for len(c.blocks) != 0 && c.blocks[len(c.blocks)-1].height > height {
+		c.blocks = c.blocks[:len(c.blocks)-1]
+	}
+}
+
+// reorgError is returned by processBlock after blocks which left the chain
+// were disconnected. Watching continues from the block after forkHeight.
+type reorgError struct {
+	forkHeight int32
+}
+
+func (e *reorgError) Error() string {
+	return fmt.Sprintf("reorg with fork at height %d", e.forkHeight)
+}
+
+// disconnectStale delivers disconnections of delivered blocks which are not
+// in the chain any more, from the newest down, and returns the fork height.
+func (w *FullWatcher) disconnectStale(handlers rpcclient.NotificationHandlers) (int32, error) {
+	stale, forkHeight, err := w.tracker.stale(func(height int32) (*chainhash.Hash, error) {
+		hash, err := w.cs.GetBlockHash(int64(height))
+		if err != nil {
+			return nil, fmt.Errorf("GetBlockHash(%d) failed: %w", height, err)
+		}
+		return hash, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(stale) == len(w.tracker.blocks) {
+		w.opts.logWarn("Reorg is deeper than remembered blocks", "depth", len(stale))
+	}
+	w.opts.logWarn("Reorg, disconnecting blocks", "fork_height", forkHeight, "count", len(stale))
+	if err := w.deliverDisconnected(stale, forkHeight, handlers); err != nil {
+		return 0, err
+	}
+	w.tracker.remove(forkHeight)
+	return forkHeight, nil
+}
+
+// deliverDisconnected calls the disconnection handlers for blocks, reverts
+// their outpoint changes and stores forkHeight as processed, so that
+// ResumeWatching continues after the fork.
+func (w *FullWatcher) deliverDisconnected(blocks []deliveredBlock, forkHeight int32, handlers rpcclient.NotificationHandlers) error {
+	undo := make([]outPointChange, 0, len(blocks))
+	for _, b := range blocks {
+		undo = append(undo, b.outPoints.undo())
+		header := b.header
+		if handlers.OnBlockDisconnected != nil {
+			hash := header.BlockHash()
+			handlers.OnBlockDisconnected(&hash, b.height, header.Timestamp)
+		}
+		if handlers.OnFilteredBlockDisconnected != nil {
+			handlers.OnFilteredBlockDisconnected(b.height, &header)
+		}
+	}
+	return w.applyOutPoints(forkHeight, undo...)
+}
@@ -0,0 +1,139 @@
+package watch
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/neutrino"
+)
+
+// scriptAddress returns the address of pkScript if the script is one of the
+// standard single-address forms, which neutrino can watch as an address.
+// It returns nil for all other scripts.
+func scriptAddress(pkScript []byte, params *chaincfg.Params) btcutil.Address {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, params)
+	if err != nil || len(addrs) != 1 {
+		return nil
+	}
+	switch class {
+	case txscript.PubKeyHashTy, txscript.ScriptHashTy,
+		txscript.WitnessV0PubKeyHashTy, txscript.WitnessV0ScriptHashTy:
+		return addrs[0]
+	}
+	return nil
+}
+
+// paymentAddress is scriptAddress also recognizing P2TR, for reporting
+// addresses of outputs.
+func paymentAddress(pkScript []byte, params *chaincfg.Params) btcutil.Address {
+	if a := scriptAddress(pkScript, params); a != nil {
+		return a
+	}
+	if a := taprootScriptAddress(pkScript, params); a != nil {
+		return a
+	}
+	return nil
+}
+
+// decodeAddress is btcutil.DecodeAddress also accepting P2TR addresses. It
+// rejects base58 addresses of other networks, which btcutil decodes.
+func decodeAddress(addr string, params *chaincfg.Params) (btcutil.Address, error) {
+	a, err := btcutil.DecodeAddress(addr, params)
+	if err == nil {
+		if !a.IsForNet(params) {
+			return nil, fmt.Errorf("%w: %s is not for %s", ErrInvalidAddress, addr, params.Name)
+		}
+		return a, nil
+	}
+	if isTaprootCandidate(addr, params) {
+		tr, err := decodeTaprootAddress(addr, params)
+		if err != nil {
+			return nil, &sentinelError{ErrInvalidAddress, fmt.Errorf("%s: decodeTaprootAddress: %w", addr, err)}
+		}
+		return tr, nil
+	}
+	return nil, &sentinelError{ErrInvalidAddress, fmt.Errorf("%s: btcutil.DecodeAddress: %w", addr, err)}
+}
+
+func addressScripts(aaa []btcutil.Address) ([][]byte, error) {
+	scripts := make([][]byte, 0, len(aaa))
+	for _, a := range aaa {
+		if tr, ok := a.(*taprootAddress); ok {
+			scripts = append(scripts, tr.pkScript())
+			continue
+		}
+		script, err := txscript.PayToAddrScript(a)
+		if err != nil {
+			return nil, fmt.Errorf("txscript.PayToAddrScript: %w", err)
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// scriptInputs converts raw scripts to neutrino inputs with zero outpoints,
+// which makes neutrino match them against block filters.
+func scriptInputs(scripts [][]byte) []neutrino.InputWithScript {
+	inputs := make([]neutrino.InputWithScript, 0, len(scripts))
+	for _, script := range scripts {
+		inputs = append(inputs, neutrino.InputWithScript{PkScript: script})
+	}
+	return inputs
+}
+
+// blockMatches returns transactions which pay to watched scripts or spend
+// outPoints, like neutrino's rescan does. Outputs paying to watched scripts
+// are added to outPoints, so spends within the block match too.
+func blockMatches(txs []*btcutil.Tx, watched map[string]bool, outPoints map[wire.OutPoint]bool) []*btcutil.Tx {
+	var matches []*btcutil.Tx
+	for _, tx := range txs {
+		isMatch := false
+		for _, txIn := range tx.MsgTx().TxIn {
+			if outPoints[txIn.PreviousOutPoint] {
+				isMatch = true
+			}
+		}
+		for i, txOut := range tx.MsgTx().TxOut {
+			if watched[string(txOut.PkScript)] {
+				outPoints[wire.OutPoint{Hash: *tx.Hash(), Index: uint32(i)}] = true
+				isMatch = true
+			}
+		}
+		if isMatch {
+			matches = append(matches, tx)
+		}
+	}
+	return matches
+}
+
+func paysScript(tx *btcutil.Tx, scripts [][]byte) bool {
+	for _, txOut := range tx.MsgTx().TxOut {
+		for _, script := range scripts {
+			if bytes.Equal(txOut.PkScript, script) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeScriptMatches returns transactions of the block which are either in
+// relevantTxs or pay to one of scripts, keeping the order of the block.
+func mergeScriptMatches(blockTxs, relevantTxs []*btcutil.Tx, scripts [][]byte) []*btcutil.Tx {
+	relevant := make(map[chainhash.Hash]bool, len(relevantTxs))
+	for _, tx := range relevantTxs {
+		relevant[*tx.Hash()] = true
+	}
+	result := make([]*btcutil.Tx, 0, len(relevantTxs))
+	for _, tx := range blockTxs {
+		if relevant[*tx.Hash()] || paysScript(tx, scripts) {
+			result = append(result, tx)
+		}
+	}
+	return result
+}
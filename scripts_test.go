@@ -0,0 +1,87 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestScriptMatches(t *testing.T) {
+	params := &chaincfg.MainNetParams
+
+	a, err := btcutil.DecodeAddress("3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2sh, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scriptAddress(p2sh, params); got == nil || got.EncodeAddress() != a.EncodeAddress() {
+		t.Errorf("scriptAddress(p2sh) = %v, want %s.", got, a)
+	}
+
+	// Bare 1-of-1 multisig has no address form.
+	pubKey := append([]byte{0x02}, make([]byte, 32)...)
+	pubKey[32] = 1
+	bare, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_1).AddData(pubKey).AddOp(txscript.OP_1).
+		AddOp(txscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scriptAddress(bare, params); got != nil {
+		t.Errorf("scriptAddress(bare multisig) = %s, want nil.", got)
+	}
+
+	makeTx := func(lockTime uint32, pkScript []byte) *btcutil.Tx {
+		msgTx := wire.NewMsgTx(wire.TxVersion)
+		msgTx.LockTime = lockTime
+		msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+		return btcutil.NewTx(msgTx)
+	}
+	tx0 := makeTx(0, p2sh)
+	tx1 := makeTx(1, bare)
+	tx2 := makeTx(2, p2sh)
+	blockTxs := []*btcutil.Tx{tx0, tx1, tx2}
+
+	got := mergeScriptMatches(blockTxs, []*btcutil.Tx{tx2}, [][]byte{bare})
+	if len(got) != 2 || got[0] != tx1 || got[1] != tx2 {
+		t.Errorf("mergeScriptMatches returned %v, want [%s %s].", got, tx1.Hash(), tx2.Hash())
+	}
+}
+
+func TestBlockMatches(t *testing.T) {
+	watchedScript := []byte{txscript.OP_TRUE}
+	watchedOp := wire.OutPoint{Index: 7}
+
+	pay := wire.NewMsgTx(wire.TxVersion)
+	pay.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	pay.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_2}))
+	pay.AddTxOut(wire.NewTxOut(2000, watchedScript))
+	payTx := btcutil.NewTx(pay)
+
+	// Spends the output of pay in the same block.
+	spendNew := wire.NewMsgTx(wire.TxVersion)
+	spendNew.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: *payTx.Hash(), Index: 1}, nil, nil))
+	spendNew.AddTxOut(wire.NewTxOut(1500, []byte{txscript.OP_2}))
+
+	spendOld := wire.NewMsgTx(wire.TxVersion)
+	spendOld.AddTxIn(wire.NewTxIn(&watchedOp, nil, nil))
+	spendOld.AddTxOut(wire.NewTxOut(500, []byte{txscript.OP_3}))
+
+	other := wire.NewMsgTx(wire.TxVersion)
+	other.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: *payTx.Hash(), Index: 0}, nil, nil))
+	other.AddTxOut(wire.NewTxOut(900, []byte{txscript.OP_2}))
+
+	txs := []*btcutil.Tx{btcutil.NewTx(other), payTx, btcutil.NewTx(spendNew), btcutil.NewTx(spendOld)}
+	watched := map[string]bool{string(watchedScript): true}
+	outPoints := map[wire.OutPoint]bool{watchedOp: true}
+	got := blockMatches(txs, watched, outPoints)
+	if len(got) != 3 || got[0] != txs[1] || got[1] != txs[2] || got[2] != txs[3] {
+		t.Errorf("blockMatches returned %v, want the last 3 transactions.", got)
+	}
+}
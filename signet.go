@@ -0,0 +1,44 @@
+package watch
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// btcd v0.20 has no signet parameters. These are of the default signet
+// (BIP 325), derived from testnet3. Neutrino checks proof of work only, not
+// signatures of signet blocks, so peers are trusted to follow the signet
+// challenge.
+
+var signetGenesisBlock = func() wire.MsgBlock {
+	block := *chaincfg.TestNet3Params.GenesisBlock
+	block.Header.Timestamp = time.Unix(1598918400, 0)
+	block.Header.Bits = 0x1e0377ae
+	block.Header.Nonce = 52613770
+	return block
+}()
+
+var signetParams = func() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = "signet"
+	params.Net = wire.BitcoinNet(0x40cf030a)
+	params.DefaultPort = "38333"
+	params.DNSSeeds = []chaincfg.DNSSeed{
+		{Host: "seed.signet.bitcoin.sprovoost.nl", HasFiltering: false},
+	}
+	genesisHash := signetGenesisBlock.BlockHash()
+	params.GenesisBlock = &signetGenesisBlock
+	params.GenesisHash = &genesisHash
+	params.PowLimit = new(big.Int).Lsh(big.NewInt(0x0377ae), 8*(0x1e-3))
+	params.PowLimitBits = 0x1e0377ae
+	params.BIP0034Height = 1
+	params.BIP0065Height = 1
+	params.BIP0066Height = 1
+	params.ReduceMinDifficulty = false
+	params.MinDiffReductionTime = 0
+	params.Checkpoints = nil
+	return params
+}()
@@ -0,0 +1,37 @@
+package watch
+
+import (
+	"time"
+)
+
+const (
+	sinkRetryMin = time.Second
+	sinkRetryMax = time.Minute
+)
+
+// Sink receives events, e.g. to publish them to a durable queue.
+type Sink interface {
+	Deliver(Event) error
+}
+
+// deliverToSink retries delivery with exponential backoff until it succeeds
+// or quit is closed. It returns false in the latter case.
+func deliverToSink(o *options, sink Sink, event Event, quit <-chan struct{}) bool {
+	delay := sinkRetryMin
+	for {
+		err := sink.Deliver(event)
+		if err == nil {
+			return true
+		}
+		o.logWarn("Failed to deliver tx to sink, retrying", "txid", event.Tx.Hash(), "err", err, "delay", delay)
+		select {
+		case <-quit:
+			return false
+		case <-o.clock.After(delay):
+		}
+		delay *= 2
+		if delay > sinkRetryMax {
+			delay = sinkRetryMax
+		}
+	}
+}
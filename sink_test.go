@@ -0,0 +1,110 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+type testSink struct {
+	err    error
+	events []Event
+}
+
+func (s *testSink) Deliver(event Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	txs := []*btcutil.Tx{makeTestTx(1), makeTestTx(2)}
+	prevOut := blockPrevOut(txs, newBlockCache(0))
+	quit := make(chan struct{})
+
+	good := &testSink{}
+	o := newOptions([]Option{WithSinks(good)})
+	if !deliverEvents(o, quit, &chaincfg.MainNetParams, nil, nil, 10, &chainhash.Hash{}, txs, prevOut) {
+		t.Fatalf("deliverEvents returned false.")
+	}
+	if len(good.events) != 2 || good.events[1].Tx != txs[1] || good.events[1].Height != 10 {
+		t.Errorf("Sink got %v, want events for 2 txs at height 10.", good.events)
+	}
+
+	bad := &testSink{err: errors.New("queue is down")}
+	o = newOptions([]Option{WithSinks(bad)})
+	close(quit)
+	if deliverEvents(o, quit, &chaincfg.MainNetParams, nil, nil, 10, &chainhash.Hash{}, txs, prevOut) {
+		t.Errorf("deliverEvents to a failing sink returned true.")
+	}
+}
+
+// fakeClock returns from After immediately, advancing its time.
+type fakeClock struct {
+	now    time.Time
+	delays []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.delays = append(c.delays, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// flakySink fails the first failures deliveries.
+type flakySink struct {
+	failures int
+	calls    int
+}
+
+func (s *flakySink) Deliver(event Event) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("queue is down")
+	}
+	return nil
+}
+
+func TestSinkBackoff(t *testing.T) {
+	clock := &fakeClock{}
+	logger := &testLogger{}
+	o := newOptions([]Option{WithLogger(logger)})
+	o.clock = clock
+	sink := &flakySink{failures: 8}
+	event := Event{Tx: makeTestTx(1)}
+	if !deliverToSink(o, sink, event, make(chan struct{})) {
+		t.Fatalf("deliverToSink returned false.")
+	}
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 32 * time.Second, time.Minute, time.Minute,
+	}
+	if !reflect.DeepEqual(clock.delays, want) {
+		t.Errorf("Delays are %v, want %v.", clock.delays, want)
+	}
+	if len(logger.lines) != 8 {
+		t.Errorf("Logged %d lines, want one per failure.", len(logger.lines))
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
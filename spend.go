@@ -0,0 +1,160 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// spendWait is a pending WaitForSpend.
+type spendWait struct {
+	confs int32
+
+	// tx spends the outpoint and was mined at height. It is nil until the
+	// spend is seen. Guarded by Watcher.mu.
+	tx     *btcutil.Tx
+	height int32
+
+	// done is closed when the spend is confirmed and replaced when it is
+	// disconnected again. Guarded by Watcher.mu.
+	done chan struct{}
+}
+
+// confirmed reports whether the spend has enough confirmations at scanned.
+func (s *spendWait) confirmed(scanned int32) bool {
+	return s.tx != nil && scanned-s.height+1 >= s.confs
+}
+
+// WaitForSpend waits until the outpoint is spent and the spending tx has confs
+// confirmations, or DefaultConfirmations if confs is 0. The outpoint must be
+// registered with RegisterSpend.
+func (w *Watcher) WaitForSpend(ctx context.Context, outpoint wire.OutPoint, confs int32) (spendingTx *btcutil.Tx, height int32, err error) {
+	wait := &spendWait{
+		confs: w.opts.confirmations(confs),
+		done:  make(chan struct{}),
+	}
+
+	w.mu.Lock()
+	registered := false
+	for _, input := range w.inputs {
+		if input.OutPoint == outpoint {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		w.mu.Unlock()
+		return nil, 0, ErrUnknownOutPoint
+	}
+	if w.spendWaits == nil {
+		w.spendWaits = make(map[wire.OutPoint][]*spendWait)
+	}
+	w.spendWaits[outpoint] = append(w.spendWaits[outpoint], wait)
+	w.mu.Unlock()
+	defer w.removeSpendWait(outpoint, wait)
+
+	// The spend may have been processed already.
+	minedHeight, msgTx, found, err := findMatchedSpend(w.database(), outpoint)
+	if err != nil {
+		return nil, 0, fmt.Errorf("findMatchedSpend: %w", err)
+	}
+	w.mu.Lock()
+	if found && wait.tx == nil {
+		wait.tx, wait.height = btcutil.NewTx(msgTx), minedHeight
+	}
+	if wait.confirmed(atomic.LoadInt32(&w.scannedHeight)) {
+		spendingTx, height = wait.tx, wait.height
+		w.mu.Unlock()
+		return spendingTx, height, nil
+	}
+	w.mu.Unlock()
+
+	for {
+		w.mu.Lock()
+		done := wait.done
+		w.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-w.fullClose:
+			return nil, 0, ErrClosed
+		case <-done:
+		}
+		w.mu.Lock()
+		spendingTx, height = wait.tx, wait.height
+		w.mu.Unlock()
+		if spendingTx != nil {
+			return spendingTx, height, nil
+		}
+		// The spend was disconnected since, wait for it again.
+	}
+}
+
+func (w *Watcher) removeSpendWait(outpoint wire.OutPoint, wait *spendWait) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	waits := w.spendWaits[outpoint]
+	for i, other := range waits {
+		if other == wait {
+			waits = append(waits[:i], waits[i+1:]...)
+			break
+		}
+	}
+	if len(waits) == 0 {
+		delete(w.spendWaits, outpoint)
+	} else {
+		w.spendWaits[outpoint] = waits
+	}
+}
+
+// processSpendWaits records spends in the block at the height and wakes up
+// waits which got enough confirmations.
+func (w *Watcher) processSpendWaits(height int32, relevantTxs []*btcutil.Tx) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.spendWaits) == 0 {
+		return
+	}
+	for _, tx := range relevantTxs {
+		for _, txIn := range tx.MsgTx().TxIn {
+			for _, wait := range w.spendWaits[txIn.PreviousOutPoint] {
+				if wait.tx == nil {
+					wait.tx, wait.height = tx, height
+				}
+			}
+		}
+	}
+	for _, waits := range w.spendWaits {
+		for _, wait := range waits {
+			if wait.confirmed(height) {
+				select {
+				case <-wait.done:
+				default:
+					close(wait.done)
+				}
+			}
+		}
+	}
+}
+
+// unmineSpendWaits forgets spends mined at the height or above, when the block
+// at the height was disconnected.
+func (w *Watcher) unmineSpendWaits(height int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, waits := range w.spendWaits {
+		for _, wait := range waits {
+			if wait.tx != nil && wait.height >= height {
+				wait.tx, wait.height = nil, 0
+				select {
+				case <-wait.done:
+					wait.done = make(chan struct{})
+				default:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/neutrino"
+)
+
+func TestWaitForSpend(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	outpoint := wire.OutPoint{Hash: *makeTestTx(1).Hash(), Index: 0}
+	watcher := &Watcher{
+		db:     db,
+		params: &chaincfg.MainNetParams,
+		opts:   newOptions(nil),
+		inputs: []neutrino.InputWithScript{{OutPoint: outpoint, PkScript: []byte{0x51}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, _, err := watcher.WaitForSpend(ctx, wire.OutPoint{Index: 5}, 1); err != ErrUnknownOutPoint {
+		t.Errorf("WaitForSpend of unknown outpoint returned %v, want ErrUnknownOutPoint.", err)
+	}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(&outpoint, nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(500, []byte{0x52}))
+	spender := btcutil.NewTx(msgTx)
+
+	type result struct {
+		tx     *btcutil.Tx
+		height int32
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		tx, height, err := watcher.WaitForSpend(ctx, outpoint, 2)
+		results <- result{tx, height, err}
+	}()
+	for {
+		watcher.mu.Lock()
+		n := len(watcher.spendWaits[outpoint])
+		watcher.mu.Unlock()
+		if n != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	watcher.processSpendWaits(10, []*btcutil.Tx{spender})
+	// The spend is reorged out and mined again.
+	watcher.unmineSpendWaits(10)
+	watcher.processSpendWaits(10, nil)
+	watcher.processSpendWaits(11, []*btcutil.Tx{spender})
+	select {
+	case r := <-results:
+		t.Fatalf("WaitForSpend returned %v at 1 confirmation.", r)
+	default:
+	}
+	watcher.processSpendWaits(12, nil)
+
+	r := <-results
+	if r.err != nil {
+		t.Fatalf("WaitForSpend: %v.", r.err)
+	}
+	if r.tx != spender || r.height != 11 {
+		t.Errorf("WaitForSpend returned %s at %d, want %s at 11.", r.tx.Hash(), r.height, spender.Hash())
+	}
+	if len(watcher.spendWaits) != 0 {
+		t.Errorf("Spend waits are not removed: %v.", watcher.spendWaits)
+	}
+
+	// A confirmed spend which is disconnected before WaitForSpend wakes
+	// up is waited for again.
+	go func() {
+		tx, height, err := watcher.WaitForSpend(ctx, outpoint, 1)
+		results <- result{tx, height, err}
+	}()
+	for {
+		watcher.mu.Lock()
+		n := len(watcher.spendWaits[outpoint])
+		watcher.mu.Unlock()
+		if n != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	watcher.processSpendWaits(13, []*btcutil.Tx{spender})
+	watcher.unmineSpendWaits(13)
+	watcher.processSpendWaits(13, nil)
+	watcher.processSpendWaits(14, []*btcutil.Tx{spender})
+	if r := <-results; r.err != nil || r.tx != spender {
+		t.Errorf("WaitForSpend across a reorg returned %v at %d (%v), want %s.", r.tx, r.height, r.err, spender.Hash())
+	}
+
+	// Spends stored before the call are found.
+	if err := storeMatchedTxs(db, 11, &wire.BlockHeader{}, []*btcutil.Tx{spender}); err != nil {
+		t.Fatal(err)
+	}
+	watcher.scannedHeight = 12
+	tx, height, err := watcher.WaitForSpend(ctx, outpoint, 2)
+	if err != nil || *tx.Hash() != *spender.Hash() || height != 11 {
+		t.Errorf("WaitForSpend of stored spend returned %v at %d (%v), want %s at 11.", tx, height, err, spender.Hash())
+	}
+
+	// The spend is looked up by the index of spent outpoints.
+	if _, _, found, err := findMatchedSpend(db, wire.OutPoint{Index: 7}); err != nil || found {
+		t.Errorf("findMatchedSpend of an unspent outpoint: found=%v, err=%v, want not found.", found, err)
+	}
+	if err := deleteMatchedTxs(db, 11); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, found, err := findMatchedSpend(db, outpoint); err != nil || found {
+		t.Errorf("findMatchedSpend after the spend was disconnected: found=%v, err=%v, want not found.", found, err)
+	}
+}
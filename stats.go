@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"sync/atomic"
+
+	"github.com/lightninglabs/neutrino"
+)
+
+// CacheStats describes neutrino's block and filter caches. Neutrino reports
+// only the number of entries, sizes in bytes are bounded by the capacities.
+type CacheStats struct {
+	BlockEntries  int
+	FilterEntries int
+
+	BlockCacheCapacity  uint64
+	FilterCacheCapacity uint64
+}
+
+func cacheStats(cs *neutrino.ChainService, o *options) CacheStats {
+	return CacheStats{
+		BlockEntries:        cs.BlockCache.Len(),
+		FilterEntries:       cs.FilterCache.Len(),
+		BlockCacheCapacity:  o.blockCacheSize,
+		FilterCacheCapacity: o.filterCacheSize,
+	}
+}
+
+// WatcherStats is a snapshot of the watcher state for monitoring.
+type WatcherStats struct {
+	// Height is the height of the best block header, zero if unknown.
+	Height int32
+	Synced bool
+
+	// ScannedHeight is the height of the last block processed by the
+	// rescan.
+	ScannedHeight int32
+
+	Peers     int
+	Addresses int
+
+	// Watching is true if the rescan started by StartWatching is running,
+	// i.e. it was started and is not restarting or failed.
+	Watching bool
+
+	// Restarts counts soft and hard restarts since the watcher was
+	// created.
+	Restarts uint64
+
+	// Downloads is the traffic of blocks and filters since the watcher was
+	// created, see Watcher.DownloadStats.
+	Downloads DownloadStats
+}
+
+// SyncStalls tells which parts of the chain sync are stuck. Block headers and
+// filter headers are downloaded separately and need different diagnosis.
+type SyncStalls struct {
+	BlockHeaders  bool
+	FilterHeaders bool
+}
+
+// AddressStats tells when a watched address was registered and used. Heights
+// are zero if unknown. They are not rolled back on reorgs.
+type AddressStats struct {
+	// RegisteredHeight is the scanned height when the address was first
+	// added.
+	RegisteredHeight int32
+
+	FirstReceivedHeight int32
+
+	// LastSeenHeight is the last height where the address received funds
+	// or its outputs registered with RegisterSpend were spent.
+	LastSeenHeight int32
+}
+
+// DownloadStats approximates traffic of blocks and filters requested by the
+// watcher and its rescans. Requests served from neutrino's caches are counted
+// too, requests made by neutrino on its own, e.g. to verify filter headers,
+// are not.
+type DownloadStats struct {
+	Blocks      uint64
+	BlockBytes  uint64
+	Filters     uint64
+	FilterBytes uint64
+}
+
+// downloadCounters accumulates DownloadStats. Fields are accessed atomically.
+type downloadCounters struct {
+	blocks      uint64
+	blockBytes  uint64
+	filters     uint64
+	filterBytes uint64
+}
+
+func (c *downloadCounters) addBlock(size int) {
+	atomic.AddUint64(&c.blocks, 1)
+	atomic.AddUint64(&c.blockBytes, uint64(size))
+}
+
+func (c *downloadCounters) addFilter(size int) {
+	atomic.AddUint64(&c.filters, 1)
+	atomic.AddUint64(&c.filterBytes, uint64(size))
+}
+
+func (c *downloadCounters) stats() DownloadStats {
+	return DownloadStats{
+		Blocks:      atomic.LoadUint64(&c.blocks),
+		BlockBytes:  atomic.LoadUint64(&c.blockBytes),
+		Filters:     atomic.LoadUint64(&c.filters),
+		FilterBytes: atomic.LoadUint64(&c.filterBytes),
+	}
+}
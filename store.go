@@ -0,0 +1,767 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcwallet/walletdb"
+)
+
+var (
+	// matchedTxsBucket stores relevant transactions delivered to the
+	// handler. Key is height and index of the tx among relevant txs of the
+	// block (both big endian uint32), value is block header followed by tx.
+	matchedTxsBucket = []byte("watch-matched-txs")
+
+	// matchedTxIDsBucket indexes matchedTxsBucket by txid. Key is txid,
+	// value is the key of the tx in matchedTxsBucket. Entries of txs
+	// pruned by Compact are kept, so their heights stay known.
+	matchedTxIDsBucket = []byte("watch-matched-txids")
+
+	// matchedSpendsBucket indexes matchedTxsBucket by spent outpoints. Key
+	// is txid and output index (big endian uint32), value is the key of the
+	// spending tx in matchedTxsBucket.
+	matchedSpendsBucket = []byte("watch-matched-spends")
+
+	// addressActivityBucket stores AddressStats of watched addresses. Key
+	// is the address, value is registered, first received and last seen
+	// heights (big endian uint32).
+	addressActivityBucket = []byte("watch-address-activity")
+
+	// confirmationsBucket stores pending WatchConfirmations. Key is txid,
+	// value is target confirmations and the height the tx was mined at,
+	// zero if not yet (both big endian uint32).
+	confirmationsBucket = []byte("watch-confirmations")
+
+	// fullOutPointsBucket stores outputs paying to addresses watched by
+	// FullWatcher, to detect spends of them after restarts. Key is txid
+	// and output index (big endian uint32), value is empty.
+	fullOutPointsBucket = []byte("watch-full-outpoints")
+
+	// processedHeightBucket stores the last height processed by
+	// FullWatcher under processedHeightKey (big endian uint32).
+	processedHeightBucket = []byte("watch-processed-height")
+	processedHeightKey    = []byte("height")
+
+	// watchBuckets are all top-level buckets owned by this package. They
+	// survive restart, which recreates the rest of the database.
+	watchBuckets = [][]byte{matchedTxsBucket, matchedTxIDsBucket, matchedSpendsBucket, addressActivityBucket, confirmationsBucket, fullOutPointsBucket, processedHeightBucket}
+)
+
+// neutrinoBuckets are top-level buckets of neutrino's header index, filter
+// store and ban manager.
+var neutrinoBuckets = [][]byte{[]byte("header-index"), []byte("filter-store"), []byte("ban-store")}
+
+// deleteNeutrinoBuckets deletes neutrino's state from db, keeping buckets of
+// this package and of other users of db.
+func deleteNeutrinoBuckets(db walletdb.DB) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		for _, name := range neutrinoBuckets {
+			err := tx.DeleteTopLevelBucket(name)
+			if err != nil && err != walletdb.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func matchedTxKey(height int32, index uint32) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint32(key[:4], uint32(height))
+	binary.BigEndian.PutUint32(key[4:], index)
+	return key
+}
+
+// storeMatchedTxs replaces the transactions stored for the height.
+func storeMatchedTxs(db walletdb.DB, height int32, header *wire.BlockHeader, txs []*btcutil.Tx) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket, err := tx.CreateTopLevelBucket(matchedTxsBucket)
+		if err != nil {
+			return err
+		}
+		index, err := tx.CreateTopLevelBucket(matchedTxIDsBucket)
+		if err != nil {
+			return err
+		}
+		spends, err := tx.CreateTopLevelBucket(matchedSpendsBucket)
+		if err != nil {
+			return err
+		}
+		if err := deleteHeight(bucket, index, spends, height); err != nil {
+			return err
+		}
+		for i, t := range txs {
+			var buf bytes.Buffer
+			if err := header.Serialize(&buf); err != nil {
+				return err
+			}
+			if err := t.MsgTx().Serialize(&buf); err != nil {
+				return err
+			}
+			key := matchedTxKey(height, uint32(i))
+			if err := bucket.Put(key, buf.Bytes()); err != nil {
+				return err
+			}
+			if err := index.Put(t.Hash()[:], key); err != nil {
+				return err
+			}
+			for _, spent := range spentKeys(t.MsgTx()) {
+				if err := spends.Put(spent, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// spentKeys returns keys of matchedSpendsBucket for outpoints spent by msgTx.
+func spentKeys(msgTx *wire.MsgTx) [][]byte {
+	if blockchain.IsCoinBaseTx(msgTx) {
+		return nil
+	}
+	keys := make([][]byte, 0, len(msgTx.TxIn))
+	for _, txIn := range msgTx.TxIn {
+		keys = append(keys, outPointKey(txIn.PreviousOutPoint))
+	}
+	return keys
+}
+
+// deleteMatchedTxs removes the transactions stored for the height, e.g. when
+// its block was disconnected.
+func deleteMatchedTxs(db walletdb.DB, height int32) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadWriteBucket(matchedTxsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return deleteHeight(bucket, tx.ReadWriteBucket(matchedTxIDsBucket), tx.ReadWriteBucket(matchedSpendsBucket), height)
+	})
+}
+
+func deleteHeight(bucket, index, spends walletdb.ReadWriteBucket, height int32) error {
+	prefix := matchedTxKey(height, 0)[:4]
+	return deleteRange(bucket, index, spends, prefix, func(k []byte) bool {
+		return bytes.HasPrefix(k, prefix)
+	})
+}
+
+// deleteRange deletes records of bucket from the key seek while in returns
+// true, and their entries in the txid index and the spends index, which may
+// be nil.
+func deleteRange(bucket, index, spends walletdb.ReadWriteBucket, seek []byte, in func(k []byte) bool) error {
+	var keys [][]byte
+	var txs []*wire.MsgTx
+	cursor := bucket.ReadCursor()
+	for k, v := cursor.Seek(seek); k != nil && in(k); k, v = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+		if index == nil && spends == nil {
+			continue
+		}
+		_, msgTx, err := decodeMatchedTx(v)
+		if err != nil {
+			return err
+		}
+		txs = append(txs, msgTx)
+	}
+	for i, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+		if index != nil {
+			txid := txs[i].TxHash()
+			if err := deleteIndexEntry(index, txid[:], k); err != nil {
+				return err
+			}
+		}
+		if spends != nil {
+			for _, spent := range spentKeys(txs[i]) {
+				if err := deleteIndexEntry(spends, spent, k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteIndexEntry deletes the entry of index if it still points to the
+// record k. The tx may have been stored again at another height since.
+func deleteIndexEntry(index walletdb.ReadWriteBucket, entry, k []byte) error {
+	if !bytes.Equal(index.Get(entry), k) {
+		return nil
+	}
+	return index.Delete(entry)
+}
+
+// pruneMatchedTxs removes the transactions stored for heights below the
+// height and returns the number of removed records. Their entries in
+// matchedTxIDsBucket are kept, those in matchedSpendsBucket are removed.
+func pruneMatchedTxs(db walletdb.DB, below int32) (int, error) {
+	var n int
+	err := walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadWriteBucket(matchedTxsBucket)
+		if bucket == nil {
+			return nil
+		}
+		end := matchedTxKey(below, 0)
+		in := func(k []byte) bool {
+			if bytes.Compare(k, end) >= 0 {
+				return false
+			}
+			n++
+			return true
+		}
+		// The index keeps the heights of pruned txs for
+		// WatchConfirmations.
+		return deleteRange(bucket, nil, tx.ReadWriteBucket(matchedSpendsBucket), matchedTxKey(0, 0), in)
+	})
+	return n, err
+}
+
+// indexMatchedTxs fills matchedTxIDsBucket and matchedSpendsBucket from
+// matchedTxsBucket if they do not exist yet, e.g. in a database written by an
+// older version.
+func indexMatchedTxs(db walletdb.DB) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadBucket(matchedTxsBucket)
+		if bucket == nil {
+			return nil
+		}
+		var index, spends walletdb.ReadWriteBucket
+		var err error
+		if tx.ReadBucket(matchedTxIDsBucket) == nil {
+			if index, err = tx.CreateTopLevelBucket(matchedTxIDsBucket); err != nil {
+				return err
+			}
+		}
+		if tx.ReadBucket(matchedSpendsBucket) == nil {
+			if spends, err = tx.CreateTopLevelBucket(matchedSpendsBucket); err != nil {
+				return err
+			}
+		}
+		if index == nil && spends == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			_, msgTx, err := decodeMatchedTx(v)
+			if err != nil {
+				return err
+			}
+			key := append([]byte(nil), k...)
+			if index != nil {
+				txid := msgTx.TxHash()
+				if err := index.Put(txid[:], key); err != nil {
+					return err
+				}
+			}
+			if spends != nil {
+				for _, spent := range spentKeys(msgTx) {
+					if err := spends.Put(spent, key); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// decodeMatchedTx decodes a value of matchedTxsBucket.
+func decodeMatchedTx(v []byte) (*wire.BlockHeader, *wire.MsgTx, error) {
+	r := bytes.NewReader(v)
+	header := &wire.BlockHeader{}
+	if err := header.Deserialize(r); err != nil {
+		return nil, nil, fmt.Errorf("header.Deserialize: %w", err)
+	}
+	msgTx := &wire.MsgTx{}
+	if err := msgTx.Deserialize(r); err != nil {
+		return nil, nil, fmt.Errorf("msgTx.Deserialize: %w", err)
+	}
+	return header, msgTx, nil
+}
+
+// loadMatchedTxs returns the lowest stored height which is >= since, with its
+// header and transactions. found is false if there is no such height.
+func loadMatchedTxs(db walletdb.DB, since int32) (height int32, header *wire.BlockHeader, txs []*btcutil.Tx, found bool, err error) {
+	err = walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(matchedTxsBucket)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.ReadCursor()
+		k, v := cursor.Seek(matchedTxKey(since, 0))
+		if k == nil {
+			return nil
+		}
+		found = true
+		height = int32(binary.BigEndian.Uint32(k[:4]))
+		prefix := append([]byte(nil), k[:4]...)
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			r := bytes.NewReader(v)
+			header = &wire.BlockHeader{}
+			if err := header.Deserialize(r); err != nil {
+				return fmt.Errorf("header.Deserialize: %w", err)
+			}
+			msgTx := &wire.MsgTx{}
+			if err := msgTx.Deserialize(r); err != nil {
+				return fmt.Errorf("msgTx.Deserialize: %w", err)
+			}
+			txs = append(txs, btcutil.NewTx(msgTx))
+		}
+		return nil
+	})
+	return
+}
+
+// snapshotBuckets reads the contents of watchBuckets into memory.
+func snapshotBuckets(db walletdb.DB) (map[string]map[string][]byte, error) {
+	snapshot := make(map[string]map[string][]byte)
+	err := walletdb.View(db, func(tx walletdb.ReadTx) error {
+		for _, name := range watchBuckets {
+			bucket := tx.ReadBucket(name)
+			if bucket == nil {
+				continue
+			}
+			contents := make(map[string][]byte)
+			if err := bucket.ForEach(func(k, v []byte) error {
+				contents[string(k)] = append([]byte(nil), v...)
+				return nil
+			}); err != nil {
+				return err
+			}
+			snapshot[string(name)] = contents
+		}
+		return nil
+	})
+	return snapshot, err
+}
+
+// restoreBuckets writes a snapshot taken by snapshotBuckets.
+func restoreBuckets(db walletdb.DB, snapshot map[string]map[string][]byte) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		for name, contents := range snapshot {
+			bucket, err := tx.CreateTopLevelBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k, v := range contents {
+				if err := bucket.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func encodeAddressStats(stats AddressStats) []byte {
+	v := make([]byte, 12)
+	binary.BigEndian.PutUint32(v[0:4], uint32(stats.RegisteredHeight))
+	binary.BigEndian.PutUint32(v[4:8], uint32(stats.FirstReceivedHeight))
+	binary.BigEndian.PutUint32(v[8:12], uint32(stats.LastSeenHeight))
+	return v
+}
+
+func decodeAddressStats(v []byte) (AddressStats, error) {
+	if len(v) != 12 {
+		return AddressStats{}, fmt.Errorf("bad address stats length %d", len(v))
+	}
+	return AddressStats{
+		RegisteredHeight:    int32(binary.BigEndian.Uint32(v[0:4])),
+		FirstReceivedHeight: int32(binary.BigEndian.Uint32(v[4:8])),
+		LastSeenHeight:      int32(binary.BigEndian.Uint32(v[8:12])),
+	}, nil
+}
+
+// registerAddresses creates stats of addresses which have none yet.
+func registerAddresses(db walletdb.DB, height int32, addrs []string) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket, err := tx.CreateTopLevelBucket(addressActivityBucket)
+		if err != nil {
+			return err
+		}
+		v := encodeAddressStats(AddressStats{RegisteredHeight: height})
+		for _, addr := range addrs {
+			if bucket.Get([]byte(addr)) != nil {
+				continue
+			}
+			if err := bucket.Put([]byte(addr), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recordActivity updates stats of registered addresses which received funds
+// or were otherwise seen at the height. Unregistered addresses are ignored.
+func recordActivity(db walletdb.DB, height int32, received, seen []string) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadWriteBucket(addressActivityBucket)
+		if bucket == nil {
+			return nil
+		}
+		update := func(addr string, receivedFunds bool) error {
+			v := bucket.Get([]byte(addr))
+			if v == nil {
+				return nil
+			}
+			stats, err := decodeAddressStats(v)
+			if err != nil {
+				return err
+			}
+			if receivedFunds && stats.FirstReceivedHeight == 0 {
+				stats.FirstReceivedHeight = height
+			}
+			if height > stats.LastSeenHeight {
+				stats.LastSeenHeight = height
+			}
+			return bucket.Put([]byte(addr), encodeAddressStats(stats))
+		}
+		for _, addr := range received {
+			if err := update(addr, true); err != nil {
+				return err
+			}
+		}
+		for _, addr := range seen {
+			if err := update(addr, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pruneAddressStats removes stats of addresses which are not watched and were
+// last registered or seen below the height, and returns their number.
+func pruneAddressStats(db walletdb.DB, below int32, watched map[string]bool) (int, error) {
+	var n int
+	err := walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadWriteBucket(addressActivityBucket)
+		if bucket == nil {
+			return nil
+		}
+		var keys [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if watched[string(k)] {
+				return nil
+			}
+			stats, err := decodeAddressStats(v)
+			if err != nil {
+				return err
+			}
+			if stats.RegisteredHeight < below && stats.LastSeenHeight < below {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+// loadAddressStats returns stats of the address. found is false if the
+// address was never registered.
+func loadAddressStats(db walletdb.DB, addr string) (stats AddressStats, found bool, err error) {
+	err = walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(addressActivityBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(addr))
+		if v == nil {
+			return nil
+		}
+		found = true
+		stats, err = decodeAddressStats(v)
+		return err
+	})
+	return
+}
+
+// findMatchedSpend returns the stored matched tx spending the outpoint, with
+// its height.
+func findMatchedSpend(db walletdb.DB, outpoint wire.OutPoint) (height int32, msgTx *wire.MsgTx, found bool, err error) {
+	err = walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(matchedTxsBucket)
+		spends := tx.ReadBucket(matchedSpendsBucket)
+		if bucket == nil || spends == nil {
+			return nil
+		}
+		k := spends.Get(outPointKey(outpoint))
+		if k == nil {
+			return nil
+		}
+		v := bucket.Get(k)
+		if v == nil {
+			return nil
+		}
+		_, msgTx, err = decodeMatchedTx(v)
+		if err != nil {
+			return err
+		}
+		height = int32(binary.BigEndian.Uint32(k[:4]))
+		found = true
+		return nil
+	})
+	return
+}
+
+// findMatchedTxByID returns the stored matched tx with the txid, with its
+// height.
+func findMatchedTxByID(db walletdb.DB, txid *chainhash.Hash) (height int32, msgTx *wire.MsgTx, found bool, err error) {
+	err = walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(matchedTxsBucket)
+		index := tx.ReadBucket(matchedTxIDsBucket)
+		if bucket == nil || index == nil {
+			return nil
+		}
+		k := index.Get(txid[:])
+		if k == nil {
+			return nil
+		}
+		v := bucket.Get(k)
+		if v == nil {
+			return nil
+		}
+		_, msgTx, err = decodeMatchedTx(v)
+		if err != nil {
+			return err
+		}
+		height = int32(binary.BigEndian.Uint32(k[:4]))
+		found = true
+		return nil
+	})
+	return
+}
+
+// matchedTxHeight returns the height a matched tx was stored for, also if it
+// was pruned since.
+func matchedTxHeight(db walletdb.DB, txid *chainhash.Hash) (height int32, found bool, err error) {
+	err = walletdb.View(db, func(tx walletdb.ReadTx) error {
+		index := tx.ReadBucket(matchedTxIDsBucket)
+		if index == nil {
+			return nil
+		}
+		k := index.Get(txid[:])
+		if k == nil {
+			return nil
+		}
+		height = int32(binary.BigEndian.Uint32(k[:4]))
+		found = true
+		return nil
+	})
+	return
+}
+
+func putConfirmationWatch(db walletdb.DB, txid *chainhash.Hash, target, minedHeight int32) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket, err := tx.CreateTopLevelBucket(confirmationsBucket)
+		if err != nil {
+			return err
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint32(v[:4], uint32(target))
+		binary.BigEndian.PutUint32(v[4:], uint32(minedHeight))
+		return bucket.Put(txid[:], v)
+	})
+}
+
+// processConfirmations marks pending watches of relevantTxs as mined at the
+// height and removes and returns watches which reached their targets. Targets
+// of coinbase txs are raised to coinbaseMaturity. The
+// watches are read in a read-only transaction and written only if one of
+// them changed, so blocks without relevant watches do not write.
+func processConfirmations(db walletdb.DB, height int32, relevantTxs []*btcutil.Tx, coinbaseMaturity int32) ([]TxConfirmation, error) {
+	var confirmed []TxConfirmation
+	read := make(map[chainhash.Hash][]byte)
+	updates := make(map[chainhash.Hash][]byte)
+	err := walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(confirmationsBucket)
+		if bucket == nil {
+			return nil
+		}
+		// relevant maps txids of relevantTxs to whether they are
+		// coinbase.
+		relevant := make(map[chainhash.Hash]bool, len(relevantTxs))
+		for _, t := range relevantTxs {
+			relevant[*t.Hash()] = blockchain.IsCoinBase(t)
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var txid chainhash.Hash
+			copy(txid[:], k)
+			target := int32(binary.BigEndian.Uint32(v[:4]))
+			minedHeight := int32(binary.BigEndian.Uint32(v[4:]))
+			if coinbase, ok := relevant[txid]; ok && minedHeight == 0 {
+				read[txid] = append([]byte(nil), v...)
+				minedHeight = height
+				if coinbase && target < coinbaseMaturity {
+					target = coinbaseMaturity
+				}
+				v = make([]byte, 8)
+				binary.BigEndian.PutUint32(v[:4], uint32(target))
+				binary.BigEndian.PutUint32(v[4:], uint32(minedHeight))
+				updates[txid] = v
+			}
+			if minedHeight != 0 && height-minedHeight+1 >= target {
+				if read[txid] == nil {
+					read[txid] = append([]byte(nil), v...)
+				}
+				confirmed = append(confirmed, TxConfirmation{
+					TxID:          txid,
+					Height:        minedHeight,
+					Confirmations: height - minedHeight + 1,
+				})
+				updates[txid] = nil
+			}
+			return nil
+		})
+	})
+	if err != nil || len(updates) == 0 {
+		return confirmed, err
+	}
+	err = walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadWriteBucket(confirmationsBucket)
+		for txid, v := range updates {
+			txid := txid
+			// WatchConfirmations may have replaced the watch since
+			// it was read.
+			if !bytes.Equal(bucket.Get(txid[:]), read[txid]) {
+				continue
+			}
+			if v == nil {
+				if err := bucket.Delete(txid[:]); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := bucket.Put(txid[:], v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return confirmed, nil
+}
+
+// unmineConfirmations marks pending watches of txs mined at the height or
+// above as not mined, when the block at the height was disconnected.
+func unmineConfirmations(db walletdb.DB, height int32) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket := tx.ReadWriteBucket(confirmationsBucket)
+		if bucket == nil {
+			return nil
+		}
+		updates := make(map[string][]byte)
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if int32(binary.BigEndian.Uint32(v[4:])) >= height {
+				v = append([]byte(nil), v...)
+				binary.BigEndian.PutUint32(v[4:], 0)
+				updates[string(k)] = v
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, v := range updates {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func outPointKey(op wire.OutPoint) []byte {
+	key := make([]byte, chainhash.HashSize+4)
+	copy(key, op.Hash[:])
+	binary.BigEndian.PutUint32(key[chainhash.HashSize:], op.Index)
+	return key
+}
+
+// putProcessedOutPoints applies outpoint changes of FullWatcher in order and
+// stores the processed height in one transaction.
+func putProcessedOutPoints(db walletdb.DB, height int32, changes []outPointChange) error {
+	return walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket, err := tx.CreateTopLevelBucket(fullOutPointsBucket)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			for _, op := range c.added {
+				if err := bucket.Put(outPointKey(op), nil); err != nil {
+					return err
+				}
+			}
+			for _, op := range c.spent {
+				if err := bucket.Delete(outPointKey(op)); err != nil {
+					return err
+				}
+			}
+		}
+		bucket, err = tx.CreateTopLevelBucket(processedHeightBucket)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, uint32(height))
+		return bucket.Put(processedHeightKey, value)
+	})
+}
+
+// loadOutPoints returns outpoints stored by putProcessedOutPoints.
+func loadOutPoints(db walletdb.DB) (map[wire.OutPoint]bool, error) {
+	outPoints := make(map[wire.OutPoint]bool)
+	err := walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(fullOutPointsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if len(k) != chainhash.HashSize+4 {
+				return fmt.Errorf("bad outpoint length %d", len(k))
+			}
+			var op wire.OutPoint
+			copy(op.Hash[:], k)
+			op.Index = binary.BigEndian.Uint32(k[chainhash.HashSize:])
+			outPoints[op] = true
+			return nil
+		})
+	})
+	return outPoints, err
+}
+
+func loadProcessedHeight(db walletdb.DB) (height int32, found bool, err error) {
+	err = walletdb.View(db, func(tx walletdb.ReadTx) error {
+		bucket := tx.ReadBucket(processedHeightBucket)
+		if bucket == nil {
+			return nil
+		}
+		value := bucket.Get(processedHeightKey)
+		if len(value) != 4 {
+			return nil
+		}
+		height = int32(binary.BigEndian.Uint32(value))
+		found = true
+		return nil
+	})
+	return
+}
@@ -0,0 +1,352 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcwallet/walletdb"
+)
+
+func openTestDB(t *testing.T) (walletdb.DB, func()) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := walletdb.Create("bdb", filepath.Join(tmpDir, "wallet.db"), true)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func makeTestTx(lockTime uint32) *btcutil.Tx {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.LockTime = lockTime
+	msgTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	return btcutil.NewTx(msgTx)
+}
+
+func TestReplay(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	w := &Watcher{db: db}
+
+	header := &wire.BlockHeader{Nonce: 1}
+	tx1, tx2, tx3 := makeTestTx(1), makeTestTx(2), makeTestTx(3)
+	if err := storeMatchedTxs(db, 10, header, []*btcutil.Tx{tx1, tx2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeMatchedTxs(db, 12, header, []*btcutil.Tx{tx1}); err != nil {
+		t.Fatal(err)
+	}
+	// Height 12 is connected again after a reorg.
+	if err := storeMatchedTxs(db, 12, header, []*btcutil.Tx{tx3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeMatchedTxs(db, 15, header, []*btcutil.Tx{tx2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteMatchedTxs(db, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := func(since int32) map[int32][]string {
+		got := make(map[int32][]string)
+		handlers := rpcclient.NotificationHandlers{
+			OnFilteredBlockConnected: func(height int32, h *wire.BlockHeader, txs []*btcutil.Tx) {
+				if h.Nonce != header.Nonce {
+					t.Errorf("Height %d: got header with nonce %d.", height, h.Nonce)
+				}
+				for _, tx := range txs {
+					got[height] = append(got[height], tx.Hash().String())
+				}
+			},
+		}
+		if err := w.Replay(handlers, since); err != nil {
+			t.Fatalf("Replay: %v.", err)
+		}
+		return got
+	}
+
+	got := replay(0)
+	if len(got) != 2 || len(got[10]) != 2 || len(got[12]) != 1 {
+		t.Fatalf("Replay(0) = %v, want heights 10 (2 txs) and 12 (1 tx).", got)
+	}
+	if got[10][0] != tx1.Hash().String() || got[10][1] != tx2.Hash().String() {
+		t.Errorf("Replay(0) at height 10 = %v, want [%s %s].", got[10], tx1.Hash(), tx2.Hash())
+	}
+	if got[12][0] != tx3.Hash().String() {
+		t.Errorf("Replay(0) at height 12 = %v, want [%s].", got[12], tx3.Hash())
+	}
+
+	got = replay(11)
+	if len(got) != 1 || len(got[12]) != 1 {
+		t.Errorf("Replay(11) = %v, want only height 12.", got)
+	}
+
+	snapshot, err := snapshotBuckets(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2, cleanup2 := openTestDB(t)
+	defer cleanup2()
+	w.db = db2
+	if err := restoreBuckets(w.db, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	got = replay(0)
+	if len(got) != 2 {
+		t.Errorf("Replay(0) after restoreBuckets = %v, want heights 10 and 12.", got)
+	}
+}
+
+// makeTestCoinbase returns a coinbase tx paying to script 0x51.
+func makeTestCoinbase(height uint32) *btcutil.Tx {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.LockTime = height
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	return btcutil.NewTx(msgTx)
+}
+
+func TestPruneMatchedTxs(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	header := &wire.BlockHeader{}
+	for _, height := range []int32{10, 11, 12} {
+		txs := []*btcutil.Tx{makeTestTx(uint32(height)), makeTestTx(uint32(height) + 100)}
+		if err := storeMatchedTxs(db, height, header, txs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := pruneMatchedTxs(db, 12)
+	if err != nil {
+		t.Fatalf("pruneMatchedTxs: %v.", err)
+	}
+	if n != 4 {
+		t.Errorf("pruneMatchedTxs removed %d records, want 4.", n)
+	}
+	height, _, txs, found, err := loadMatchedTxs(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || height != 12 || len(txs) != 2 {
+		t.Errorf("After pruning lowest height is %d with %d txs (found=%v), want 12 with 2 txs.", height, len(txs), found)
+	}
+}
+
+func TestMatchedTxIndex(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	header := &wire.BlockHeader{}
+	tx1, tx2 := makeTestTx(1), makeTestTx(2)
+	if err := storeMatchedTxs(db, 10, header, []*btcutil.Tx{tx1, tx2}); err != nil {
+		t.Fatal(err)
+	}
+	// tx2 is mined again at 11 after a reorg, the stale height is deleted
+	// afterwards.
+	if err := storeMatchedTxs(db, 11, header, []*btcutil.Tx{tx2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteMatchedTxs(db, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, found, err := findMatchedTxByID(db, tx1.Hash()); err != nil || found {
+		t.Errorf("findMatchedTxByID of a deleted tx: found=%v, err=%v, want not found.", found, err)
+	}
+	height, msgTx, found, err := findMatchedTxByID(db, tx2.Hash())
+	if err != nil || !found || height != 11 || msgTx.TxHash() != *tx2.Hash() {
+		t.Errorf("findMatchedTxByID = %d (found=%v, err=%v), want height 11.", height, found, err)
+	}
+
+	if _, err := pruneMatchedTxs(db, 12); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, found, err := findMatchedTxByID(db, tx2.Hash()); err != nil || found {
+		t.Errorf("findMatchedTxByID of a pruned tx: found=%v, err=%v, want not found.", found, err)
+	}
+	if height, found, err := matchedTxHeight(db, tx2.Hash()); err != nil || !found || height != 11 {
+		t.Errorf("matchedTxHeight of a pruned tx = %d (found=%v, err=%v), want height 11.", height, found, err)
+	}
+
+	// A database without the index is indexed on open.
+	if err := storeMatchedTxs(db, 12, header, []*btcutil.Tx{tx1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		if err := tx.DeleteTopLevelBucket(matchedTxIDsBucket); err != nil {
+			return err
+		}
+		return tx.DeleteTopLevelBucket(matchedSpendsBucket)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexMatchedTxs(db); err != nil {
+		t.Fatalf("indexMatchedTxs: %v.", err)
+	}
+	if height, _, found, err := findMatchedTxByID(db, tx1.Hash()); err != nil || !found || height != 12 {
+		t.Errorf("findMatchedTxByID after indexMatchedTxs = %d (found=%v, err=%v), want height 12.", height, found, err)
+	}
+	if height, _, found, err := findMatchedSpend(db, tx1.MsgTx().TxIn[0].PreviousOutPoint); err != nil || !found || height != 12 {
+		t.Errorf("findMatchedSpend after indexMatchedTxs = %d (found=%v, err=%v), want height 12.", height, found, err)
+	}
+}
+
+func TestAddressActivity(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	w := &Watcher{db: db}
+
+	const addr, other = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", "1BitcoinEaterAddressDontSendf59kuE"
+	if err := registerAddresses(db, 100, []string{addr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordActivity(db, 105, []string{addr, other}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordActivity(db, 110, nil, []string{addr}); err != nil {
+		t.Fatal(err)
+	}
+	// Registering again keeps the stats.
+	if err := registerAddresses(db, 120, []string{addr}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := w.AddressActivity(addr)
+	if err != nil {
+		t.Fatalf("AddressActivity: %v.", err)
+	}
+	want := AddressStats{RegisteredHeight: 100, FirstReceivedHeight: 105, LastSeenHeight: 110}
+	if stats != want {
+		t.Errorf("AddressActivity = %+v, want %+v.", stats, want)
+	}
+	if _, err := w.AddressActivity(other); err != ErrUnknownAddress {
+		t.Errorf("AddressActivity of unregistered address returned %v, want ErrUnknownAddress.", err)
+	}
+
+	// Stats of watched addresses and of addresses seen recently are kept.
+	for _, tc := range []struct {
+		below   int32
+		watched bool
+		want    int
+	}{{111, true, 0}, {110, false, 0}, {111, false, 1}} {
+		n, err := pruneAddressStats(db, tc.below, map[string]bool{addr: tc.watched})
+		if err != nil {
+			t.Fatalf("pruneAddressStats: %v.", err)
+		}
+		if n != tc.want {
+			t.Errorf("pruneAddressStats(%d, watched=%v) removed %d, want %d.", tc.below, tc.watched, n, tc.want)
+		}
+	}
+	if _, err := w.AddressActivity(addr); err != ErrUnknownAddress {
+		t.Errorf("AddressActivity of pruned address returned %v, want ErrUnknownAddress.", err)
+	}
+}
+
+func TestConfirmationWatches(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	w := &Watcher{
+		db:     db,
+		params: &chaincfg.RegressionNetParams,
+		opts:   newOptions([]Option{WithDefaultConfirmations(2)}),
+	}
+
+	tx1, tx2 := makeTestTx(1), makeTestTx(2)
+	if err := storeMatchedTxs(db, 10, &wire.BlockHeader{}, []*btcutil.Tx{tx1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WatchConfirmations(*tx1.Hash(), 3); err != nil {
+		t.Fatalf("WatchConfirmations: %v.", err)
+	}
+	if err := w.WatchConfirmations(*tx2.Hash(), 0); err != nil {
+		t.Fatalf("WatchConfirmations: %v.", err)
+	}
+
+	process := func(height int32, txs ...*btcutil.Tx) []TxConfirmation {
+		confirmed, err := processConfirmations(db, height, txs, 5)
+		if err != nil {
+			t.Fatalf("processConfirmations: %v.", err)
+		}
+		return confirmed
+	}
+	if got := process(11); len(got) != 0 {
+		t.Errorf("At height 11 confirmed %v, want none.", got)
+	}
+	got := process(12, tx2)
+	want := TxConfirmation{TxID: *tx1.Hash(), Height: 10, Confirmations: 3}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("At height 12 confirmed %v, want %v.", got, want)
+	}
+
+	// The block with tx2 is reorged out, and tx2 is mined again later.
+	if err := unmineConfirmations(db, 12); err != nil {
+		t.Fatal(err)
+	}
+	if got := process(12); len(got) != 0 {
+		t.Errorf("At height 12 after reorg confirmed %v, want none.", got)
+	}
+	process(13, tx2)
+	got = process(14)
+	want = TxConfirmation{TxID: *tx2.Hash(), Height: 13, Confirmations: 2}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("At height 14 confirmed %v, want %v.", got, want)
+	}
+	if got := process(15); len(got) != 0 {
+		t.Errorf("At height 15 confirmed %v, want none.", got)
+	}
+
+	// A watch of a tx pruned by Compact fires with its stored height.
+	if _, err := pruneMatchedTxs(db, 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WatchConfirmations(*tx1.Hash(), 3); err != nil {
+		t.Fatalf("WatchConfirmations: %v.", err)
+	}
+	got = process(16)
+	want = TxConfirmation{TxID: *tx1.Hash(), Height: 10, Confirmations: 7}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("At height 16 confirmed %v, want %v for the pruned tx.", got, want)
+	}
+
+	// Coinbase txs need to mature, 5 blocks in process and
+	// params.CoinbaseMaturity if the tx is stored already.
+	coinbase, stored := makeTestCoinbase(20), makeTestCoinbase(30)
+	if err := w.WatchConfirmations(*coinbase.Hash(), 0); err != nil {
+		t.Fatalf("WatchConfirmations: %v.", err)
+	}
+	if got := process(23, coinbase); len(got) != 0 {
+		t.Errorf("At height 23 confirmed %v, want none before the coinbase matured.", got)
+	}
+	got = process(27)
+	want = TxConfirmation{TxID: *coinbase.Hash(), Height: 23, Confirmations: 5}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("At height 27 confirmed %v, want %v.", got, want)
+	}
+	if err := storeMatchedTxs(db, 30, &wire.BlockHeader{}, []*btcutil.Tx{stored}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WatchConfirmations(*stored.Hash(), 0); err != nil {
+		t.Fatalf("WatchConfirmations: %v.", err)
+	}
+	if got := process(128); len(got) != 0 {
+		t.Errorf("At height 128 confirmed %v, want none before the coinbase matured.", got)
+	}
+	if got := process(129); len(got) != 1 || got[0].Confirmations != 100 {
+		t.Errorf("At height 129 confirmed %v, want the coinbase with 100 confirmations.", got)
+	}
+}
@@ -0,0 +1,77 @@
+package watch
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// BlockEvent is a block processed by the watcher with its relevant
+// transactions, as passed to OnFilteredBlockConnected, or a block
+// disconnected by a reorg.
+//
+// Events are sent in the order of the chain: on a reorg, disconnected blocks
+// come from the old tip down, followed by blocks of the new chain from the
+// fork up, so a consumer can roll back its state for each disconnected block
+// before applying the new ones.
+type BlockEvent struct {
+	Height int32
+	Header *wire.BlockHeader
+	Txs    []*btcutil.Tx
+
+	// Disconnected is true if the block was removed from the chain. Txs
+	// is empty then; roll back what was applied for the height.
+	Disconnected bool
+}
+
+const defaultSubscribeBuffer = 100
+
+// Subscribe returns a channel receiving a BlockEvent for each block processed
+// after StartWatching, which may be called with empty handlers. The buffer
+// and policy for slow consumers are set by WithSubscribeBuffer. The channel is
+// closed by Close.
+func (w *Watcher) Subscribe() (<-chan BlockEvent, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.fullClose:
+		return nil, ErrClosed
+	default:
+	}
+	events := make(chan BlockEvent, w.opts.subscribeBuffer)
+	w.subscribers = append(w.subscribers, events)
+	return events, nil
+}
+
+// publishBlock sends the event to subscribers. It returns early if quit is
+// closed while waiting for a slow consumer.
+func (w *Watcher) publishBlock(event BlockEvent, quit <-chan struct{}) {
+	w.mu.Lock()
+	subscribers := append([]chan BlockEvent(nil), w.subscribers...)
+	w.mu.Unlock()
+	for _, events := range subscribers {
+		if w.opts.subscribeDrop {
+			select {
+			case events <- event:
+			default:
+				w.opts.logWarn("Subscriber is slow, dropped block", "height", event.Height)
+			}
+			continue
+		}
+		select {
+		case events <- event:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// closeSubscribers closes channels returned by Subscribe. The rescan must be
+// stopped, so nothing sends to them.
+func (w *Watcher) closeSubscribers() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, events := range w.subscribers {
+		close(events)
+	}
+	w.subscribers = nil
+}
@@ -0,0 +1,96 @@
+package watch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestSubscribe(t *testing.T) {
+	quit := make(chan struct{})
+	watcher := &Watcher{
+		opts:      newOptions([]Option{WithSubscribeBuffer(1, true)}),
+		fullClose: make(chan struct{}),
+	}
+	events, err := watcher.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe: %v.", err)
+	}
+
+	header := &wire.BlockHeader{}
+	watcher.publishBlock(BlockEvent{Height: 10, Header: header}, quit)
+	// The buffer is full, so it is dropped.
+	watcher.publishBlock(BlockEvent{Height: 11, Header: header}, quit)
+	if event := <-events; event.Height != 10 || event.Header != header {
+		t.Errorf("received %+v, want block 10.", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("received %+v, want it dropped.", event)
+	default:
+	}
+
+	// Blocking policy returns when quit is closed.
+	watcher.opts.subscribeDrop = false
+	watcher.publishBlock(BlockEvent{Height: 12}, quit)
+	close(quit)
+	watcher.publishBlock(BlockEvent{Height: 13}, quit)
+
+	watcher.closeSubscribers()
+	if event := <-events; event.Height != 12 {
+		t.Errorf("received %+v, want block 12.", event)
+	}
+	if _, open := <-events; open {
+		t.Errorf("channel is not closed by closeSubscribers.")
+	}
+
+	close(watcher.fullClose)
+	if _, err := watcher.Subscribe(); err != ErrClosed {
+		t.Errorf("Subscribe after Close returned %v, want ErrClosed.", err)
+	}
+}
+
+func TestSubscribeReorgOrder(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{
+		db:        db,
+		params:    &chaincfg.MainNetParams,
+		opts:      newOptions([]Option{WithLogger(&testLogger{})}),
+		blocks:    newBlockCache(10),
+		fullClose: make(chan struct{}),
+	}
+	events, err := watcher.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe: %v.", err)
+	}
+	ntfn := watcher.rescanHandlers(rpcclient.NotificationHandlers{}, make(chan struct{}))
+
+	// Headers of the old chain have nonce 1, of the new chain nonce 2.
+	// Neutrino disconnects blocks from the tip down, then connects the
+	// new chain.
+	ntfn.OnFilteredBlockConnected(10, &wire.BlockHeader{Nonce: 1}, nil)
+	ntfn.OnFilteredBlockConnected(11, &wire.BlockHeader{Nonce: 1}, nil)
+	ntfn.OnFilteredBlockDisconnected(11, &wire.BlockHeader{Nonce: 1})
+	ntfn.OnFilteredBlockDisconnected(10, &wire.BlockHeader{Nonce: 1})
+	ntfn.OnFilteredBlockConnected(10, &wire.BlockHeader{Nonce: 2}, nil)
+	ntfn.OnFilteredBlockConnected(11, &wire.BlockHeader{Nonce: 2}, nil)
+	watcher.closeSubscribers()
+
+	type step struct {
+		height       int32
+		nonce        uint32
+		disconnected bool
+	}
+	var got []step
+	for event := range events {
+		got = append(got, step{event.Height, event.Header.Nonce, event.Disconnected})
+	}
+	want := []step{{10, 1, false}, {11, 1, false}, {11, 1, true}, {10, 1, true}, {10, 2, false}, {11, 2, false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v.", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+// The pinned btcutil predates taproot, so bech32m (BIP350) and P2TR
+// addresses are handled here. Neutrino can not watch such addresses, so
+// their pkScripts are matched like scripts passed to AddScripts.
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32mConst  = 0x2bc830a3
+)
+
+func bech32mPolymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generator {
+			if (top>>uint(i))&1 != 0 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func bech32mHRPExpand(hrp string) []byte {
+	values := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return values
+}
+
+// taprootAddress is a P2TR address, segwit version 1 with a 32-byte program.
+// It implements btcutil.Address.
+type taprootAddress struct {
+	hrp     string
+	program [32]byte
+}
+
+func (a *taprootAddress) EncodeAddress() string {
+	program, err := bech32.ConvertBits(a.program[:], 8, 5, true)
+	if err != nil {
+		// Can not fail for 8 to 5 bits with padding.
+		panic(err)
+	}
+	data := append([]byte{1}, program...)
+	values := append(bech32mHRPExpand(a.hrp), data...)
+	chk := bech32mPolymod(append(values, 0, 0, 0, 0, 0, 0)) ^ bech32mConst
+	var b strings.Builder
+	b.WriteString(a.hrp)
+	b.WriteByte('1')
+	for _, v := range data {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[(chk>>uint(5*(5-i)))&31])
+	}
+	return b.String()
+}
+
+func (a *taprootAddress) String() string {
+	return a.EncodeAddress()
+}
+
+// ScriptAddress returns the witness program.
+func (a *taprootAddress) ScriptAddress() []byte {
+	return a.program[:]
+}
+
+func (a *taprootAddress) IsForNet(params *chaincfg.Params) bool {
+	return a.hrp == params.Bech32HRPSegwit
+}
+
+// pkScript returns OP_1 <program>.
+func (a *taprootAddress) pkScript() []byte {
+	return append([]byte{txscript.OP_1, txscript.OP_DATA_32}, a.program[:]...)
+}
+
+// isTaprootCandidate tells cheaply whether addr may be a P2TR address of the
+// network, to avoid decoding other addresses twice.
+func isTaprootCandidate(addr string, params *chaincfg.Params) bool {
+	prefix := params.Bech32HRPSegwit + "1p"
+	return len(addr) > len(prefix) && strings.EqualFold(addr[:len(prefix)], prefix)
+}
+
+// decodeTaprootAddress decodes a bech32m P2TR address of the network.
+func decodeTaprootAddress(addr string, params *chaincfg.Params) (*taprootAddress, error) {
+	if !isTaprootCandidate(addr, params) {
+		return nil, errors.New("not a taproot address of the network")
+	}
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return nil, errors.New("mixed case")
+	}
+	addr = strings.ToLower(addr)
+	hrp := params.Bech32HRPSegwit
+	encoded := addr[len(hrp)+1:]
+	if len(encoded) < 7 {
+		return nil, errors.New("too short")
+	}
+	data := make([]byte, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		v := strings.IndexByte(bech32Charset, encoded[i])
+		if v < 0 {
+			return nil, fmt.Errorf("invalid character %q", encoded[i])
+		}
+		data[i] = byte(v)
+	}
+	if bech32mPolymod(append(bech32mHRPExpand(hrp), data...)) != bech32mConst {
+		return nil, errors.New("bad bech32m checksum")
+	}
+	program, err := bech32.ConvertBits(data[1:len(data)-6], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("bech32.ConvertBits: %w", err)
+	}
+	if data[0] != 1 || len(program) != 32 {
+		return nil, errors.New("not a segwit v1 program of 32 bytes")
+	}
+	a := &taprootAddress{hrp: hrp}
+	copy(a.program[:], program)
+	return a, nil
+}
+
+// taprootScriptAddress returns the address of a P2TR pkScript or nil.
+func taprootScriptAddress(pkScript []byte, params *chaincfg.Params) *taprootAddress {
+	if len(pkScript) != 34 || pkScript[0] != txscript.OP_1 || pkScript[1] != txscript.OP_DATA_32 {
+		return nil
+	}
+	a := &taprootAddress{hrp: params.Bech32HRPSegwit}
+	copy(a.program[:], pkScript[2:])
+	return a
+}
+
+// taprootScripts returns pkScripts of P2TR addresses among addrs, keyed by
+// address. Other addresses are skipped.
+func taprootScripts(addrs []string, params *chaincfg.Params) map[string][]byte {
+	var scripts map[string][]byte
+	for _, addr := range addrs {
+		a, err := decodeTaprootAddress(addr, params)
+		if err != nil {
+			continue
+		}
+		if scripts == nil {
+			scripts = make(map[string][]byte)
+		}
+		scripts[addr] = a.pkScript()
+	}
+	return scripts
+}
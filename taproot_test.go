@@ -0,0 +1,83 @@
+package watch
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// BIP350 test vector: a P2TR output to the x-only generator point.
+const (
+	testTaprootAddress = "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"
+	testTaprootScript  = "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+)
+
+func TestTaprootAddress(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	pkScript, err := hex.DecodeString(testTaprootScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := decodeTaprootAddress(testTaprootAddress, params)
+	if err != nil {
+		t.Fatalf("decodeTaprootAddress: %v.", err)
+	}
+	if got := hex.EncodeToString(a.pkScript()); got != testTaprootScript {
+		t.Errorf("pkScript = %s, want %s.", got, testTaprootScript)
+	}
+	if got := taprootScriptAddress(pkScript, params).EncodeAddress(); got != testTaprootAddress {
+		t.Errorf("EncodeAddress = %s, want %s.", got, testTaprootAddress)
+	}
+
+	for _, bad := range []string{
+		// Checksum of a changed character.
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj1",
+		// BIP173 example of v1 with a bech32 checksum.
+		"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7k7grplx",
+		// Testnet address on mainnet.
+		(&taprootAddress{hrp: chaincfg.TestNet3Params.Bech32HRPSegwit, program: a.program}).EncodeAddress(),
+	} {
+		if _, err := decodeAddress(bad, params); err == nil {
+			t.Errorf("decodeAddress(%s) succeeded, want error.", bad)
+		}
+	}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	outputs := PrepareTxOutputs(btcutil.NewTx(msgTx), false)
+	if outputs[testTaprootAddress] != 1000 {
+		t.Errorf("PrepareTxOutputs = %v, want 1000 to %s.", outputs, testTaprootAddress)
+	}
+}
+
+func TestAddTaprootAddress(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	w := &Watcher{db: db, params: &chaincfg.MainNetParams, opts: newOptions(nil)}
+
+	if err := w.AddAddresses(testTaprootAddress); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	watched, err := w.watchedScripts()
+	if err != nil {
+		t.Fatalf("watchedScripts: %v.", err)
+	}
+	pkScript, _ := hex.DecodeString(testTaprootScript)
+	if !watched[string(pkScript)] {
+		t.Errorf("P2TR script is not watched.")
+	}
+
+	if err := w.RemoveAddresses(testTaprootAddress); err != nil {
+		t.Fatalf("RemoveAddresses: %v.", err)
+	}
+	w.mu.Lock()
+	scripts := w.rawScripts()
+	w.mu.Unlock()
+	if len(scripts) != 0 {
+		t.Errorf("P2TR script is still matched after RemoveAddresses.")
+	}
+}
@@ -0,0 +1,58 @@
+package watch
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// btcd v0.20 has no testnet4 parameters. These are of BIP 94, derived from
+// testnet3, whose address formats testnet4 shares. Neutrino checks
+// difficulty by testnet3 rules, while BIP 94 computes a retarget from the
+// first block of the period instead of the last one, so headers of a
+// retarget following a minimum difficulty block are rejected.
+
+var testNet4GenesisBlock = func() wire.MsgBlock {
+	const message = "03/May/2024 000000000000000000001ebd58c244970b3aa9d783bb001011fbe8ea8e98e00e"
+	// Pushes of 0x1d00ffff, 4 and the message, as in the genesis blocks of
+	// other networks. The 4 is data, not OP_4, which ScriptBuilder would use.
+	sigScript := append([]byte{0x04, 0xff, 0xff, 0x00, 0x1d, 0x01, 0x04, txscript.OP_PUSHDATA1, byte(len(message))}, message...)
+	// A 33 bytes long zero pubkey and OP_CHECKSIG.
+	pkScript := append(append([]byte{txscript.OP_DATA_33}, make([]byte, 33)...), txscript.OP_CHECKSIG)
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), sigScript, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50*1e8, pkScript))
+
+	return wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    1,
+			MerkleRoot: coinbase.TxHash(),
+			Timestamp:  time.Unix(1714777860, 0),
+			Bits:       0x1d00ffff,
+			Nonce:      393743547,
+		},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+}()
+
+var testNet4Params = func() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = "testnet4"
+	params.Net = wire.BitcoinNet(0x283f161c)
+	params.DefaultPort = "48333"
+	params.DNSSeeds = []chaincfg.DNSSeed{
+		{Host: "seed.testnet4.bitcoin.sprovoost.nl", HasFiltering: false},
+		{Host: "seed.testnet4.wiz.biz", HasFiltering: false},
+	}
+	genesisHash := testNet4GenesisBlock.BlockHash()
+	params.GenesisBlock = &testNet4GenesisBlock
+	params.GenesisHash = &genesisHash
+	params.BIP0034Height = 1
+	params.BIP0065Height = 1
+	params.BIP0066Height = 1
+	params.Checkpoints = nil
+	return params
+}()
@@ -0,0 +1,106 @@
+package watch
+
+import (
+	"errors"
+	"net"
+
+	"github.com/lightningnetwork/lnd/tor"
+	"golang.org/x/net/proxy"
+)
+
+// TorConfig configures connections to peers through the Tor SOCKS proxy.
+//
+// With StreamIsolation every peer connection gets its own circuit, so an exit
+// relay or a peer can't link our connections to each other. Without it
+// connections may share circuits: they are set up faster, but whoever sees a
+// shared circuit sees all peers we talk to over it.
+//
+// Username and Password, if set, are sent to the proxy instead of random
+// credentials. Tor isolates streams by SOCKS credentials (IsolateSOCKSAuth,
+// on by default), so all connections then share the identity named by them
+// and are kept apart from other Tor users on the host. StreamIsolation is
+// ignored in this case.
+type TorConfig struct {
+	StreamIsolation bool
+
+	Username, Password string
+}
+
+// defaultTorConfig isolates each connection, as before TorConfig was added.
+var defaultTorConfig = TorConfig{StreamIsolation: true}
+
+// WithTorConfig configures connections through Tor. By default every
+// connection uses a separate circuit. It has no effect without a Tor SOCKS
+// address.
+func WithTorConfig(cfg TorConfig) Option {
+	return func(o *options) {
+		o.tor = cfg
+	}
+}
+
+// torNet returns the dialer and resolver for the proxy at socks.
+func torNet(socks string, cfg TorConfig) tor.Net {
+	proxyNet := &tor.ProxyNet{
+		SOCKS:           socks,
+		StreamIsolation: cfg.StreamIsolation,
+	}
+	if cfg.Username == "" && cfg.Password == "" {
+		return proxyNet
+	}
+	return &torAuthNet{
+		ProxyNet: proxyNet,
+		auth:     &proxy.Auth{User: cfg.Username, Password: cfg.Password},
+	}
+}
+
+// torAuthNet dials through Tor with fixed SOCKS credentials.
+type torAuthNet struct {
+	*tor.ProxyNet
+	auth *proxy.Auth
+}
+
+func (p *torAuthNet) Dial(network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.New("cannot dial non-tcp network via Tor")
+	}
+	dialer, err := proxy.SOCKS5("tcp", p.SOCKS, p.auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	// Report the peer and not the proxy as the remote address, like
+	// tor.Dial does.
+	remoteAddr, err := peerAddr(address, p.SOCKS)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &torConn{Conn: conn, remoteAddr: remoteAddr}, nil
+}
+
+// peerAddr parses the address. tor.ParseAddr resolves even IP addresses
+// through Tor, so they are parsed here.
+func peerAddr(address, socks string) (net.Addr, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return net.ResolveTCPAddr("tcp", address)
+	}
+	return tor.ParseAddr(address, socks)
+}
+
+type torConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *torConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
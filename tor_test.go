@@ -0,0 +1,90 @@
+package watch
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/tor"
+)
+
+func TestTorNetDefault(t *testing.T) {
+	proxyNet, ok := torNet("127.0.0.1:9050", newOptions(nil).tor).(*tor.ProxyNet)
+	if !ok {
+		t.Fatalf("torNet returned %T, want *tor.ProxyNet.", proxyNet)
+	}
+	if !proxyNet.StreamIsolation {
+		t.Errorf("StreamIsolation is off by default.")
+	}
+
+	o := newOptions([]Option{WithTorConfig(TorConfig{})})
+	proxyNet = torNet("127.0.0.1:9050", o.tor).(*tor.ProxyNet)
+	if proxyNet.StreamIsolation {
+		t.Errorf("StreamIsolation is on after WithTorConfig turned it off.")
+	}
+}
+
+// serveSOCKS accepts one SOCKS5 connection with username/password auth and
+// sends the credentials to creds.
+func serveSOCKS(t *testing.T, l net.Listener, creds chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		t.Errorf("Accept: %v.", err)
+		close(creds)
+		return
+	}
+	defer conn.Close()
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Errorf("read: %v.", err)
+		}
+		return buf
+	}
+	methods := read(2)
+	read(int(methods[1]))
+	conn.Write([]byte{5, 2})
+	user := read(int(read(2)[1]))
+	password := read(int(read(1)[0]))
+	conn.Write([]byte{1, 0})
+	creds <- string(user) + ":" + string(password)
+	// CONNECT to an IPv4 address.
+	read(4 + 4 + 2)
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 1})
+	io.Copy(conn, conn)
+}
+
+func TestTorNetCredentials(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	creds := make(chan string, 1)
+	go serveSOCKS(t, l, creds)
+
+	proxyNet := torNet(l.Addr().String(), TorConfig{Username: "alice", Password: "secret"})
+	conn, err := proxyNet.Dial("tcp", "1.2.3.4:8333")
+	if err != nil {
+		t.Fatalf("Dial: %v.", err)
+	}
+	defer conn.Close()
+	if got := <-creds; got != "alice:secret" {
+		t.Errorf("proxy got credentials %q, want alice:secret.", got)
+	}
+	if got := conn.RemoteAddr().String(); got != "1.2.3.4:8333" {
+		t.Errorf("RemoteAddr is %s, want the peer address.", got)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v.", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, []byte("ping")) {
+		t.Errorf("read %q, %v through the proxy, want ping.", buf, err)
+	}
+
+	if _, err := proxyNet.Dial("udp", "1.2.3.4:8333"); err == nil {
+		t.Errorf("Dial of udp succeeded, want error.")
+	}
+}
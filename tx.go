@@ -1,29 +1,309 @@
 package watch
 
 import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 )
 
-func PrepareTxOutputs(tx *btcutil.Tx, testnet bool) map[string]btcutil.Amount {
-	params := &chaincfg.MainNetParams
-	if testnet {
-		params = &chaincfg.TestNet3Params
+var (
+	mainNetParser = NewOutputParser(&chaincfg.MainNetParams)
+	testNetParser = NewOutputParser(&chaincfg.TestNet3Params)
+)
+
+// OutputParser extracts amounts paid to addresses from transactions of one
+// network. It is safe for concurrent use.
+type OutputParser struct {
+	params   *chaincfg.Params
+	minValue btcutil.Amount
+}
+
+func NewOutputParser(params *chaincfg.Params) *OutputParser {
+	return &OutputParser{params: params}
+}
+
+// DustThreshold is the dust limit of P2PKH outputs at the default relay fee.
+// Use it with WithMinValue to skip spam outputs.
+const DustThreshold btcutil.Amount = 546
+
+// WithMinValue returns a parser which ignores outputs below min, so they
+// are not counted as deposits.
+func (p *OutputParser) WithMinValue(min btcutil.Amount) *OutputParser {
+	return &OutputParser{params: p.params, minValue: min}
+}
+
+// outputAddress returns the address paid by txOut. It returns false if the
+// script has no address or the value is below the minimum.
+func (p *OutputParser) outputAddress(txOut *wire.TxOut) (string, bool) {
+	if btcutil.Amount(txOut.Value) < p.minValue {
+		return "", false
 	}
+	return p.pkScriptAddress(txOut.PkScript)
+}
 
+// pkScriptAddress returns the address of pkScript, including P2TR which the
+// pinned txscript does not know.
+func (p *OutputParser) pkScriptAddress(script []byte) (string, bool) {
+	if a := taprootScriptAddress(script, p.params); a != nil {
+		return a.EncodeAddress(), true
+	}
+	pkScript, err := txscript.ParsePkScript(script)
+	if err != nil {
+		return "", false
+	}
+	a, err := pkScript.Address(p.params)
+	if err != nil {
+		return "", false
+	}
+	return a.EncodeAddress(), true
+}
+
+// ParseOutputs returns amounts paid by tx to each address.
+func (p *OutputParser) ParseOutputs(tx *btcutil.Tx) map[string]btcutil.Amount {
+	result := make(map[string]btcutil.Amount, len(tx.MsgTx().TxOut))
+	p.ParseOutputsInto(tx, result)
+	return result
+}
+
+// ParseOutputsInto adds amounts paid by tx to each address to result. It lets
+// hot paths reuse maps instead of allocating one per transaction.
+func (p *OutputParser) ParseOutputsInto(tx *btcutil.Tx, result map[string]btcutil.Amount) {
+	for _, txOut := range tx.MsgTx().TxOut {
+		addr, ok := p.outputAddress(txOut)
+		if !ok {
+			continue
+		}
+		result[addr] += btcutil.Amount(txOut.Value)
+	}
+}
+
+// ParseScriptOutputs returns amounts paid by tx to scripts without an address
+// form, e.g. bare multisig, keyed by hex-encoded pkScript. Unspendable
+// outputs, such as OP_RETURN, are skipped.
+func (p *OutputParser) ParseScriptOutputs(tx *btcutil.Tx) map[string]btcutil.Amount {
 	result := make(map[string]btcutil.Amount)
+	for _, txOut := range tx.MsgTx().TxOut {
+		if btcutil.Amount(txOut.Value) < p.minValue || txscript.IsUnspendable(txOut.PkScript) {
+			continue
+		}
+		if _, ok := p.outputAddress(txOut); ok {
+			continue
+		}
+		result[hex.EncodeToString(txOut.PkScript)] += btcutil.Amount(txOut.Value)
+	}
+	return result
+}
+
+// OutputRef is a single output of a transaction.
+type OutputRef struct {
+	Index  uint32
+	Amount btcutil.Amount
+}
 
+// ParseOutputRefs returns outputs of tx paying to each address, in order of
+// their indices. Several outputs paying to the same address are all listed.
+func (p *OutputParser) ParseOutputRefs(tx *btcutil.Tx) map[string][]OutputRef {
+	result := make(map[string][]OutputRef, len(tx.MsgTx().TxOut))
+	for i, txOut := range tx.MsgTx().TxOut {
+		addr, ok := p.outputAddress(txOut)
+		if !ok {
+			continue
+		}
+		result[addr] = append(result[addr], OutputRef{
+			Index:  uint32(i),
+			Amount: btcutil.Amount(txOut.Value),
+		})
+	}
+	return result
+}
+
+// OutputInfo describes one output of a transaction.
+type OutputInfo struct {
+	Index uint32
+
+	// Address is empty if the script has no address form.
+	Address string
+
+	// Class is the script class from txscript.GetScriptClass. The pinned
+	// btcd predates taproot, so P2TR outputs are NonStandardTy, though
+	// they have an Address.
+	Class  txscript.ScriptClass
+	Amount btcutil.Amount
+}
+
+// ParseOutputsDetailed returns all outputs of tx in order of their indices,
+// including ones without an address and below the minimum value.
+func (p *OutputParser) ParseOutputsDetailed(tx *btcutil.Tx) []OutputInfo {
+	txOuts := tx.MsgTx().TxOut
+	result := make([]OutputInfo, 0, len(txOuts))
+	for i, txOut := range txOuts {
+		info := OutputInfo{
+			Index:  uint32(i),
+			Class:  txscript.GetScriptClass(txOut.PkScript),
+			Amount: btcutil.Amount(txOut.Value),
+		}
+		info.Address, _ = p.pkScriptAddress(txOut.PkScript)
+		result = append(result, info)
+	}
+	return result
+}
+
+// DepositRecord is a payment to a watched address by a single output.
+type DepositRecord struct {
+	Address   string
+	Amount    btcutil.Amount
+	TxID      chainhash.Hash
+	Vout      uint32
+	Height    int32
+	BlockHash chainhash.Hash
+
+	// Confirmations is the number of confirmations when tip was at
+	// tipHeight.
+	Confirmations int32
+
+	// Coinbase is true if the output belongs to a coinbase tx, which can
+	// not be spent until it matures.
+	Coinbase bool
+}
+
+// Mature reports whether the output can be spent. Coinbase outputs need
+// params.CoinbaseMaturity confirmations, other outputs need one.
+func (d DepositRecord) Mature(params *chaincfg.Params) bool {
+	if d.Coinbase {
+		return d.Confirmations >= int32(params.CoinbaseMaturity)
+	}
+	return d.Confirmations >= 1
+}
+
+// DepositBalance sums amounts of records, separating outputs which can not be
+// spent yet.
+func DepositBalance(records []DepositRecord, params *chaincfg.Params) (mature, immature btcutil.Amount) {
+	for _, d := range records {
+		if d.Mature(params) {
+			mature += d.Amount
+		} else {
+			immature += d.Amount
+		}
+	}
+	return mature, immature
+}
+
+// Deposits returns a record for each output of tx paying to an address in
+// watched, in order of outputs. The tx was mined in block blockHash at height.
+func (p *OutputParser) Deposits(tx *btcutil.Tx, height int32, blockHash *chainhash.Hash, tipHeight int32, watched map[string]bool) []DepositRecord {
+	confirmations := tipHeight - height + 1
+	if confirmations < 0 {
+		confirmations = 0
+	}
+
+	coinbase := blockchain.IsCoinBase(tx)
+	var records []DepositRecord
+	for i, txOut := range tx.MsgTx().TxOut {
+		addr, ok := p.outputAddress(txOut)
+		if !ok || !watched[addr] {
+			continue
+		}
+		records = append(records, DepositRecord{
+			Address:       addr,
+			Amount:        btcutil.Amount(txOut.Value),
+			TxID:          *tx.Hash(),
+			Vout:          uint32(i),
+			Height:        height,
+			BlockHash:     *blockHash,
+			Confirmations: confirmations,
+			Coinbase:      coinbase,
+		})
+	}
+	return records
+}
+
+// blockDeposits returns payments to addrs in the block.
+func blockDeposits(params *chaincfg.Params, block *btcutil.Block, height, tipHeight int32, addrs []string) []DepositRecord {
+	watched := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		watched[addr] = true
+	}
+	parser := NewOutputParser(params)
+	var records []DepositRecord
+	for _, tx := range block.Transactions() {
+		records = append(records, parser.Deposits(tx, height, block.Hash(), tipHeight, watched)...)
+	}
+	return records
+}
+
+// IsRBFSignaling reports whether tx opts in to replace-by-fee (BIP 125) by
+// having an input with sequence below 0xfffffffe.
+func IsRBFSignaling(tx *btcutil.Tx) bool {
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+func networkParser(testnet bool) *OutputParser {
+	if testnet {
+		return testNetParser
+	}
+	return mainNetParser
+}
+
+func PrepareTxOutputs(tx *btcutil.Tx, testnet bool) map[string]btcutil.Amount {
+	return networkParser(testnet).ParseOutputs(tx)
+}
+
+// PrepareTxOutputsWithParams is PrepareTxOutputs for any chain.
+func PrepareTxOutputsWithParams(tx *btcutil.Tx, params *chaincfg.Params) map[string]btcutil.Amount {
+	return NewOutputParser(params).ParseOutputs(tx)
+}
+
+// PrepareTxScriptOutputs complements PrepareTxOutputs with outputs paying to
+// scripts without an address, see OutputParser.ParseScriptOutputs.
+func PrepareTxScriptOutputs(tx *btcutil.Tx, testnet bool) map[string]btcutil.Amount {
+	return networkParser(testnet).ParseScriptOutputs(tx)
+}
+
+// PrepareTxOutputsDetailed is PrepareTxOutputs keeping each output with its
+// script class, see OutputParser.ParseOutputsDetailed.
+func PrepareTxOutputsDetailed(tx *btcutil.Tx, params *chaincfg.Params) []OutputInfo {
+	return NewOutputParser(params).ParseOutputsDetailed(tx)
+}
+
+func PrepareTxOutputRefs(tx *btcutil.Tx, testnet bool) map[string][]OutputRef {
+	return networkParser(testnet).ParseOutputRefs(tx)
+}
+
+// PrepareTxOutputRefsMin is PrepareTxOutputRefs ignoring outputs below
+// minValue, e.g. DustThreshold.
+func PrepareTxOutputRefsMin(tx *btcutil.Tx, testnet bool, minValue btcutil.Amount) map[string][]OutputRef {
+	return networkParser(testnet).WithMinValue(minValue).ParseOutputRefs(tx)
+}
+
+// PrepareTxOpReturns returns data pushed by OP_RETURN outputs of tx, in order
+// of outputs and pushes. Outputs having anything but data pushes after
+// OP_RETURN, or a malformed push, are skipped. Empty pushes are omitted.
+func PrepareTxOpReturns(tx *btcutil.Tx) [][]byte {
+	var result [][]byte
 	for _, txOut := range tx.MsgTx().TxOut {
-		pkScript, err := txscript.ParsePkScript(txOut.PkScript)
-		if err != nil {
+		script := txOut.PkScript
+		if len(script) == 0 || script[0] != txscript.OP_RETURN || !txscript.IsPushOnlyScript(script[1:]) {
 			continue
 		}
-		a, err := pkScript.Address(params)
+		pushes, err := txscript.PushedData(script[1:])
 		if err != nil {
 			continue
 		}
-		result[a.EncodeAddress()] += btcutil.Amount(txOut.Value)
+		for _, data := range pushes {
+			if len(data) != 0 {
+				result = append(result, data)
+			}
+		}
 	}
 	return result
 }
@@ -0,0 +1,235 @@
+package watch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestPrepareTxOutputRefs(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	msgTx.AddTxOut(wire.NewTxOut(500, []byte{txscript.OP_RETURN}))
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	tx := btcutil.NewTx(msgTx)
+
+	want := []OutputRef{{Index: 0, Amount: 1000}, {Index: 2, Amount: 1000}}
+	refs := PrepareTxOutputRefs(tx, false)
+	if len(refs) != 1 || !reflect.DeepEqual(refs[addr], want) {
+		t.Errorf("PrepareTxOutputRefs = %v, want %s: %v.", refs, addr, want)
+	}
+
+	outputs := PrepareTxOutputs(tx, false)
+	if len(outputs) != 1 || outputs[addr] != 2000 {
+		t.Errorf("PrepareTxOutputs = %v, want %s: 2000.", outputs, addr)
+	}
+	if outputs := PrepareTxOutputsWithParams(tx, &chaincfg.MainNetParams); !reflect.DeepEqual(outputs, PrepareTxOutputs(tx, false)) {
+		t.Errorf("PrepareTxOutputsWithParams = %v, want %v.", outputs, PrepareTxOutputs(tx, false))
+	}
+
+	msgTx.AddTxOut(wire.NewTxOut(int64(DustThreshold)-1, pkScript))
+	msgTx.AddTxOut(wire.NewTxOut(0, pkScript))
+	tx = btcutil.NewTx(msgTx)
+	refs = PrepareTxOutputRefsMin(tx, false, DustThreshold)
+	if len(refs) != 1 || !reflect.DeepEqual(refs[addr], want) {
+		t.Errorf("PrepareTxOutputRefsMin = %v, want %s: %v.", refs, addr, want)
+	}
+	if refs := PrepareTxOutputRefs(tx, false); len(refs[addr]) != 4 {
+		t.Errorf("PrepareTxOutputRefs returned %d outputs with dust, want 4.", len(refs[addr]))
+	}
+}
+
+func TestDeposits(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	const watched, other = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", "1BitcoinEaterAddressDontSendf59kuE"
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	for _, addr := range []string{other, watched, watched} {
+		a, err := btcutil.DecodeAddress(addr, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScript, err := txscript.PayToAddrScript(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	}
+	tx := btcutil.NewTx(msgTx)
+	blockHash := chainhash.Hash{1}
+
+	records := NewOutputParser(params).Deposits(tx, 100, &blockHash, 105, map[string]bool{watched: true})
+	if len(records) != 2 {
+		t.Fatalf("Got %d records, want 2.", len(records))
+	}
+	want := DepositRecord{
+		Address:       watched,
+		Amount:        1000,
+		TxID:          *tx.Hash(),
+		Vout:          2,
+		Height:        100,
+		BlockHash:     blockHash,
+		Confirmations: 6,
+	}
+	if records[1] != want {
+		t.Errorf("Second record is %+v, want %+v.", records[1], want)
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	const watched = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	a, err := btcutil.DecodeAddress(watched, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{1, 2}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(5000, pkScript))
+	regular := wire.NewMsgTx(wire.TxVersion)
+	regular.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	regular.AddTxOut(wire.NewTxOut(1000, pkScript))
+
+	parser := NewOutputParser(params)
+	blockHash := chainhash.Hash{1}
+	w := map[string]bool{watched: true}
+	records := append(parser.Deposits(btcutil.NewTx(coinbase), 100, &blockHash, 150, w),
+		parser.Deposits(btcutil.NewTx(regular), 100, &blockHash, 150, w)...)
+	if len(records) != 2 || !records[0].Coinbase || records[1].Coinbase {
+		t.Fatalf("Got records %+v, want a coinbase and a regular one.", records)
+	}
+	if mature, immature := DepositBalance(records, params); mature != 1000 || immature != 5000 {
+		t.Errorf("DepositBalance at 51 confirmations = %v, %v, want 1000, 5000.", mature, immature)
+	}
+
+	records = parser.Deposits(btcutil.NewTx(coinbase), 100, &blockHash, 199, w)
+	if mature, immature := DepositBalance(records, params); mature != 5000 || immature != 0 {
+		t.Errorf("DepositBalance at 100 confirmations = %v, %v, want 5000, 0.", mature, immature)
+	}
+}
+
+func TestWatcherPrepareTxOutputs(t *testing.T) {
+	params := &chaincfg.TestNet3Params
+	const addr = "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"
+	a, err := btcutil.DecodeAddress(addr, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	tx := btcutil.NewTx(msgTx)
+
+	w := &Watcher{params: params}
+	if outputs := w.PrepareTxOutputs(tx); outputs[addr] != 1000 {
+		t.Errorf("PrepareTxOutputs = %v, want %s: 1000.", outputs, addr)
+	}
+	if refs := w.PrepareTxOutputRefs(tx); len(refs[addr]) != 1 {
+		t.Errorf("PrepareTxOutputRefs = %v, want one output to %s.", refs, addr)
+	}
+}
+
+func TestPrepareTxScriptOutputs(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nullData, err := txscript.NullDataScript([]byte("memo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare := []byte{txscript.OP_TRUE}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	msgTx.AddTxOut(wire.NewTxOut(2000, bare))
+	msgTx.AddTxOut(wire.NewTxOut(3000, bare))
+	msgTx.AddTxOut(wire.NewTxOut(0, nullData))
+	tx := btcutil.NewTx(msgTx)
+
+	outputs := PrepareTxScriptOutputs(tx, false)
+	if want := map[string]btcutil.Amount{"51": 5000}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("PrepareTxScriptOutputs = %v, want %v.", outputs, want)
+	}
+}
+
+func TestPrepareTxOpReturns(t *testing.T) {
+	memo, err := txscript.NullDataScript([]byte("invoice-42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoPushes := []byte{txscript.OP_RETURN, 0x01, 'a', 0x02, 'b', 'c'}
+	notData := []byte{txscript.OP_RETURN, txscript.OP_DUP}
+	malformed := []byte{txscript.OP_RETURN, 0x05, 'x'}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+	msgTx.AddTxOut(wire.NewTxOut(0, memo))
+	msgTx.AddTxOut(wire.NewTxOut(0, notData))
+	msgTx.AddTxOut(wire.NewTxOut(0, malformed))
+	msgTx.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	msgTx.AddTxOut(wire.NewTxOut(0, twoPushes))
+
+	got := PrepareTxOpReturns(btcutil.NewTx(msgTx))
+	want := [][]byte{[]byte("invoice-42"), []byte("a"), []byte("bc")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrepareTxOpReturns = %q, want %q.", got, want)
+	}
+}
+
+func TestPrepareTxOutputsDetailed(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nullData, err := txscript.NullDataScript([]byte("memo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(0, nullData))
+	msgTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	msgTx.AddTxOut(wire.NewTxOut(2000, []byte{txscript.OP_TRUE}))
+
+	got := PrepareTxOutputsDetailed(btcutil.NewTx(msgTx), &chaincfg.MainNetParams)
+	want := []OutputInfo{
+		{Index: 0, Class: txscript.NullDataTy},
+		{Index: 1, Address: addr, Class: txscript.ScriptHashTy, Amount: 1000},
+		{Index: 2, Class: txscript.NonStandardTy, Amount: 2000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrepareTxOutputsDetailed = %+v, want %+v.", got, want)
+	}
+}
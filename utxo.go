@@ -0,0 +1,57 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/neutrino"
+	"github.com/lightninglabs/neutrino/headerfs"
+)
+
+// getUtxo scans blocks from startHeight to the tip for the outpoint. It gives
+// up with ErrClosed when quit is closed.
+func getUtxo(cs *neutrino.ChainService, quit <-chan struct{}, op wire.OutPoint, pkScript []byte, startHeight int32) (*neutrino.SpendReport, error) {
+	select {
+	case <-quit:
+		return nil, ErrClosed
+	default:
+	}
+	if len(pkScript) == 0 {
+		return nil, fmt.Errorf("empty pkScript of %s", op)
+	}
+	hash, err := blockHashAt(cs, startHeight)
+	if err != nil {
+		return nil, err
+	}
+	report, err := cs.GetUtxo(
+		neutrino.WatchInputs(neutrino.InputWithScript{OutPoint: op, PkScript: pkScript}),
+		neutrino.StartBlock(&headerfs.BlockStamp{Hash: *hash, Height: startHeight}),
+		neutrino.QuitChan(quit),
+	)
+	if errors.Is(err, neutrino.ErrGetUtxoCancelled) || errors.Is(err, neutrino.ErrShuttingDown) {
+		select {
+		case <-quit:
+			return nil, ErrClosed
+		default:
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetUtxo(%s): %w", op, err)
+	}
+	return report, nil
+}
+
+// GetUtxo checks whether the outpoint with the pkScript is unspent at the tip.
+// startHeight must not exceed the height of the block which created the
+// output; the lower it is, the more filters are scanned. The report has Output
+// set if the output is unspent and SpendingTx set if it is spent.
+func (w *Watcher) GetUtxo(op wire.OutPoint, pkScript []byte, startHeight int32) (*neutrino.SpendReport, error) {
+	return getUtxo(w.chainService(), w.fullClose, op, pkScript, startHeight)
+}
+
+// GetUtxo checks whether the outpoint with the pkScript is unspent at the tip.
+// See Watcher.GetUtxo.
+func (w *FullWatcher) GetUtxo(op wire.OutPoint, pkScript []byte, startHeight int32) (*neutrino.SpendReport, error) {
+	return getUtxo(w.cs, w.fullClose, op, pkScript, startHeight)
+}
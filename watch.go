@@ -1,22 +1,28 @@
 package watch
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/gcs/builder"
 	"github.com/btcsuite/btcwallet/walletdb"
 	_ "github.com/btcsuite/btcwallet/walletdb/bdb"
 	"github.com/lightninglabs/neutrino"
 	"github.com/lightninglabs/neutrino/headerfs"
+	"github.com/lightninglabs/neutrino/pushtx"
 	"github.com/lightningnetwork/lnd/tor"
 )
 
@@ -38,12 +44,22 @@ var (
 		"testnet3-btcd.zaphq.io",
 		"testnet4-btcd.zaphq.io",
 	}
+
+	// TestNet4Peers is empty, as no public testnet4 peers serving
+	// cfilters are known, so neutrino finds them through DNS seeds.
+	TestNet4Peers []string
 )
 
 type Watcher struct {
+	// cs and db are replaced by restart under mu. Read them with
+	// chainService and database unless mu is held.
 	cs *neutrino.ChainService
 	db walletdb.DB
 
+	// ownsDB is false if db was passed to NewWithDB, so it is not closed
+	// or deleted by the watcher.
+	ownsDB bool
+
 	params *chaincfg.Params
 
 	rescan   *neutrino.Rescan
@@ -52,52 +68,292 @@ type Watcher struct {
 	// Arguments of New to start from scratch if it breaks.
 	peers    []string
 	torSocks string
-	testnet  bool
 	dir      string
+	opts     *options
+
+	blocks *blockCache
 
 	addresses []string
+	scripts   [][]byte
+	inputs    []neutrino.InputWithScript
 	fullClose chan struct{}
+	closeOnce sync.Once
 	mu        sync.Mutex
 	watching  bool
+
+	// closeDone is closed when the shutdown started by Close completes,
+	// with the result in closeErr.
+	closeDone chan struct{}
+	closeErr  error
+
+	// restartErr is set if the last restart failed or was disabled, so
+	// the watcher is not watching and won't recover.
+	restartErr error
+
+	// restarts counts soft and hard restarts.
+	restarts uint64
+
+	// scannedHeight is the height of the last block processed by rescan.
+	// It is accessed atomically.
+	scannedHeight int32
+	stalls        SyncStalls
+
+	// softRestarts counts soft restarts since the last processed block.
+	// It is accessed atomically.
+	softRestarts int32
+
+	spendWaits map[wire.OutPoint][]*spendWait
+
+	// backfillFrom is the lowest start height of cohorts added before
+	// StartWatching, if any.
+	backfillFrom *int32
+
+	// handlers are passed to StartWatching, to restart the rescan when
+	// items are removed or the service restarts. started is set by
+	// StartWatching.
+	handlers rpcclient.NotificationHandlers
+	started  bool
+
+	// restartMu serializes rebuilds, restarts and Close, which stop and
+	// start the rescan and the chain service. Handlers never take it, so
+	// unlike mu it may be held while the rescan is stopped.
+	restartMu sync.Mutex
+
+	// errs is returned by Errors. errMu guards closing it.
+	errs       chan error
+	errMu      sync.Mutex
+	errsClosed bool
+
+	// subscribers are channels returned by Subscribe.
+	subscribers []chan BlockEvent
+
+	// pause queues handler calls between Pause and Resume.
+	pause pauser
+
+	// derived maps addresses derived by AddExtendedKey to their position.
+	derived map[string]derivedAddress
+
+	// taproot has pkScripts of watched P2TR addresses, which neutrino can
+	// not watch as addresses, so they are matched like scripts.
+	taproot map[string][]byte
+
+	// gate holds connected blocks for handlers if WithMinConfirmations is
+	// set. It outlives rescans, so restarts rewind to the blocks it holds.
+	gate *confirmationGate
+}
+
+// errorsBuffer is the capacity of the Errors channel.
+const errorsBuffer = 16
+
+// RestartEvent is published on Errors when the watcher restarts.
+type RestartEvent struct {
+	Reason error
+
+	// Wipe is true if the database is deleted and the chain is synced
+	// from scratch.
+	Wipe bool
+}
+
+func (e *RestartEvent) Error() string {
+	if e.Wipe {
+		return fmt.Sprintf("restarting with a new database after: %v", e.Reason)
+	}
+	return fmt.Sprintf("restarting after: %v", e.Reason)
+}
+
+func (e *RestartEvent) Unwrap() error {
+	return e.Reason
+}
+
+var (
+	ErrClosed = errors.New("watcher is closed")
+
+	// ErrNotWatching is returned by CaughtUp before StartWatching and
+	// wrapped by errors of watchers which stopped watching and won't
+	// recover, see ErrRestartFailed and ErrNeedsRestart. Add* methods do
+	// not return it before StartWatching, because items added then are
+	// watched once it is called.
+	ErrNotWatching   = errors.New("not watching")
+	ErrRestartFailed = fmt.Errorf("restart failed, %w", ErrNotWatching)
+
+	// ErrNeedsRestart is returned when the watcher stopped because it
+	// would restart itself, but WithDisableAutoRestart is set.
+	ErrNeedsRestart = fmt.Errorf("watcher needs restart, %w", ErrNotWatching)
+
+	// ErrAlreadyWatching is returned by StartWatching if it was called
+	// before.
+	ErrAlreadyWatching = errors.New("StartWatching called several times")
+
+	// ErrInvalidAddress is wrapped by errors about addresses which can not
+	// be decoded or are for another network.
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrDBOpen and ErrChainServiceStart are wrapped by errors of
+	// constructors and restarts if the database can not be opened or
+	// neutrino can not be started.
+	ErrDBOpen            = errors.New("opening the database failed")
+	ErrChainServiceStart = errors.New("starting neutrino failed")
+
+	// ErrUnknownAddress is returned by AddressActivity for addresses which
+	// were never watched, or whose stats Compact removed.
+	ErrUnknownAddress = errors.New("address was never watched")
+
+	// ErrUnknownOutPoint is returned by WaitForSpend for outpoints which
+	// are not watched.
+	ErrUnknownOutPoint = errors.New("outpoint is not watched, see RegisterSpend and AddOutPoints")
+
+	// ErrTxNotFound is returned, or wrapped by AddOutPoints, for
+	// transactions which are not stored, e.g. because Compact pruned them.
+	ErrTxNotFound = errors.New("transaction not found among matched transactions")
+)
+
+// sentinelError matches sentinel with errors.Is and unwraps to err, so that
+// callers can match both, while fmt.Errorf wraps only one error.
+type sentinelError struct {
+	sentinel error
+	err      error
+}
+
+func (e *sentinelError) Error() string {
+	return fmt.Sprintf("%v: %v", e.sentinel, e.err)
+}
+
+func (e *sentinelError) Is(target error) bool {
+	return errors.Is(e.sentinel, target)
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.err
+}
+
+// StartFromTip passed to StartWatching as startBlock skips the historical
+// rescan: only blocks connected after the call are delivered, so funds
+// received before are not reported.
+const StartFromTip int32 = -1
+
+func New(peers []string, torSocks string, testnet bool, dir string, opts ...Option) (*Watcher, error) {
+	return NewForNetwork(peers, torSocks, boolNetwork(testnet), dir, opts...)
+}
+
+// NewForNetwork is New for any network. Pass DefaultPeers(network) as peers
+// to use the known peers of the network.
+func NewForNetwork(peers []string, torSocks string, network Network, dir string, opts ...Option) (*Watcher, error) {
+	params, err := network.Params()
+	if err != nil {
+		return nil, err
+	}
+	return NewWithParams(peers, torSocks, params, dir, opts...)
+}
+
+// NewWithParams is New for a chain with custom parameters, e.g. a modified
+// regtest. Params with a new bech32 prefix must be registered with
+// chaincfg.Register to decode addresses.
+func NewWithParams(peers []string, torSocks string, params *chaincfg.Params, dir string, opts ...Option) (*Watcher, error) {
+	return newWatcher(peers, torSocks, params, dir, nil, opts)
 }
 
-func New(peers []string, torSocks string, testnet bool, dir string) (*Watcher, error) {
+// NewWithDB is NewWithParams using db instead of wallet.db in dir. The caller
+// keeps ownership: Close does not close db and restarts do not delete it,
+// only neutrino's buckets in it. A db must not be shared by several watchers.
+func NewWithDB(peers []string, torSocks string, params *chaincfg.Params, dir string, db walletdb.DB, opts ...Option) (*Watcher, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+	return newWatcher(peers, torSocks, params, dir, db, opts)
+}
+
+// newWatcher creates a watcher using db or, if it is nil, its own database.
+func newWatcher(peers []string, torSocks string, params *chaincfg.Params, dir string, db walletdb.DB, opts []Option) (*Watcher, error) {
+	o := newOptions(opts)
 	watcher := &Watcher{
-		peers:    peers,
+		db:       db,
+		ownsDB:   db == nil,
+		params:   params,
+		peers:    append([]string(nil), peers...),
 		torSocks: torSocks,
-		testnet:  testnet,
 		dir:      dir,
+		opts:     o,
+
+		blocks: newBlockCache(o.txCacheSize),
 
 		fullClose: make(chan struct{}),
+		errs:      make(chan error, errorsBuffer),
+	}
+	if n := o.blockConfirmations(); n > 1 {
+		watcher.gate = &confirmationGate{
+			minConfirmations: n,
+			coinbaseMaturity: int32(params.CoinbaseMaturity),
+		}
 	}
 
 	if err := watcher.start(); err != nil {
 		return nil, err
 	}
+	if err := indexMatchedTxs(watcher.db); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("indexMatchedTxs: %w", err)
+	}
+	if o.retention > 0 && o.compactInterval > 0 {
+		go watcher.compactLoop()
+	}
+	if o.tipHint != nil {
+		go watchTipHint(o, watcher.fullClose, watcher.CurrentHeight)
+	}
+	if o.healthChecks() {
+		go watchHealth(o, watcher.fullClose, watcher.healthSample)
+	}
 
 	return watcher, nil
 }
 
-func makeService(peers []string, torSocks string, testnet bool, dir string) (cs *neutrino.ChainService, db walletdb.DB, params *chaincfg.Params, err error) {
-	dbFile := filepath.Join(dir, "wallet.db")
+// globalsMu guards neutrino's globals for user agent and outbound peers,
+// which NewChainService reads. It is held even if the options do not change
+// them, so a service created concurrently does not see another watcher's
+// values.
+var globalsMu sync.Mutex
 
-	if _, err0 := os.Stat(dbFile); os.IsNotExist(err0) {
-		db, err = walletdb.Create("bdb", dbFile, true)
-	} else {
-		db, err = walletdb.Open("bdb", dbFile, true)
+func newChainService(config neutrino.Config, o *options) (*neutrino.ChainService, error) {
+	globalsMu.Lock()
+	defer globalsMu.Unlock()
+	if o.userAgentName == "" && o.userAgentVersion == "" && o.maxPeers <= 0 {
+		return neutrino.NewChainService(config)
 	}
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("walletdb: %w", err)
+	defaultName, defaultVersion := neutrino.UserAgentName, neutrino.UserAgentVersion
+	defaultTarget := neutrino.TargetOutbound
+	defer func() {
+		neutrino.UserAgentName, neutrino.UserAgentVersion = defaultName, defaultVersion
+		neutrino.TargetOutbound = defaultTarget
+	}()
+	if o.userAgentName != "" {
+		neutrino.UserAgentName = o.userAgentName
+	}
+	if o.userAgentVersion != "" {
+		neutrino.UserAgentVersion = o.userAgentVersion
+	}
+	if o.maxPeers > 0 {
+		neutrino.TargetOutbound = o.maxPeers
 	}
+	return neutrino.NewChainService(config)
+}
 
-	dataDir := filepath.Join(dir, "data")
+// makeService starts neutrino in dir. It opens wallet.db in dir unless db is
+// not nil.
+func makeService(peers []string, torSocks string, params *chaincfg.Params, dir string, db walletdb.DB, o *options) (*neutrino.ChainService, walletdb.DB, error) {
+	dataDir := o.dataDir(dir)
 	if err := os.Mkdir(dataDir, 0700); err != nil && !os.IsExist(err) {
-		return nil, nil, nil, fmt.Errorf("Mkdir: %w", err)
+		return nil, nil, fmt.Errorf("Mkdir: %w", err)
+	}
+
+	if err := checkNetworkMarker(o.markerDir(dir), params); err != nil {
+		return nil, nil, err
 	}
 
-	params = &chaincfg.MainNetParams
-	if testnet {
-		params = &chaincfg.TestNet3Params
+	if db == nil {
+		var err error
+		db, err = openDB(o.dbFile(dir), o)
+		if err != nil {
+			return nil, nil, &sentinelError{ErrDBOpen, fmt.Errorf("walletdb: %w", err)}
+		}
 	}
 
 	config := neutrino.Config{
@@ -105,14 +361,14 @@ func makeService(peers []string, torSocks string, testnet bool, dir string) (cs
 		Database:     db,
 		ChainParams:  *params,
 		AddPeers:     peers,
-		ConnectPeers: peers,
+		ConnectPeers: connectPeers(peers, o),
+
+		BlockCacheSize:  o.blockCacheSize,
+		FilterCacheSize: o.filterCacheSize,
 	}
 
 	if torSocks != "" {
-		proxy := &tor.ProxyNet{
-			SOCKS:           torSocks,
-			StreamIsolation: true,
-		}
+		proxy := torNet(torSocks, o.tor)
 		config.Dialer = func(addr net.Addr) (net.Conn, error) {
 			return proxy.Dial(addr.Network(), addr.String())
 		}
@@ -121,194 +377,1617 @@ func makeService(peers []string, torSocks string, testnet bool, dir string) (cs
 		}
 	}
 
-	cs, err = neutrino.NewChainService(config)
+	cs, err := newChainService(config, o)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("neutrino.NewChainService: %w", err)
+		return nil, nil, &sentinelError{ErrChainServiceStart, fmt.Errorf("neutrino.NewChainService: %w", err)}
 	}
 	if err := cs.Start(); err != nil {
-		return nil, nil, nil, fmt.Errorf("cs.Start: %w", err)
+		return nil, nil, &sentinelError{ErrChainServiceStart, fmt.Errorf("cs.Start: %w", err)}
+	}
+
+	return cs, db, nil
+}
+
+// connectPeers returns the peers neutrino connects to exclusively. It is nil
+// with DNS seeds, so that neutrino discovers peers in addition to them.
+func connectPeers(peers []string, o *options) []string {
+	if o.dnsSeeds {
+		return nil
+	}
+	return peers
+}
+
+func openDB(dbFile string, o *options) (walletdb.DB, error) {
+	open := func() (walletdb.DB, error) {
+		if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+			return walletdb.Create("bdb", dbFile, o.noFreelistSync)
+		}
+		return walletdb.Open("bdb", dbFile, o.noFreelistSync)
+	}
+	if o.dbOpenTimeout == 0 {
+		return open()
 	}
 
-	return
+	// bdb waits for the file lock forever, so the timeout is implemented
+	// here. The database is closed if it opens after the timeout.
+	type result struct {
+		db  walletdb.DB
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		db, err := open()
+		results <- result{db, err}
+	}()
+	select {
+	case r := <-results:
+		return r.db, r.err
+	case <-time.After(o.dbOpenTimeout):
+		go func() {
+			if r := <-results; r.err == nil {
+				r.db.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timed out opening %s after %s", dbFile, o.dbOpenTimeout)
+	}
 }
 
 func (w *Watcher) start() error {
-	cs, db, params, err := makeService(w.peers, w.torSocks, w.testnet, w.dir)
+	var db walletdb.DB
+	if !w.ownsDB {
+		db = w.database()
+	}
+	cs, db, err := makeService(w.peers, w.torSocks, w.params, w.dir, db, w.opts)
 	if err != nil {
 		return err
 	}
 
+	w.mu.Lock()
 	w.cs = cs
 	w.db = db
-	w.params = params
+	w.mu.Unlock()
 
 	return nil
 }
 
+func (w *Watcher) isClosed() bool {
+	select {
+	case <-w.fullClose:
+		return true
+	default:
+		return false
+	}
+}
+
+// chainService returns the current service, which restart replaces.
+func (w *Watcher) chainService() *neutrino.ChainService {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cs
+}
+
+// database returns the current database, which restart replaces if the
+// watcher owns it.
+func (w *Watcher) database() walletdb.DB {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.db
+}
+
+// stopRescan stops the running rescan, if any, and waits for it to exit. It
+// must be called without holding w.mu, because the rescan goroutine may wait
+// for it in a handler.
+func (w *Watcher) stopRescan() {
+	w.mu.Lock()
+	quitChan, rescan := w.quitChan, w.rescan
+	w.quitChan, w.rescan = nil, nil
+	w.watching = false
+	w.mu.Unlock()
+
+	if quitChan != nil {
+		close(quitChan)
+		rescan.WaitForShutdown()
+	}
+}
+
+// Close stops the watcher. Later calls return the result of the first one.
 func (w *Watcher) Close() error {
-	close(w.fullClose)
-	return w.stop()
+	return w.CloseContext(context.Background())
 }
 
-func (w *Watcher) stop() error {
-	if w.quitChan != nil {
-		close(w.quitChan)
-		w.rescan.WaitForShutdown()
-		w.quitChan = nil
-		w.rescan = nil
+// CloseContext is Close returning ctx.Err() if ctx is done before the
+// shutdown completes, e.g. if neutrino is stuck. The shutdown continues in
+// the background then. Later calls wait for the same shutdown.
+func (w *Watcher) CloseContext(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		close(w.fullClose)
+		w.errMu.Lock()
+		if w.errs != nil {
+			close(w.errs)
+		}
+		w.errsClosed = true
+		w.errMu.Unlock()
+		w.closeDone = make(chan struct{})
+		go func() {
+			w.restartMu.Lock()
+			w.closeErr = w.stop()
+			w.restartMu.Unlock()
+			w.closeSubscribers()
+			close(w.closeDone)
+		}()
+	})
+	select {
+	case <-w.closeDone:
+		return w.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Errors returns a channel receiving rescan errors, RestartEvent and failed
+// restarts. Errors are dropped if the channel is full. It is closed by Close.
+func (w *Watcher) Errors() <-chan error {
+	return w.errs
+}
+
+// publishError sends err to Errors unless nobody reads it.
+func (w *Watcher) publishError(err error) {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	if w.errsClosed {
+		return
 	}
-	if err := w.cs.Stop(); err != nil {
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
+
+func (w *Watcher) stop() error {
+	w.stopRescan()
+	if err := w.chainService().Stop(); err != nil {
 		return err
 	}
-	if err := w.db.Close(); err != nil {
+	if !w.ownsDB {
+		return nil
+	}
+	if err := w.database().Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (w *Watcher) WaitForSync() error {
-	prev := int32(0)
-	for !w.cs.IsCurrent() {
-		time.Sleep(10 * time.Second)
+	return w.WaitForSyncContext(context.Background())
+}
 
-		header, err := w.cs.BestBlock()
+// WaitForSyncContext is WaitForSync which returns ctx.Err() when ctx is done.
+func (w *Watcher) WaitForSyncContext(ctx context.Context) error {
+	for {
+		err := w.waitForSync(ctx)
+		if !errors.Is(err, errSyncStalled) && !errors.Is(err, errChainReset) {
+			return err
+		}
+		// A chain reset needs new headers, which a soft restart would
+		// not fetch.
+		if err := w.restart(ctx, err, errors.Is(err, errChainReset)); err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	errSyncStalled = errors.New("sync stalled")
+	errChainReset  = errors.New("chain reset")
+)
+
+// waitForSync is WaitForSyncContext which returns errSyncStalled or
+// errChainReset instead of restarting, so restart can call it.
+func (w *Watcher) waitForSync(ctx context.Context) error {
+	if err := w.checkChainReset(); err != nil {
+		return err
+	}
+	cs := w.chainService()
+	start, err := cs.BestBlock()
+	if err != nil {
+		return err
+	}
+	prev := start.Height
+	prevFilter, err := w.FilterHeight()
+	if err != nil {
+		return err
+	}
+	stalledPolls := 0
+	for !cs.IsCurrent() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.fullClose:
+			return ErrClosed
+		case <-w.opts.clock.After(w.opts.pollInterval()):
+		}
+
+		header, err := cs.BestBlock()
+		if err != nil {
+			return err
+		}
+		filterHeight, err := w.FilterHeight()
 		if err != nil {
 			return err
 		}
-		log.Printf("%d %s", header.Height, header.Hash)
+		w.opts.logInfo("Syncing", "height", header.Height, "hash", header.Hash, "filter_height", filterHeight)
+		reportSyncProgress(cs, w.opts, header.Height)
+
+		// Neutrino downloads filter headers after block headers, so
+		// only one of them is expected to progress at a time.
+		stalls := SyncStalls{
+			BlockHeaders:  header.Height == prev,
+			FilterHeaders: filterHeight == prevFilter && filterHeight < header.Height,
+		}
+		w.mu.Lock()
+		w.stalls = stalls
+		w.mu.Unlock()
+
+		if !stalls.BlockHeaders || (!stalls.FilterHeaders && filterHeight != header.Height) {
+			stalledPolls = 0
+			// Restarts which led to progress are not counted
+			// towards a hard restart.
+			atomic.StoreInt32(&w.softRestarts, 0)
+		} else {
+			stalledPolls++
+		}
+		// The tip may be reached right after the check of the loop.
+		if stalledPolls >= w.opts.stallPolls && !cs.IsCurrent() {
+			w.opts.logWarn("No sync progress, restarting", "stalled_polls", stalledPolls, "block_headers_stalled", stalls.BlockHeaders, "filter_headers_stalled", stalls.FilterHeaders)
+			return errSyncStalled
+		}
+		prev, prevFilter = header.Height, filterHeight
 
-		if header.Height == prev {
-			log.Printf("No progress since last check. Restarting...")
-			w.restart(0, rpcclient.NotificationHandlers{})
+		if _, err := verifyCheckpoint(cs, w.opts); err != nil {
+			return err
 		}
-		prev = header.Height
+	}
+
+	w.mu.Lock()
+	w.stalls = SyncStalls{}
+	w.mu.Unlock()
+	return verifySyncedCheckpoint(cs, w.opts)
+}
+
+// checkChainReset returns errChainReset if stored headers of a test network
+// are not of the chain of params, which happens when the network is reset.
+func (w *Watcher) checkChainReset() error {
+	if w.params.Net == wire.MainNet {
+		return nil
+	}
+	cs := w.chainService()
+	header, err := cs.BestBlock()
+	if err != nil {
+		return err
+	}
+	height, reset, err := chainReset(w.params, header.Height, cs.GetBlockHash)
+	if err != nil {
+		return fmt.Errorf("chainReset: %w", err)
+	}
+	if reset {
+		w.opts.logWarn("Stored headers do not match the chain of the network, looks like a testnet reset, resyncing from scratch", "height", height, "tip", header.Height)
+		return errChainReset
 	}
 	return nil
 }
 
+// SyncStalls returns which parts of the chain sync made no progress during
+// the last check of WaitForSync.
+func (w *Watcher) SyncStalls() SyncStalls {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stalls
+}
+
 func (w *Watcher) CurrentHeight() (int32, error) {
-	header, err := w.cs.BestBlock()
+	header, err := w.chainService().BestBlock()
 	if err != nil {
 		return 0, err
 	}
 	return header.Height, nil
 }
 
-func (w *Watcher) StartWatching(startBlock int32, handlers rpcclient.NotificationHandlers) {
+// CurrentTip returns the hash and height of the best block header. The hash
+// changes on reorgs even if the height stays the same.
+func (w *Watcher) CurrentTip() (*chainhash.Hash, int32, error) {
+	header, err := w.chainService().BestBlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	return &header.Hash, header.Height, nil
+}
+
+// ChainService returns the underlying neutrino service for read-only queries
+// which the package does not wrap. Do not start, stop or reconfigure it. The
+// watcher replaces the service on restart, so do not keep the result.
+func (w *Watcher) ChainService() *neutrino.ChainService {
+	return w.chainService()
+}
+
+// IsSynced reports whether block headers are synced, without waiting like
+// WaitForSync.
+func (w *Watcher) IsSynced() bool {
+	return w.chainService().IsCurrent()
+}
+
+// SyncStatus returns the height of the best block header and whether block
+// headers are synced.
+func (w *Watcher) SyncStatus() (current int32, synced bool, err error) {
 	select {
 	case <-w.fullClose:
-		return
+		return 0, false, ErrClosed
 	default:
 	}
-
-	if w.rescan != nil {
-		panic("StartWatching called several times")
+	cs := w.chainService()
+	header, err := cs.BestBlock()
+	if err != nil {
+		return 0, false, err
 	}
+	return header.Height, cs.IsCurrent(), nil
+}
 
-	defer func() {
-		w.mu.Lock()
-		w.watching = true
-		w.mu.Unlock()
-	}()
+// DefaultConfirmations returns the number of confirmations considered final,
+// see WithDefaultConfirmations.
+func (w *Watcher) DefaultConfirmations() int32 {
+	return w.opts.defaultConfirmations
+}
 
-	w.mu.Lock()
-	addresses := w.addresses
-	w.mu.Unlock()
+// Params returns parameters of the network the watcher follows.
+func (w *Watcher) Params() *chaincfg.Params {
+	return w.params
+}
 
-	aaa, err := w.convertAddresses(addresses...)
-	if err != nil {
-		// Should had been detected in AddAddresses.
-		panic(err)
-	}
+// PrepareTxOutputs is like package-level PrepareTxOutputs for the network of
+// the watcher.
+func (w *Watcher) PrepareTxOutputs(tx *btcutil.Tx) map[string]btcutil.Amount {
+	return NewOutputParser(w.params).ParseOutputs(tx)
+}
 
-	quitChan := make(chan struct{})
-	w.quitChan = quitChan
-	startBlockStamp := &headerfs.BlockStamp{Height: startBlock}
-	w.rescan = neutrino.NewRescan(
-		&neutrino.RescanChainSource{ChainService: w.cs},
-		neutrino.QuitChan(quitChan),
-		neutrino.StartBlock(startBlockStamp),
-		neutrino.NotificationHandlers(handlers),
-		neutrino.WatchAddrs(aaa...),
-	)
-	errChan := w.rescan.Start()
-	go func() {
-		for err := range errChan {
-			log.Printf("Rescan error: %v.", err)
-			if strings.Contains(err.Error(), "unable to fetch cfilter") {
-				log.Println("It looks we have bug https://github.com/lightninglabs/neutrino/pull/194#issuecomment-575613975 here. Restarting neutrino.")
-				w.restart(startBlock, handlers)
-			}
-		}
-	}()
+// PrepareTxScriptOutputs is like package-level PrepareTxScriptOutputs for the
+// network of the watcher.
+func (w *Watcher) PrepareTxScriptOutputs(tx *btcutil.Tx) map[string]btcutil.Amount {
+	return NewOutputParser(w.params).ParseScriptOutputs(tx)
+}
+
+// PrepareTxOutputRefs is like package-level PrepareTxOutputRefs for the
+// network of the watcher.
+func (w *Watcher) PrepareTxOutputRefs(tx *btcutil.Tx) map[string][]OutputRef {
+	return NewOutputParser(w.params).ParseOutputRefs(tx)
 }
 
-func (w *Watcher) restart(startBlock int32, handlers rpcclient.NotificationHandlers) {
+// NetworkMarker returns the name of the network the directory was created for.
+func (w *Watcher) NetworkMarker() (string, error) {
+	return readNetworkMarker(w.opts.markerDir(w.dir))
+}
+
+// Stats returns a snapshot of the watcher state. It reads only the tip
+// headers, so it is cheap enough to poll every second.
+func (w *Watcher) Stats() WatcherStats {
 	w.mu.Lock()
-	w.watching = false
+	cs := w.cs
+	stats := WatcherStats{
+		ScannedHeight: atomic.LoadInt32(&w.scannedHeight),
+		Addresses:     len(w.addresses),
+		Watching:      w.watching && w.restartErr == nil,
+		Restarts:      w.restarts,
+		Downloads:     w.opts.downloads.stats(),
+	}
 	w.mu.Unlock()
 
-	if err := w.stop(); err != nil {
-		log.Printf("Failed to stop: %v. Giving up.", err)
-		return
-	}
-	dataDir := filepath.Join(w.dir, "data")
-	if err := os.RemoveAll(dataDir); err != nil {
-		log.Printf("Failed to remove dir %s: %v. Giving up.", dataDir, err)
-		return
+	if best, err := cs.BestBlock(); err == nil {
+		stats.Height = best.Height
 	}
-	dbFile := filepath.Join(w.dir, "wallet.db")
-	if err := os.Remove(dbFile); err != nil {
-		log.Printf("Failed to remove dbFile %s: %v. Giving up.", dbFile, err)
-		return
+	stats.Synced = cs.IsCurrent()
+	for _, sp := range cs.Peers() {
+		if sp.Connected() {
+			stats.Peers++
+		}
 	}
+	return stats
+}
 
-	if err := w.start(); err != nil {
-		log.Printf("Failed to start: %v. Giving up.", err)
-		return
-	}
-	if err := w.WaitForSync(); err != nil {
-		log.Printf("Failed to WaitForSync: %v. Giving up.", err)
-		return
+// CacheStats returns sizes of neutrino's block and filter caches, or
+// ErrClosed after Close.
+func (w *Watcher) CacheStats() (CacheStats, error) {
+	if w.isClosed() {
+		return CacheStats{}, ErrClosed
 	}
+	return cacheStats(w.chainService(), w.opts), nil
+}
+
+// DownloadStats returns the traffic of blocks and filters since the watcher
+// was created.
+func (w *Watcher) DownloadStats() DownloadStats {
+	return w.opts.downloads.stats()
+}
 
-	if handlers.OnFilteredBlockConnected != nil {
-		w.StartWatching(startBlock, handlers)
+// GetBlock returns the block with the hash, downloading it unless it was
+// fetched recently.
+func (w *Watcher) GetBlock(hash *chainhash.Hash) (*btcutil.Block, error) {
+	if w.isClosed() {
+		return nil, ErrClosed
 	}
+	return fetchBlock(context.Background(), w.chainService(), w.blocks, w.opts, w.fullClose, hash)
 }
 
-func (w *Watcher) AddAddresses(addrs ...string) error {
+// GetBlockDeposits returns payments to addrs in the block at the height.
+// The block is downloaded only if its filter matches addrs.
+func (w *Watcher) GetBlockDeposits(height int32, addrs []string) ([]DepositRecord, error) {
 	aaa, err := w.convertAddresses(addrs...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	w.addresses = append(w.addresses, addrs...)
-	if !w.watching {
-		// We can not add addressed before StartWatching or during restarting.
+	scripts, err := addressScripts(aaa)
+	if err != nil {
+		return nil, err
+	}
+	for _, script := range taprootScripts(addrs, w.params) {
+		scripts = append(scripts, script)
+	}
+	if len(scripts) == 0 {
+		return nil, nil
+	}
+	tipHeight, err := w.CurrentHeight()
+	if err != nil {
+		return nil, err
+	}
+	cs := w.chainService()
+	blockHash, err := cs.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, fmt.Errorf("GetBlockHash(%d) failed: %w", height, err)
+	}
+
+	filter, err := fetchFilter(context.Background(), cs, w.opts, w.fullClose, blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("for height %d GetCFilter failed: %w", height, err)
+	}
+	matched, err := filter.MatchAny(builder.DeriveKey(blockHash), scripts)
+	if err != nil {
+		return nil, fmt.Errorf("for height %d filter.MatchAny failed: %w", height, err)
+	}
+	if !matched {
+		return nil, nil
+	}
+
+	block, err := w.GetBlock(blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("for height %d GetBlock failed: %w", height, err)
+	}
+	return blockDeposits(w.params, block, height, tipHeight, addrs), nil
+}
+
+// SendRawTransaction broadcasts the transaction to connected peers. Neutrino
+// rebroadcasts it on each new block until it is mined.
+func (w *Watcher) SendRawTransaction(tx *wire.MsgTx) (*chainhash.Hash, error) {
+	select {
+	case <-w.fullClose:
+		return nil, ErrClosed
+	default:
+	}
+	if err := w.chainService().SendTransaction(tx); err != nil {
+		if errors.Is(err, pushtx.ErrBroadcasterStopped) {
+			return nil, fmt.Errorf("SendTransaction: chain service is stopped, the watcher is closed or restarting: %w", err)
+		}
+		return nil, fmt.Errorf("SendTransaction: %w", err)
+	}
+	hash := tx.TxHash()
+	return &hash, nil
+}
+
+// FilterHeight returns the height of the tip of the filter header chain,
+// which neutrino downloads separately from block headers.
+func (w *Watcher) FilterHeight() (int32, error) {
+	_, height, err := w.chainService().RegFilterHeaders.ChainTip()
+	if err != nil {
+		return 0, err
+	}
+	return int32(height), nil
+}
+
+// WaitForFilters waits until the filter header chain reaches the height,
+// checking it every WithSyncPollInterval. Rescanning blocks without filter
+// headers fails to fetch cfilters.
+func (w *Watcher) WaitForFilters(ctx context.Context, height int32) error {
+	for {
+		filterHeight, err := w.FilterHeight()
+		if err != nil {
+			return err
+		}
+		if filterHeight >= height {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.fullClose:
+			return ErrClosed
+		case <-w.opts.clock.After(w.opts.pollInterval()):
+		}
+	}
+}
+
+func (w *Watcher) StartWatching(startBlock int32, handlers rpcclient.NotificationHandlers) error {
+	// The lock is held until watching is set, so items added concurrently
+	// are either in the lists below or registered with rescan.Update.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.fullClose:
+		return ErrClosed
+	default:
+	}
+
+	if w.rescan != nil {
+		return ErrAlreadyWatching
+	}
+
+	addresses := w.addresses
+	scripts := w.rawScripts()
+	inputs := w.inputs
+
+	aaa, err := w.convertAddresses(addresses...)
+	if err != nil {
+		return err
+	}
+
+	if startBlock == StartFromTip {
+		// CurrentHeight would take w.mu again.
+		header, err := w.cs.BestBlock()
+		if err != nil {
+			return err
+		}
+		startBlock = header.Height
+	}
+	if w.backfillFrom != nil {
+		if *w.backfillFrom < startBlock {
+			startBlock = *w.backfillFrom
+		}
+		w.backfillFrom = nil
+	}
+	if w.gate != nil {
+		// Blocks held by the gate of a previous rescan were not
+		// delivered to handlers.
+		startBlock = w.gate.rewind(startBlock)
+	}
+
+	// Rescan delivers blocks after startBlock.
+	atomic.StoreInt32(&w.scannedHeight, startBlock)
+	w.handlers = handlers
+	w.started = true
+
+	quitChan := make(chan struct{})
+
+	// Restarts pass handlers to StartWatching again, so only the rescan
+	// gets the wrapped ones.
+	ntfn := w.rescanHandlers(handlers, quitChan)
+
+	w.quitChan = quitChan
+	startBlockStamp := &headerfs.BlockStamp{Height: startBlock}
+	w.rescan = neutrino.NewRescan(
+		newRescanSource(w.cs, w.blocks, w.opts, quitChan),
+		neutrino.QuitChan(quitChan),
+		neutrino.StartBlock(startBlockStamp),
+		neutrino.NotificationHandlers(ntfn),
+		neutrino.WatchAddrs(aaa...),
+		neutrino.WatchInputs(scriptInputs(scripts)...),
+		neutrino.WatchInputs(inputs...),
+	)
+	errChan := w.rescan.Start()
+	go func() {
+		// The rescan sends one error when it exits, ErrRescanExit if it
+		// was stopped.
+		var err error
+		select {
+		case err = <-errChan:
+		case <-quitChan:
+			return
+		}
+		if err == nil || errors.Is(err, neutrino.ErrRescanExit) {
+			return
+		}
+		w.opts.logError("Rescan error", "err", err)
+		w.publishError(err)
+		if strings.Contains(err.Error(), "unable to fetch cfilter") {
+			w.opts.logWarn("Hit the neutrino cfilter bug, restarting", "bug", "https://github.com/lightninglabs/neutrino/pull/194#issuecomment-575613975", "err", err)
+			w.restart(context.Background(), err, false)
+		}
+	}()
+
+	w.watching = true
+	return nil
+}
+
+// rescanHandlers wraps handlers with the processing of blocks by the watcher.
+// A connected block is processed until it is stored and delivered, retrying
+// failed writes, and the scanned height moves past it only then, so a rescan
+// resumed from the scanned height delivers it again if quit is closed before.
+func (w *Watcher) rescanHandlers(handlers rpcclient.NotificationHandlers, quit <-chan struct{}) rpcclient.NotificationHandlers {
+	paused := w.pause.wrap(handlers)
+	ntfn := paused
+	ntfn.OnFilteredBlockConnected = func(height int32, header *wire.BlockHeader, relevantTxs []*btcutil.Tx) {
+		atomic.StoreInt32(&w.softRestarts, 0)
+
+		// Headers may reach the checkpoint only after WaitForSync.
+		blockHash := header.BlockHash()
+		if err := checkBlock(w.opts, height, &blockHash); err != nil {
+			w.stopWith(err)
+			// stopRescan waits for this handler, which waits for
+			// quit, so later blocks of the chain are not delivered.
+			go w.stopRescan()
+			<-quit
+			return
+		}
+
+		var matched []*btcutil.Tx
+		if !w.retryUntilQuit(quit, "addScriptMatches", height, func() error {
+			var err error
+			matched, err = w.addScriptMatches(header, relevantTxs)
+			return err
+		}) {
+			return
+		}
+		relevantTxs = matched
+		if len(relevantTxs) != 0 {
+			if !w.retryUntilQuit(quit, "storeMatchedTxs", height, func() error {
+				return storeMatchedTxs(w.database(), height, header, relevantTxs)
+			}) {
+				return
+			}
+			received, spent := w.txAddresses(relevantTxs)
+			if !w.retryUntilQuit(quit, "recordActivity", height, func() error {
+				return recordActivity(w.database(), height, received, spent)
+			}) {
+				return
+			}
+			if addrs := w.extendDerived(received); len(addrs) != 0 {
+				// rescan.Update blocks if called from the rescan
+				// goroutine, so the rescan goes on meanwhile and
+				// is rewound to scan this block again for them.
+				go func() {
+					if err := w.addAddresses(addrs, neutrino.Rewind(uint32(height-1)), neutrino.DisableDisconnectedNtfns(true)); err != nil {
+						w.opts.logError("Adding derived addresses failed", "height", height, "err", err)
+					}
+				}()
+			}
+		}
+		w.deliverMatchedBlock(height, header, relevantTxs)
+		w.processSpendWaits(height, relevantTxs)
+		var confirmed []TxConfirmation
+		if !w.retryUntilQuit(quit, "processConfirmations", height, func() error {
+			var err error
+			confirmed, err = processConfirmations(w.database(), height, relevantTxs, int32(w.params.CoinbaseMaturity))
+			return err
+		}) {
+			return
+		}
+		if w.opts.onConfirmed != nil {
+			for _, c := range confirmed {
+				w.opts.onConfirmed(c)
+			}
+		}
+		if paused.OnFilteredBlockConnected != nil {
+			if w.gate == nil {
+				paused.OnFilteredBlockConnected(height, header, relevantTxs)
+			} else {
+				for _, b := range w.gate.connect(height, header, relevantTxs) {
+					paused.OnFilteredBlockConnected(b.height, b.header, b.txs)
+				}
+			}
+		}
+		w.publishBlock(BlockEvent{Height: height, Header: header, Txs: relevantTxs}, quit)
+		if len(relevantTxs) != 0 && (w.opts.eventHandler != nil || len(w.opts.sinks) != 0) {
+			watched, err := w.watchedScripts()
+			if err != nil {
+				w.opts.logError("watchedScripts failed", "height", height, "err", err)
+				watched = map[string]bool{}
+			}
+			if !deliverEvents(w.opts, quit, w.params, watched, w.watchedOutPoints(), height, &blockHash, relevantTxs, blockPrevOut(relevantTxs, w.blocks)) {
+				return
+			}
+		}
+		if w.opts.onBlockProcessed != nil {
+			w.opts.onBlockProcessed(height, blockHash)
+		}
+		atomic.StoreInt32(&w.scannedHeight, height)
+	}
+	ntfn.OnFilteredBlockDisconnected = func(height int32, header *wire.BlockHeader) {
+		atomic.StoreInt32(&w.scannedHeight, height-1)
+
+		if err := deleteMatchedTxs(w.database(), height); err != nil {
+			w.opts.logError("deleteMatchedTxs failed", "height", height, "err", err)
+		}
+		w.unmineSpendWaits(height)
+		// Handlers did not see blocks held by the gate.
+		held := w.gate != nil && w.gate.disconnect(height)
+		if err := unmineConfirmations(w.database(), height); err != nil {
+			w.opts.logError("unmineConfirmations failed", "height", height, "err", err)
+		}
+		if paused.OnFilteredBlockDisconnected != nil && !held {
+			paused.OnFilteredBlockDisconnected(height, header)
+		}
+		w.publishBlock(BlockEvent{Height: height, Header: header, Disconnected: true}, quit)
+	}
+	return ntfn
+}
+
+// retryUntilQuit calls f until it succeeds, backing off between attempts. It
+// returns false if quit is closed first.
+func (w *Watcher) retryUntilQuit(quit <-chan struct{}, what string, height int32, f func() error) bool {
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil {
+			return true
+		}
+		delay := w.opts.retryDelay(attempt)
+		w.opts.logError(what+" failed, retrying", "height", height, "err", err, "delay", delay)
+		select {
+		case <-quit:
+			return false
+		case <-w.opts.clock.After(delay):
+		}
+	}
+}
+
+// StartWatchingAndWait calls StartWatching and waits until the rescan catches
+// up with the tip of the chain, checking it every WithSyncPollInterval.
+func (w *Watcher) StartWatchingAndWait(ctx context.Context, startBlock int32, handlers rpcclient.NotificationHandlers) error {
+	if err := w.StartWatching(startBlock, handlers); err != nil {
+		return err
+	}
+
+	for {
+		caughtUp, err := w.CaughtUp()
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.fullClose:
+			return ErrClosed
+		case <-w.opts.clock.After(w.opts.pollInterval()):
+		}
+	}
+}
+
+// CaughtUp returns true if the chain is synced and the rescan started by
+// StartWatching has processed all blocks up to the tip. It returns
+// ErrNotWatching before StartWatching and the error of the last restart if
+// the watcher stopped watching.
+func (w *Watcher) CaughtUp() (bool, error) {
+	w.mu.Lock()
+	started, watching, restartErr := w.started, w.watching, w.restartErr
+	w.mu.Unlock()
+	if !started {
+		return false, ErrNotWatching
+	}
+	if restartErr != nil {
+		return false, restartErr
+	}
+	scannedHeight := atomic.LoadInt32(&w.scannedHeight)
+	if !watching || !w.chainService().IsCurrent() {
+		return false, nil
+	}
+	height, err := w.CurrentHeight()
+	if err != nil {
+		return false, err
+	}
+	return scannedHeight >= height, nil
+}
+
+// restart recreates the chain service because of reason and resumes watching
+// from the scanned height if StartWatching was called. It returns the error
+// which Add* methods return from now on, if the watcher could not restart.
+// If ctx is done, it returns ctx.Err() after the service is restarted or
+// before the next attempt. If wipe is set, the database is wiped without
+// trying soft restarts first.
+func (w *Watcher) restart(ctx context.Context, reason error, wipe bool) error {
+	w.restartMu.Lock()
+	defer w.restartMu.Unlock()
+	select {
+	case <-w.fullClose:
+		return ErrClosed
+	default:
+	}
+
+	w.mu.Lock()
+	w.watching = false
+	w.restartErr = nil
+	w.mu.Unlock()
+
+	if w.opts.disableAutoRestart {
+		w.opts.logError("Auto restart is disabled, stopping", "reason", reason)
+		w.stopRescan()
+		return w.stopWith(&sentinelError{ErrNeedsRestart, reason})
+	}
+
+	for !wipe && atomic.LoadInt32(&w.softRestarts) < int32(w.opts.hardRestartAfter) {
+		if err := w.waitBeforeRestart(ctx); err != nil {
+			return err
+		}
+		n := atomic.AddInt32(&w.softRestarts, 1)
+		w.opts.logWarn("Soft restart keeping the database", "attempt", n, "max_attempts", w.opts.hardRestartAfter, "reason", reason)
+		w.publishError(&RestartEvent{Reason: reason})
+		w.countRestart()
+		err := w.softRestart(ctx)
+		if err == nil || ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return err
+		}
+		if errors.Is(err, ErrCheckpointMismatch) {
+			// Wiping would sync the same chain again.
+			w.opts.logError("Restart failed, giving up", "err", err)
+			return w.stopWith(&sentinelError{ErrRestartFailed, err})
+		}
+		w.opts.logWarn("Soft restart failed", "err", err)
+		reason = err
+	}
+
+	if err := w.waitBeforeRestart(ctx); err != nil {
+		return err
+	}
+	w.opts.logWarn("Hard restart wiping the database", "reason", reason)
+	w.publishError(&RestartEvent{Reason: reason, Wipe: true})
+	w.countRestart()
+	atomic.StoreInt32(&w.softRestarts, 0)
+	if err := w.reset(ctx); err != nil {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return err
+		}
+		w.opts.logError("Restart failed, giving up", "err", err)
+		return w.stopWith(&sentinelError{ErrRestartFailed, err})
+	}
+	return nil
+}
+
+// stopWith makes Add* methods and CaughtUp return err from now on and
+// publishes it. It returns err.
+func (w *Watcher) stopWith(err error) error {
+	w.mu.Lock()
+	w.restartErr = err
+	w.mu.Unlock()
+	w.publishError(err)
+	return err
+}
+
+// reset recreates the chain service from scratch and resumes watching.
+func (w *Watcher) reset(ctx context.Context) error {
+	if !w.ownsDB {
+		return w.resetNeutrino(ctx)
+	}
+	snapshot, err := snapshotBuckets(w.database())
+	if err != nil {
+		return fmt.Errorf("failed to snapshot buckets: %w", err)
+	}
+	if err := w.stop(); err != nil {
+		return fmt.Errorf("failed to stop: %w", err)
+	}
+	dataDir := w.opts.dataDir(w.dir)
+	if err := os.RemoveAll(dataDir); err != nil {
+		return fmt.Errorf("failed to remove dir %s: %w", dataDir, err)
+	}
+	dbFile := w.opts.dbFile(w.dir)
+	if err := os.Remove(dbFile); err != nil {
+		return fmt.Errorf("failed to remove dbFile %s: %w", dbFile, err)
+	}
+
+	if err := w.start(); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	if err := restoreBuckets(w.database(), snapshot); err != nil {
+		return fmt.Errorf("failed to restore buckets: %w", err)
+	}
+	return w.resume(ctx)
+}
+
+// countRestart counts a soft or hard restart for Stats.
+func (w *Watcher) countRestart() {
+	w.mu.Lock()
+	w.restarts++
+	w.mu.Unlock()
+}
+
+// waitBeforeRestart backs off if the watcher restarted without processing a
+// block since. It returns ErrClosed if the watcher was closed meanwhile and
+// ctx.Err() if ctx is done.
+func (w *Watcher) waitBeforeRestart(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.fullClose:
+		return ErrClosed
+	default:
+	}
+	attempt := int(atomic.LoadInt32(&w.softRestarts))
+	if attempt == 0 {
+		return nil
+	}
+	delay := w.opts.retryDelay(attempt - 1)
+	w.opts.logWarn("Restarted without progress, waiting", "attempt", attempt, "delay", delay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.fullClose:
+		return ErrClosed
+	case <-w.opts.clock.After(delay):
+		return nil
+	}
+}
+
+// resetNeutrino is reset for a database owned by the caller: it deletes only
+// neutrino's buckets and files.
+func (w *Watcher) resetNeutrino(ctx context.Context) error {
+	if err := w.stop(); err != nil {
+		return fmt.Errorf("failed to stop: %w", err)
+	}
+	dataDir := w.opts.dataDir(w.dir)
+	if err := os.RemoveAll(dataDir); err != nil {
+		return fmt.Errorf("failed to remove dir %s: %w", dataDir, err)
+	}
+	if err := deleteNeutrinoBuckets(w.database()); err != nil {
+		return fmt.Errorf("failed to delete neutrino buckets: %w", err)
+	}
+	if err := w.start(); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	return w.resume(ctx)
+}
+
+// softRestart restarts the chain service keeping headers and filters on disk.
+func (w *Watcher) softRestart(ctx context.Context) error {
+	if err := w.stop(); err != nil {
+		return fmt.Errorf("failed to stop: %w", err)
+	}
+	if err := w.start(); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	return w.resume(ctx)
+}
+
+// resume waits for the restarted service to sync and restarts watching from
+// the scanned height if StartWatching was called. If ctx is done, it restarts
+// watching without waiting and returns ctx.Err().
+func (w *Watcher) resume(ctx context.Context) error {
+	syncErr := w.waitForSync(ctx)
+	if syncErr != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to WaitForSync: %w", syncErr)
+	}
+
+	w.mu.Lock()
+	started, handlers := w.started, w.handlers
+	w.mu.Unlock()
+	if started {
+		if err := w.StartWatching(atomic.LoadInt32(&w.scannedHeight), handlers); err != nil {
+			return fmt.Errorf("failed to StartWatching: %w", err)
+		}
+	}
+	return syncErr
+}
+
+// AddAddresses starts watching addrs. All addresses of a call are added to
+// the running rescan with one update, so large sets should be added in one
+// call rather than one by one. Before StartWatching addresses are only
+// recorded and the rescan starts with all of them.
+func (w *Watcher) AddAddresses(addrs ...string) error {
+	return w.addAddresses(addrs)
+}
+
+// addAddresses is AddAddresses passing options to the rescan update.
+func (w *Watcher) addAddresses(addrs []string, options ...neutrino.UpdateOption) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	aaa, err := w.convertAddresses(addrs...)
+	if err != nil {
+		return err
+	}
+
+	taproot := w.watchTaproot(addrs)
+
+	w.mu.Lock()
+	w.addresses = append(w.addresses, addrs...)
+	w.mu.Unlock()
+
+	if err := registerAddresses(w.database(), atomic.LoadInt32(&w.scannedHeight), addrs); err != nil {
+		return fmt.Errorf("registerAddresses: %w", err)
+	}
+
+	options = append(options, neutrino.AddAddrs(aaa...), neutrino.AddInputs(scriptInputs(taproot)...))
+	return w.updateRescan(options...)
+}
+
+// WatchConfirmations calls the handler set by WithOnConfirmed once the tx has
+// target confirmations, or DefaultConfirmations if target is 0. The tx must
+// pay to a watched address or script or spend an output registered with
+// RegisterSpend. It may have been pruned by Compact already, the height it
+// was mined at is kept. The target of a coinbase tx is raised to
+// params.CoinbaseMaturity, unless Compact pruned it. Pending watches are
+// stored in the database and survive restarts of the process.
+func (w *Watcher) WatchConfirmations(txid chainhash.Hash, target int32) error {
+	minedHeight, _, err := matchedTxHeight(w.database(), &txid)
+	if err != nil {
+		return fmt.Errorf("matchedTxHeight: %w", err)
+	}
+	target = w.opts.confirmations(target)
+	if maturity := int32(w.params.CoinbaseMaturity); minedHeight != 0 && target < maturity {
+		_, msgTx, found, err := findMatchedTxByID(w.database(), &txid)
+		if err != nil {
+			return fmt.Errorf("findMatchedTxByID: %w", err)
+		}
+		if found && blockchain.IsCoinBaseTx(msgTx) {
+			target = maturity
+		}
+	}
+	if err := putConfirmationWatch(w.database(), &txid, target, minedHeight); err != nil {
+		return fmt.Errorf("putConfirmationWatch: %w", err)
+	}
+	return nil
+}
+
+// GetTransaction returns a stored transaction matching watched items and the
+// height of its block. Transactions pruned by Compact are not found.
+func (w *Watcher) GetTransaction(hash *chainhash.Hash) (*btcutil.Tx, int32, error) {
+	height, msgTx, found, err := findMatchedTxByID(w.database(), hash)
+	if err != nil {
+		return nil, 0, fmt.Errorf("findMatchedTxByID: %w", err)
+	}
+	if !found {
+		return nil, 0, ErrTxNotFound
+	}
+	return btcutil.NewTx(msgTx), height, nil
+}
+
+// ListAddresses returns a copy of the watched addresses, including those added
+// before StartWatching.
+func (w *Watcher) ListAddresses() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.addresses...)
+}
+
+// SetAddresses makes addrs the whole set of watched addresses, adding new
+// ones and removing the rest, e.g. to load an address book at startup. The
+// set is applied at once, so concurrent calls do not interleave. New
+// addresses are added to the running rescan with one update. If any is
+// removed while watching, the rescan is restarted from the scanned height
+// instead, because neutrino can not stop watching an address.
+func (w *Watcher) SetAddresses(addrs ...string) error {
+	if _, err := w.convertAddresses(addrs...); err != nil {
+		return err
+	}
+
+	want := make(map[string]bool, len(addrs))
+	var set []string
+	for _, addr := range addrs {
+		if !want[addr] {
+			want[addr] = true
+			set = append(set, addr)
+		}
+	}
+
+	w.mu.Lock()
+	current := make(map[string]bool, len(w.addresses))
+	removed := false
+	for _, addr := range w.addresses {
+		current[addr] = true
+		if !want[addr] {
+			removed = true
+		}
+	}
+	var added []string
+	for _, addr := range set {
+		if !current[addr] {
+			added = append(added, addr)
+		}
+	}
+	if removed {
+		w.addresses = set
+		for addr := range w.taproot {
+			if !want[addr] {
+				delete(w.taproot, addr)
+			}
+		}
+	} else {
+		w.addresses = append(w.addresses, added...)
+	}
+	taproot := taprootScripts(added, w.params)
+	if len(taproot) != 0 && w.taproot == nil {
+		w.taproot = make(map[string][]byte, len(taproot))
+	}
+	scripts := make([][]byte, 0, len(taproot))
+	for addr, script := range taproot {
+		w.taproot[addr] = script
+		scripts = append(scripts, script)
+	}
+	w.mu.Unlock()
+
+	if len(added) != 0 {
+		if err := registerAddresses(w.database(), atomic.LoadInt32(&w.scannedHeight), added); err != nil {
+			return fmt.Errorf("registerAddresses: %w", err)
+		}
+	}
+	if removed {
+		return w.rebuildRescan()
+	}
+	if len(added) == 0 {
 		return nil
 	}
-	if err := w.rescan.Update(neutrino.AddAddrs(aaa...)); err != nil {
+	aaa, err := w.convertAddresses(added...)
+	if err != nil {
+		return err
+	}
+	return w.updateRescan(neutrino.AddAddrs(aaa...), neutrino.AddInputs(scriptInputs(scripts)...))
+}
+
+// RemoveAddresses stops watching addrs. Addresses which are not watched are
+// ignored. If any is removed while watching, the rescan is restarted from the
+// scanned height, because neutrino can not stop watching an address.
+func (w *Watcher) RemoveAddresses(addrs ...string) error {
+	remove := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		remove[addr] = true
+	}
+
+	w.mu.Lock()
+	kept := make([]string, 0, len(w.addresses))
+	for _, addr := range w.addresses {
+		if !remove[addr] {
+			kept = append(kept, addr)
+		}
+	}
+	for addr := range remove {
+		delete(w.taproot, addr)
+	}
+	removed := len(kept) != len(w.addresses)
+	w.addresses = kept
+	w.mu.Unlock()
+
+	if !removed {
+		return nil
+	}
+	return w.rebuildRescan()
+}
+
+// rebuildRescan restarts a running rescan from the scanned height with the
+// current lists of items.
+func (w *Watcher) rebuildRescan() error {
+	w.restartMu.Lock()
+	defer w.restartMu.Unlock()
+
+	w.mu.Lock()
+	watching, restartErr, handlers := w.watching, w.restartErr, w.handlers
+	w.watching = false
+	w.mu.Unlock()
+
+	if restartErr != nil {
+		return restartErr
+	}
+	if !watching {
+		// StartWatching will use the lists.
+		return nil
+	}
+	// Must not hold w.mu, the rescan goroutine may wait for it.
+	w.stopRescan()
+	return w.StartWatching(atomic.LoadInt32(&w.scannedHeight), handlers)
+}
+
+// AddressActivity returns when the address was registered and used. The stats
+// survive restarts, and Compact removes them once the address is removed and
+// not seen within the retention window.
+func (w *Watcher) AddressActivity(addr string) (AddressStats, error) {
+	stats, found, err := loadAddressStats(w.database(), addr)
+	if err != nil {
+		return AddressStats{}, fmt.Errorf("loadAddressStats: %w", err)
+	}
+	if !found {
+		return AddressStats{}, ErrUnknownAddress
+	}
+	return stats, nil
+}
+
+// txAddresses returns addresses paid by txs and addresses of outputs
+// registered with RegisterSpend which txs spend.
+func (w *Watcher) txAddresses(txs []*btcutil.Tx) (received, spent []string) {
+	w.mu.Lock()
+	inputs := make(map[wire.OutPoint][]byte, len(w.inputs))
+	for _, input := range w.inputs {
+		inputs[input.OutPoint] = input.PkScript
+	}
+	w.mu.Unlock()
+
+	for _, tx := range txs {
+		for _, payment := range txPayments(tx, w.params, nil) {
+			if payment.Address != "" {
+				received = append(received, payment.Address)
+			}
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			pkScript, has := inputs[txIn.PreviousOutPoint]
+			if !has {
+				continue
+			}
+			if a := paymentAddress(pkScript, w.params); a != nil {
+				spent = append(spent, a.EncodeAddress())
+			}
+		}
+	}
+	return received, spent
+}
+
+// updateRescan registers items, which the caller has already added to the
+// lists, with the running rescan. It must be called without holding w.mu,
+// because the rescan goroutine may wait for it in a handler.
+func (w *Watcher) updateRescan(options ...neutrino.UpdateOption) error {
+	w.mu.Lock()
+	rescan, watching, restartErr := w.rescan, w.watching, w.restartErr
+	w.mu.Unlock()
+
+	select {
+	case <-w.fullClose:
+		return ErrClosed
+	default:
+	}
+	if restartErr != nil {
+		return restartErr
+	}
+	if !watching || rescan == nil {
+		// We can not add items before StartWatching or during
+		// restarting. StartWatching registers them from the lists.
+		return nil
+	}
+	if err := rescan.Update(options...); err != nil {
+		if errors.Is(err, neutrino.ErrRescanExit) {
+			// Restarting, see above.
+			return nil
+		}
 		return fmt.Errorf("rescan.Update: %w", err)
 	}
 	return nil
 }
 
+// Compact removes matched transactions older than the retention window set
+// by WithRetention, and activity stats of addresses which are not watched
+// anymore and were not seen within the window. The txid index of matched
+// transactions keeps an entry per pruned transaction for WatchConfirmations,
+// and pending confirmation watches are kept until they fire. Freed database
+// pages are reused by later writes, so the file stops growing instead of
+// shrinking.
+func (w *Watcher) Compact() error {
+	if w.opts.retention <= 0 {
+		return nil
+	}
+	tipHeight, err := w.CurrentHeight()
+	if err != nil {
+		return err
+	}
+	below := tipHeight - w.opts.retention + 1
+	n, err := pruneMatchedTxs(w.database(), below)
+	if err != nil {
+		return fmt.Errorf("pruneMatchedTxs: %w", err)
+	}
+	if n != 0 {
+		w.opts.logInfo("Compact removed matched transactions", "count", n)
+	}
+
+	watched := make(map[string]bool)
+	for _, addr := range w.ListAddresses() {
+		watched[addr] = true
+	}
+	n, err = pruneAddressStats(w.database(), below, watched)
+	if err != nil {
+		return fmt.Errorf("pruneAddressStats: %w", err)
+	}
+	if n != 0 {
+		w.opts.logInfo("Compact removed stats of unwatched addresses", "count", n)
+	}
+	return nil
+}
+
+func (w *Watcher) compactLoop() {
+	ticker := time.NewTicker(w.opts.compactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.fullClose:
+			return
+		case <-ticker.C:
+		}
+		if err := w.Compact(); err != nil {
+			w.opts.logError("Compact failed", "err", err)
+		}
+	}
+}
+
+// Replay delivers relevant transactions stored by the watcher at heights
+// starting from since to handlers, in order of height, without rescanning the
+// chain. It may be called while watching.
+func (w *Watcher) Replay(handlers rpcclient.NotificationHandlers, since int32) error {
+	next := since
+	for {
+		height, header, txs, found, err := loadMatchedTxs(w.database(), next)
+		if err != nil {
+			return fmt.Errorf("loadMatchedTxs: %w", err)
+		}
+		if !found {
+			return nil
+		}
+		if handlers.OnFilteredBlockConnected != nil {
+			handlers.OnFilteredBlockConnected(height, header, txs)
+		}
+		if handlers.OnBlockConnected != nil {
+			blockHash := header.BlockHash()
+			handlers.OnBlockConnected(&blockHash, height, header.Timestamp)
+		}
+		next = height + 1
+	}
+}
+
+// AddScripts starts watching outputs paying to the exact pkScripts given.
+// Scripts having a standard address form are watched as addresses, the rest
+// are matched against block filters and blocks directly.
+func (w *Watcher) AddScripts(scripts ...[]byte) error {
+	var addrs []string
+	var raw [][]byte
+	for _, script := range scripts {
+		if a := scriptAddress(script, w.params); a != nil {
+			addrs = append(addrs, a.EncodeAddress())
+		} else {
+			raw = append(raw, append([]byte(nil), script...))
+		}
+	}
+	if len(addrs) != 0 {
+		if err := w.AddAddresses(addrs...); err != nil {
+			return err
+		}
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	w.scripts = append(w.scripts, raw...)
+	w.mu.Unlock()
+
+	return w.updateRescan(neutrino.AddInputs(scriptInputs(raw)...))
+}
+
+// RegisterSpend starts watching spends of outputs of tx paying to watched
+// addresses or scripts, e.g. change of a transaction we broadcast.
+func (w *Watcher) RegisterSpend(tx *wire.MsgTx) error {
+	watched, err := w.watchedScripts()
+	if err != nil {
+		return err
+	}
+
+	txHash := tx.TxHash()
+	var inputs []neutrino.InputWithScript
+	for i, txOut := range tx.TxOut {
+		if !watched[string(txOut.PkScript)] {
+			continue
+		}
+		inputs = append(inputs, neutrino.InputWithScript{
+			OutPoint: wire.OutPoint{Hash: txHash, Index: uint32(i)},
+			PkScript: txOut.PkScript,
+		})
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	w.inputs = append(w.inputs, inputs...)
+	w.mu.Unlock()
+
+	return w.updateRescan(neutrino.AddInputs(inputs...))
+}
+
+// AddOutPoints starts watching spends of the outpoints, e.g. a channel
+// funding output. A spending transaction is delivered as relevant even if it
+// pays to no watched address, with the outpoint in Event.Spends. Neutrino
+// matches spends by the script of the output, so the funding transaction must
+// be known: stored as relevant to watched addresses or scripts, or in a
+// recently fetched block. Add the address before funding to detect it.
+func (w *Watcher) AddOutPoints(ops ...wire.OutPoint) error {
+	inputs := make([]neutrino.InputWithScript, 0, len(ops))
+	for _, op := range ops {
+		txOut, err := w.findOutput(op)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, neutrino.InputWithScript{
+			OutPoint: op,
+			PkScript: txOut.PkScript,
+		})
+	}
+
+	w.mu.Lock()
+	w.inputs = append(w.inputs, inputs...)
+	w.mu.Unlock()
+
+	return w.updateRescan(neutrino.AddInputs(inputs...))
+}
+
+// findOutput returns the output of a stored matched or recently fetched tx.
+func (w *Watcher) findOutput(op wire.OutPoint) (*wire.TxOut, error) {
+	var msgTx *wire.MsgTx
+	if tx := w.blocks.getTx(op.Hash); tx != nil {
+		msgTx = tx.MsgTx()
+	} else {
+		_, found, ok, err := findMatchedTxByID(w.database(), &op.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("findMatchedTxByID: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("outpoint %v: %w", op, ErrTxNotFound)
+		}
+		msgTx = found
+	}
+	if int(op.Index) >= len(msgTx.TxOut) {
+		return nil, fmt.Errorf("outpoint %v: tx has %d outputs", op, len(msgTx.TxOut))
+	}
+	return msgTx.TxOut[op.Index], nil
+}
+
+// watchedOutPoints returns the set of outpoints whose spends are watched.
+func (w *Watcher) watchedOutPoints() map[wire.OutPoint]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watched := make(map[wire.OutPoint]bool, len(w.inputs))
+	for _, input := range w.inputs {
+		watched[input.OutPoint] = true
+	}
+	return watched
+}
+
+// watchedScripts returns the set of pkScripts of watched addresses and raw
+// scripts.
+func (w *Watcher) watchedScripts() (map[string]bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	aaa, err := w.convertAddresses(w.addresses...)
+	if err != nil {
+		// Should had been detected in AddAddresses.
+		return nil, err
+	}
+	scripts, err := addressScripts(aaa)
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[string]bool, len(scripts)+len(w.scripts))
+	for _, script := range scripts {
+		watched[string(script)] = true
+	}
+	for _, script := range w.rawScripts() {
+		watched[string(script)] = true
+	}
+	return watched, nil
+}
+
+// WatchP2SH starts watching P2SH outputs with the given script hash.
+func (w *Watcher) WatchP2SH(scriptHash [20]byte) error {
+	a, err := btcutil.NewAddressScriptHashFromHash(scriptHash[:], w.params)
+	if err != nil {
+		return fmt.Errorf("btcutil.NewAddressScriptHashFromHash: %w", err)
+	}
+	return w.AddAddresses(a.EncodeAddress())
+}
+
+// addScriptMatches adds transactions paying to raw scripts to relevantTxs.
+// Neutrino fetches such blocks because of the filter match, but reports only
+// transactions paying to addresses. It fails if the filter or the block can
+// not be fetched, so that the block is not processed without its matches.
+func (w *Watcher) addScriptMatches(header *wire.BlockHeader, relevantTxs []*btcutil.Tx) ([]*btcutil.Tx, error) {
+	w.mu.Lock()
+	scripts := w.rawScripts()
+	w.mu.Unlock()
+	if len(scripts) == 0 {
+		return relevantTxs, nil
+	}
+
+	blockHash := header.BlockHash()
+	filter, err := fetchFilter(context.Background(), w.chainService(), w.opts, w.fullClose, &blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetCFilter(%s): %w", blockHash, err)
+	}
+	matched, err := filter.MatchAny(builder.DeriveKey(&blockHash), scripts)
+	if err != nil {
+		return nil, fmt.Errorf("filter.MatchAny: %w", err)
+	}
+	if !matched {
+		return relevantTxs, nil
+	}
+	block, err := w.GetBlock(&blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlock(%s): %w", blockHash, err)
+	}
+	return mergeScriptMatches(block.Transactions(), relevantTxs, scripts), nil
+}
+
+// deliverMatchedBlock passes the block to the callback set by
+// WithMatchedBlockCallback if it has relevant transactions. It runs on the
+// rescan goroutine, so it takes the block the rescan fetched from the cache
+// and skips it on a miss instead of downloading it again.
+func (w *Watcher) deliverMatchedBlock(height int32, header *wire.BlockHeader, relevantTxs []*btcutil.Tx) {
+	callback := w.opts.onMatchedBlock
+	if callback == nil || len(relevantTxs) == 0 {
+		return
+	}
+	blockHash := header.BlockHash()
+	block := w.blocks.getBlock(blockHash)
+	if block == nil {
+		w.opts.logWarn("Matched block is not cached, not passing it", "height", height, "hash", blockHash)
+		return
+	}
+	w.pause.call(func() { callback(height, block) })
+}
+
+// ValidateAddress checks that addr can be watched on the network of the
+// watcher, without watching it.
+func (w *Watcher) ValidateAddress(addr string) error {
+	_, err := w.convertAddresses(addr)
+	return err
+}
+
+// convertAddresses decodes addresses for neutrino. P2TR addresses are checked
+// but left out, see watchTaproot.
 func (w *Watcher) convertAddresses(addrs ...string) ([]btcutil.Address, error) {
 	aaa := make([]btcutil.Address, 0, len(addrs))
 	for _, addr := range addrs {
-		a, err := btcutil.DecodeAddress(addr, w.params)
+		a, err := decodeAddress(addr, w.params)
 		if err != nil {
-			return nil, fmt.Errorf("btcutil.DecodeAddress: %w", err)
+			return nil, err
+		}
+		if _, ok := a.(*taprootAddress); ok {
+			continue
 		}
 		aaa = append(aaa, a)
 	}
 	return aaa, nil
 }
 
+// watchTaproot stores pkScripts of P2TR addresses among addrs and returns
+// them, to be added to the rescan as scripts.
+func (w *Watcher) watchTaproot(addrs []string) [][]byte {
+	taproot := taprootScripts(addrs, w.params)
+	if len(taproot) == 0 {
+		return nil
+	}
+	scripts := make([][]byte, 0, len(taproot))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.taproot == nil {
+		w.taproot = make(map[string][]byte, len(taproot))
+	}
+	for addr, script := range taproot {
+		w.taproot[addr] = script
+		scripts = append(scripts, script)
+	}
+	return scripts
+}
+
+// rawScripts returns scripts matched against filters and blocks: the ones
+// passed to AddScripts and the ones of P2TR addresses. w.mu must be held.
+func (w *Watcher) rawScripts() [][]byte {
+	if len(w.taproot) == 0 {
+		return w.scripts
+	}
+	scripts := make([][]byte, 0, len(w.scripts)+len(w.taproot))
+	scripts = append(scripts, w.scripts...)
+	for _, script := range w.taproot {
+		scripts = append(scripts, script)
+	}
+	return scripts
+}
+
 func resolveHost(proxy tor.Net, host string) ([]net.IP, error) {
 	addrs, err := proxy.LookupHost(host)
 	if err != nil {
@@ -1,24 +1,35 @@
 package watch
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcwallet/walletdb"
+	"github.com/lightninglabs/neutrino"
 )
 
 type W interface {
 	Close() error
 	WaitForSync() error
 	CurrentHeight() (int32, error)
-	StartWatching(startBlock int32, handlers rpcclient.NotificationHandlers)
+	StartWatching(startBlock int32, handlers rpcclient.NotificationHandlers) error
 	AddAddresses(addrs ...string) error
 }
 
@@ -88,13 +99,17 @@ func TestWatcher(t *testing.T) {
 			},
 		}
 		if startWatchingBefore {
-			watcher.StartWatching(startBlock, handlers)
+			if err := watcher.StartWatching(startBlock, handlers); err != nil {
+				t.Fatalf("StartWatching: %v.", err)
+			}
 		}
 		if err := watcher.AddAddresses(addr); err != nil {
 			t.Fatalf("AddAddresses: %v.", err)
 		}
 		if !startWatchingBefore {
-			watcher.StartWatching(startBlock, handlers)
+			if err := watcher.StartWatching(startBlock, handlers); err != nil {
+				t.Fatalf("StartWatching: %v.", err)
+			}
 		}
 		wg.Add(1)
 		wg.Wait()
@@ -187,3 +202,1130 @@ func TestWatcher(t *testing.T) {
 		}
 	}
 }
+
+func TestRescanRange(t *testing.T) {
+	const (
+		block  = 628330
+		txid   = "d40f946de9a47d28f0d706d183186ca84b048080736dee4234f8ea9a06a48c26"
+		addr   = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+		amount = btcutil.Amount(20731159)
+	)
+
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watcher, err := New(MainNetPeers, "", false, tmpDir)
+	if err != nil {
+		t.Fatalf("New: %v.", err)
+	}
+	defer watcher.Close()
+	if err := watcher.WaitForSync(); err != nil {
+		t.Fatalf("WaitForSync: %v.", err)
+	}
+	if err := watcher.AddAddresses(addr); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+
+	found := 0
+	handlers := rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, relevantTxs []*btcutil.Tx) {
+			for _, tx := range relevantTxs {
+				if tx.Hash().String() != txid {
+					continue
+				}
+				found++
+				if height != block {
+					t.Errorf("tx %s at height %d, want %d.", txid, height, block)
+				}
+				if got := PrepareTxOutputs(tx, false)[addr]; got != amount {
+					t.Errorf("Address %s in tx %s got %s, want %s.", addr, txid, got, amount)
+				}
+			}
+		},
+	}
+	if err := watcher.RescanRange(block-10, block+1, handlers); err != nil {
+		t.Fatalf("RescanRange: %v.", err)
+	}
+	if found != 1 {
+		t.Errorf("tx %s found %d times, want 1.", txid, found)
+	}
+}
+
+func TestRescanRangeInvalid(t *testing.T) {
+	w := &Watcher{}
+	for _, r := range [][2]int32{{0, 10}, {10, 9}} {
+		if err := w.RescanRange(r[0], r[1], rpcclient.NotificationHandlers{}); err == nil {
+			t.Errorf("RescanRange(%d, %d) succeeded, want error.", r[0], r[1])
+		}
+	}
+}
+
+func TestRegisterSpend(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+	if err := watcher.AddAddresses(addr); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := txscript.PayToAddrScript(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+	tx.AddTxOut(wire.NewTxOut(2000, change))
+	if err := watcher.RegisterSpend(tx); err != nil {
+		t.Fatalf("RegisterSpend: %v.", err)
+	}
+
+	want := wire.OutPoint{Hash: tx.TxHash(), Index: 1}
+	if len(watcher.inputs) != 1 || watcher.inputs[0].OutPoint != want {
+		t.Errorf("Watched inputs are %v, want %s.", watcher.inputs, want)
+	}
+}
+
+func TestOpenDBTimeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbFile := filepath.Join(tmpDir, "wallet.db")
+
+	o := newOptions([]Option{WithDBOpenTimeout(100 * time.Millisecond)})
+	db, err := openDB(dbFile, o)
+	if err != nil {
+		t.Fatalf("openDB: %v.", err)
+	}
+	defer db.Close()
+
+	if _, err := openDB(dbFile, o); err == nil {
+		t.Errorf("openDB of a locked file succeeded, want timeout.")
+	}
+}
+
+func TestOpenDBFreelistSync(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbFile := filepath.Join(tmpDir, "wallet.db")
+
+	db, err := openDB(dbFile, newOptions(nil))
+	if err != nil {
+		t.Fatalf("openDB: %v.", err)
+	}
+	if err := putProcessedOutPoints(db, 42, nil); err != nil {
+		t.Fatalf("putProcessedOutPoints: %v.", err)
+	}
+	db.Close()
+
+	// A database created without freelist sync opens in the safer mode.
+	db, err = openDB(dbFile, newOptions([]Option{WithNoFreelistSync(false)}))
+	if err != nil {
+		t.Fatalf("openDB with freelist sync: %v.", err)
+	}
+	defer db.Close()
+	if height, found, err := loadProcessedHeight(db); err != nil || !found || height != 42 {
+		t.Errorf("loadProcessedHeight = %d, %v, %v, want 42.", height, found, err)
+	}
+	if err := putProcessedOutPoints(db, 43, nil); err != nil {
+		t.Errorf("putProcessedOutPoints with freelist sync: %v.", err)
+	}
+}
+
+func TestAddAddressesAfterFailedRestart(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{
+		db:         db,
+		params:     &chaincfg.MainNetParams,
+		restartErr: fmt.Errorf("%w: failed to start", ErrRestartFailed),
+	}
+	if err := watcher.AddAddresses(addr); !errors.Is(err, ErrRestartFailed) {
+		t.Errorf("AddAddresses returned %v, want ErrRestartFailed.", err)
+	}
+	if len(watcher.addresses) != 1 {
+		t.Errorf("Address was not stored to be watched after recovery.")
+	}
+}
+
+func TestDisableAutoRestart(t *testing.T) {
+	const addr = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{
+		db:     db,
+		params: &chaincfg.MainNetParams,
+		opts:   newOptions([]Option{WithDisableAutoRestart(true)}),
+	}
+	if err := watcher.restart(context.Background(), errors.New("sync stalled"), false); !errors.Is(err, ErrNeedsRestart) {
+		t.Errorf("restart returned %v, want ErrNeedsRestart.", err)
+	}
+	if err := watcher.AddAddresses(addr); !errors.Is(err, ErrNeedsRestart) {
+		t.Errorf("AddAddresses returned %v, want ErrNeedsRestart.", err)
+	}
+}
+
+func TestSetAddresses(t *testing.T) {
+	const (
+		addr1 = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs"
+		addr2 = "1BitcoinEaterAddressDontSendf59kuE"
+		addr3 = "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+	)
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+	if err := watcher.AddAddresses(addr1, addr2); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+
+	if err := watcher.SetAddresses(addr2, addr3, addr3); err != nil {
+		t.Fatalf("SetAddresses: %v.", err)
+	}
+	if want := []string{addr2, addr3}; !reflect.DeepEqual(watcher.addresses, want) {
+		t.Errorf("Watched addresses are %v, want %v.", watcher.addresses, want)
+	}
+	if _, err := watcher.AddressActivity(addr3); err != nil {
+		t.Errorf("Added address is not registered: %v.", err)
+	}
+
+	if err := watcher.SetAddresses(addr2, "bad"); err == nil {
+		t.Errorf("SetAddresses accepted a bad address.")
+	}
+	if len(watcher.addresses) != 2 {
+		t.Errorf("Failed SetAddresses changed addresses to %v.", watcher.addresses)
+	}
+
+	// Concurrent calls adding the same address must not watch it twice.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := watcher.SetAddresses(addr2, addr3, addr1); err != nil {
+				t.Errorf("SetAddresses: %v.", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if want := []string{addr2, addr3, addr1}; !reflect.DeepEqual(watcher.ListAddresses(), want) {
+		t.Errorf("Watched addresses after concurrent calls are %v, want %v.", watcher.ListAddresses(), want)
+	}
+}
+
+func TestSetAddressesLarge(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+
+	addrs := make([]string, 10000)
+	for i := range addrs {
+		var hash [20]byte
+		binary.BigEndian.PutUint32(hash[:], uint32(i))
+		a, err := btcutil.NewAddressPubKeyHash(hash[:], watcher.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs[i] = a.EncodeAddress()
+	}
+	if err := watcher.SetAddresses(addrs...); err != nil {
+		t.Fatalf("SetAddresses: %v.", err)
+	}
+	if got := len(watcher.ListAddresses()); got != len(addrs) {
+		t.Errorf("Watching %d addresses, want %d.", got, len(addrs))
+	}
+
+	// Setting the same set must not update the rescan, which would panic
+	// here because there is none.
+	watcher.watching = true
+	if err := watcher.SetAddresses(addrs...); err != nil {
+		t.Fatalf("SetAddresses of the same set: %v.", err)
+	}
+	if err := watcher.AddAddresses(); err != nil {
+		t.Fatalf("AddAddresses without addresses: %v.", err)
+	}
+}
+
+func TestRemoveAddresses(t *testing.T) {
+	const addr1, addr2 = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", "1BitcoinEaterAddressDontSendf59kuE"
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+	if err := watcher.AddAddresses(addr1, addr2); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+
+	if err := watcher.RemoveAddresses(addr1, "never-added"); err != nil {
+		t.Fatalf("RemoveAddresses: %v.", err)
+	}
+	if want := []string{addr2}; !reflect.DeepEqual(watcher.addresses, want) {
+		t.Errorf("Watched addresses after removal are %v, want %v.", watcher.addresses, want)
+	}
+
+	if err := watcher.AddAddresses(addr1); err != nil {
+		t.Fatalf("AddAddresses after removal: %v.", err)
+	}
+	if want := []string{addr2, addr1}; !reflect.DeepEqual(watcher.addresses, want) {
+		t.Errorf("Watched addresses after re-adding are %v, want %v.", watcher.addresses, want)
+	}
+	watched, err := watcher.watchedScripts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watched) != 2 {
+		t.Errorf("Watching %d scripts after re-adding, want 2.", len(watched))
+	}
+}
+
+func TestListAddresses(t *testing.T) {
+	const addr1, addr2 = "3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs", "1BitcoinEaterAddressDontSendf59kuE"
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+	if err := watcher.AddAddresses(addr1, addr2); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+
+	list := watcher.ListAddresses()
+	if want := []string{addr1, addr2}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("ListAddresses() = %v, want %v.", list, want)
+	}
+	list[0] = "changed"
+	_ = append(list[:1], "appended")
+	if want := []string{addr1, addr2}; !reflect.DeepEqual(watcher.ListAddresses(), want) {
+		t.Errorf("ListAddresses() after changing the result = %v, want %v.", watcher.ListAddresses(), want)
+	}
+}
+
+func TestGetTransaction(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams}
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	tx := btcutil.NewTx(msgTx)
+	if _, _, err := watcher.GetTransaction(tx.Hash()); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("GetTransaction before storing: got %v, want ErrTxNotFound.", err)
+	}
+
+	if err := storeMatchedTxs(db, 10, &wire.BlockHeader{}, []*btcutil.Tx{tx}); err != nil {
+		t.Fatalf("storeMatchedTxs: %v.", err)
+	}
+	got, height, err := watcher.GetTransaction(tx.Hash())
+	if err != nil {
+		t.Fatalf("GetTransaction: %v.", err)
+	}
+	if *got.Hash() != *tx.Hash() || height != 10 {
+		t.Errorf("GetTransaction = %s at %d, want %s at 10.", got.Hash(), height, tx.Hash())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{
+		db:     db,
+		params: &chaincfg.MainNetParams,
+		opts:   newOptions([]Option{WithDisableAutoRestart(true)}),
+		errs:   make(chan error, errorsBuffer),
+	}
+	watcher.restart(context.Background(), errors.New("sync stalled"), false)
+	if err := <-watcher.Errors(); !errors.Is(err, ErrNeedsRestart) {
+		t.Errorf("Errors received %v, want ErrNeedsRestart.", err)
+	}
+
+	// Nobody reads, so errors beyond the buffer are dropped.
+	for i := 0; i < errorsBuffer+1; i++ {
+		watcher.publishError(errors.New("rescan error"))
+	}
+	if n := len(watcher.Errors()); n != errorsBuffer {
+		t.Errorf("Errors has %d errors, want %d.", n, errorsBuffer)
+	}
+
+	event := error(&RestartEvent{Reason: ErrClosed, Wipe: true})
+	if !errors.Is(event, ErrClosed) {
+		t.Errorf("RestartEvent does not unwrap to its reason.")
+	}
+}
+
+func TestWatcherCloseTwice(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if err := watcher.Close(); err != nil {
+		t.Errorf("second Close returned %v, want nil.", err)
+	}
+	if _, open := <-watcher.Errors(); open {
+		t.Errorf("Errors is not closed after Close.")
+	}
+}
+
+func TestAddOutPoints(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	watcher := &Watcher{db: db, params: &chaincfg.MainNetParams, blocks: newBlockCache(1)}
+
+	funding := makeTestTx(1)
+	op := wire.OutPoint{Hash: *funding.Hash(), Index: 0}
+	if err := watcher.AddOutPoints(op); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("AddOutPoints of an unknown tx returned %v, want ErrTxNotFound.", err)
+	}
+	if err := storeMatchedTxs(db, 10, &wire.BlockHeader{}, []*btcutil.Tx{funding}); err != nil {
+		t.Fatalf("storeMatchedTxs: %v.", err)
+	}
+	if err := watcher.AddOutPoints(wire.OutPoint{Hash: op.Hash, Index: 1}); err == nil {
+		t.Errorf("AddOutPoints of a missing output succeeded.")
+	}
+	if err := watcher.AddOutPoints(op); err != nil {
+		t.Fatalf("AddOutPoints: %v.", err)
+	}
+
+	spendTx := wire.NewMsgTx(wire.TxVersion)
+	spendTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 5}, nil, nil))
+	spendTx.AddTxIn(wire.NewTxIn(&op, nil, nil))
+	spendTx.AddTxOut(wire.NewTxOut(900, []byte{0x51}))
+	spend := btcutil.NewTx(spendTx)
+
+	var events []Event
+	o := newOptions([]Option{WithEventHandler(func(e Event) { events = append(events, e) })})
+	noPrevOut := func(wire.OutPoint) *wire.TxOut { return nil }
+	deliverEvents(o, nil, watcher.params, nil, watcher.watchedOutPoints(), 11, &chainhash.Hash{}, []*btcutil.Tx{spend}, noPrevOut)
+	if len(events) != 1 || !reflect.DeepEqual(events[0].Spends, []wire.OutPoint{op}) {
+		t.Errorf("events %+v, want a spend of %v.", events, op)
+	}
+}
+
+func TestCurrentTipAndHeaders(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+
+	hash, height, err := watcher.CurrentTip()
+	if err != nil {
+		t.Fatalf("CurrentTip: %v.", err)
+	}
+	if height != 0 || *hash != *chaincfg.RegressionNetParams.GenesisHash {
+		t.Errorf("CurrentTip returned %s at %d, want the genesis block.", hash, height)
+	}
+
+	hash, err = watcher.GetBlockHash(0)
+	if err != nil {
+		t.Fatalf("GetBlockHash(0): %v.", err)
+	}
+	header, err := watcher.GetBlockHeader(hash)
+	if err != nil {
+		t.Fatalf("GetBlockHeader: %v.", err)
+	}
+	if header.BlockHash() != *hash {
+		t.Errorf("GetBlockHeader returned header of %s, want %s.", header.BlockHash(), hash)
+	}
+	for _, h := range []int32{-1, 1} {
+		if _, err := watcher.GetBlockHash(h); err == nil {
+			t.Errorf("GetBlockHash(%d) succeeded, want error.", h)
+		}
+	}
+	if _, err := watcher.GetBlockHeader(&chainhash.Hash{1}); err == nil {
+		t.Errorf("GetBlockHeader of unknown hash succeeded, want error.")
+	}
+
+	// Nothing is watched, so the genesis block is not downloaded.
+	txs, header, err := watcher.ScanBlock(0)
+	if err != nil {
+		t.Fatalf("ScanBlock(0): %v.", err)
+	}
+	if len(txs) != 0 || header.BlockHash() != *hash {
+		t.Errorf("ScanBlock(0) returned %d txs and header of %s, want none and the genesis block.", len(txs), header.BlockHash())
+	}
+	if _, _, err := watcher.ScanBlock(1); err == nil {
+		t.Errorf("ScanBlock beyond the tip succeeded, want error.")
+	}
+}
+
+func TestWaitForHeight(t *testing.T) {
+	clock := &fakeClock{}
+	o := newOptions([]Option{WithSyncPollInterval(time.Second)})
+	o.clock = clock
+	height := int32(5)
+	currentHeight := func() (int32, error) {
+		height++
+		return height, nil
+	}
+	if err := waitForHeight(context.Background(), o, nil, currentHeight, 9); err != nil {
+		t.Fatalf("waitForHeight: %v.", err)
+	}
+	if height != 9 || len(clock.delays) != 3 || clock.delays[0] != time.Second {
+		t.Errorf("Stopped at height %d after waits %v, want 9 after 3 waits of 1s.", height, clock.delays)
+	}
+
+	if err := waitForHeight(context.Background(), o, nil, currentHeight, 5); err != nil {
+		t.Errorf("waitForHeight of a passed height: %v.", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stuck := func() (int32, error) { return 1, nil }
+	o.clock = realClock{}
+	if err := waitForHeight(ctx, o, nil, stuck, 9); err != context.Canceled {
+		t.Errorf("waitForHeight with a done context returned %v, want context.Canceled.", err)
+	}
+	quit := make(chan struct{})
+	close(quit)
+	if err := waitForHeight(context.Background(), o, quit, stuck, 9); err != ErrClosed {
+		t.Errorf("waitForHeight after close returned %v, want ErrClosed.", err)
+	}
+}
+
+func TestGetUtxoInvalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+
+	op := wire.OutPoint{Hash: chainhash.Hash{1}}
+	pkScript := []byte{txscript.OP_TRUE}
+	if _, err := watcher.GetUtxo(op, nil, 0); err == nil {
+		t.Errorf("GetUtxo without pkScript succeeded, want error.")
+	}
+	for _, h := range []int32{-1, 1} {
+		if _, err := watcher.GetUtxo(op, pkScript, h); err == nil {
+			t.Errorf("GetUtxo from height %d succeeded, want error.", h)
+		}
+	}
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if _, err := watcher.GetUtxo(op, pkScript, 0); err != ErrClosed {
+		t.Errorf("GetUtxo after Close returned %v, want ErrClosed.", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.AddAddresses("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	watcher.opts.downloads.addBlock(100)
+	want := WatcherStats{Addresses: 1, Downloads: DownloadStats{Blocks: 1, BlockBytes: 100}}
+	if got := watcher.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v.", got, want)
+	}
+}
+
+func TestSharedDirectory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var watchers []*Watcher
+	for _, network := range []Network{Regtest, Simnet} {
+		name := network.String()
+		watcher, err := NewForNetwork(nil, "", network, tmpDir, WithDBFileName(name+".db"), WithDataSubdir("data-"+name))
+		if err != nil {
+			t.Fatalf("NewForNetwork(%s): %v.", name, err)
+		}
+		defer watcher.Close()
+		watchers = append(watchers, watcher)
+
+		for _, file := range []string{name + ".db", "data-" + name} {
+			if _, err := os.Stat(filepath.Join(tmpDir, file)); err != nil {
+				t.Errorf("%s of %s: %v.", file, name, err)
+			}
+		}
+	}
+	for _, watcher := range watchers {
+		marker, err := watcher.NetworkMarker()
+		if err != nil {
+			t.Fatalf("NetworkMarker: %v.", err)
+		}
+		if marker != watcher.Params().Name {
+			t.Errorf("NetworkMarker = %s, want %s.", marker, watcher.Params().Name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "wallet.db")); !os.IsNotExist(err) {
+		t.Errorf("wallet.db was created in the directory.")
+	}
+}
+
+func TestConcurrentNetworks(t *testing.T) {
+	networks := []Network{Regtest, Simnet}
+	watchers := make([]*Watcher, len(networks))
+	errs := make([]error, len(networks))
+	var wg sync.WaitGroup
+	for i, network := range networks {
+		tmpDir, err := ioutil.TempDir("", "watch_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		// Only one of them changes neutrino's globals.
+		var opts []Option
+		if i == 0 {
+			opts = append(opts, WithUserAgent("first", "1.0"), WithMaxPeers(2))
+		}
+		wg.Add(1)
+		go func(i int, network Network, dir string) {
+			defer wg.Done()
+			watchers[i], errs[i] = NewForNetwork(nil, "", network, dir, opts...)
+		}(i, network, tmpDir)
+	}
+	wg.Wait()
+	for i, watcher := range watchers {
+		if errs[i] != nil {
+			t.Fatalf("NewForNetwork(%s): %v.", networks[i], errs[i])
+		}
+		defer watcher.Close()
+	}
+
+	for i, watcher := range watchers {
+		params, err := networks[i].Params()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, _, err := watcher.CurrentTip()
+		if err != nil {
+			t.Fatalf("CurrentTip of %s: %v.", networks[i], err)
+		}
+		if *hash != *params.GenesisHash {
+			t.Errorf("Tip of %s is %s, want its genesis %s.", networks[i], hash, params.GenesisHash)
+		}
+	}
+	if neutrino.UserAgentName == "first" || neutrino.TargetOutbound == 2 {
+		t.Errorf("neutrino globals were not restored.")
+	}
+}
+
+func TestConnectPeers(t *testing.T) {
+	peers := []string{"192.0.2.1:8333"}
+	if got := connectPeers(peers, newOptions(nil)); !reflect.DeepEqual(got, peers) {
+		t.Errorf("connectPeers returned %v by default, want %v.", got, peers)
+	}
+	o := newOptions([]Option{WithDNSSeeds(true)})
+	if got := connectPeers(peers, o); got != nil {
+		t.Errorf("connectPeers returned %v with DNS seeds, want nil.", got)
+	}
+}
+
+func TestDeliverMatchedBlock(t *testing.T) {
+	var got []int32
+	w := &Watcher{
+		opts: newOptions([]Option{WithMatchedBlockCallback(func(height int32, block *btcutil.Block) {
+			got = append(got, height)
+		})}),
+		blocks: newBlockCache(1),
+	}
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Header:       wire.BlockHeader{Nonce: 1},
+		Transactions: []*wire.MsgTx{makeTestTx(1).MsgTx()},
+	})
+	w.blocks.put(block)
+	header := &block.MsgBlock().Header
+
+	w.deliverMatchedBlock(10, header, nil)
+	w.deliverMatchedBlock(11, header, block.Transactions())
+	w.Pause()
+	w.deliverMatchedBlock(12, header, block.Transactions())
+	if !reflect.DeepEqual(got, []int32{11}) {
+		t.Errorf("Callback got heights %v, want only the matched unpaused one.", got)
+	}
+	if err := w.Resume(); err != nil {
+		t.Fatalf("Resume: %v.", err)
+	}
+	if !reflect.DeepEqual(got, []int32{11, 12}) {
+		t.Errorf("Callback got heights %v after Resume, want 11 and 12.", got)
+	}
+
+	// A block missing from the cache is skipped, not downloaded.
+	other := &wire.BlockHeader{Nonce: 2}
+	w.deliverMatchedBlock(13, other, block.Transactions())
+	if !reflect.DeepEqual(got, []int32{11, 12}) {
+		t.Errorf("Callback got heights %v after a cache miss, want 11 and 12.", got)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+
+	_, err = NewForNetwork(nil, "", Regtest, tmpDir, WithDBOpenTimeout(100*time.Millisecond))
+	if !errors.Is(err, ErrDBOpen) {
+		t.Errorf("NewForNetwork with a locked database returned %v, want ErrDBOpen.", err)
+	}
+
+	if _, err := watcher.CaughtUp(); !errors.Is(err, ErrNotWatching) {
+		t.Errorf("CaughtUp before StartWatching returned %v, want ErrNotWatching.", err)
+	}
+	if err := watcher.StartWatching(0, rpcclient.NotificationHandlers{}); err != nil {
+		t.Fatalf("StartWatching: %v.", err)
+	}
+	if err := watcher.StartWatching(0, rpcclient.NotificationHandlers{}); !errors.Is(err, ErrAlreadyWatching) {
+		t.Errorf("Second StartWatching returned %v, want ErrAlreadyWatching.", err)
+	}
+
+	for _, addr := range []string{"not an address", "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"} {
+		if err := watcher.AddAddresses(addr); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("AddAddresses(%s) returned %v, want ErrInvalidAddress.", addr, err)
+		}
+	}
+
+	for _, err := range []error{ErrRestartFailed, ErrNeedsRestart} {
+		if !errors.Is(err, ErrNotWatching) {
+			t.Errorf("%v does not wrap ErrNotWatching.", err)
+		}
+	}
+
+	// Both the sentinel and the cause can be matched.
+	err = &sentinelError{ErrRestartFailed, fmt.Errorf("walletdb: %w", os.ErrPermission)}
+	for _, target := range []error{ErrRestartFailed, ErrNotWatching, os.ErrPermission} {
+		if !errors.Is(err, target) {
+			t.Errorf("%v does not match %v.", err, target)
+		}
+	}
+	if errors.Is(err, ErrDBOpen) {
+		t.Errorf("%v matches ErrDBOpen.", err)
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	w := &Watcher{params: &chaincfg.MainNetParams}
+	for _, addr := range []string{
+		"3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWs",
+		"1BitcoinEaterAddressDontSendf59kuE",
+		testZpubReceiving,
+		testTaprootAddress,
+	} {
+		if err := w.ValidateAddress(addr); err != nil {
+			t.Errorf("ValidateAddress(%s): %v.", addr, err)
+		}
+	}
+	for _, addr := range []string{
+		"",
+		"not an address",
+		"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn",
+		"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx",
+		"3HuJwfCpp3mB8hFctX2N9SMz7euKCQ4vWt",
+	} {
+		if err := w.ValidateAddress(addr); err == nil {
+			t.Errorf("ValidateAddress(%q) succeeded, want error.", addr)
+		}
+	}
+	if len(w.ListAddresses()) != 0 {
+		t.Errorf("ValidateAddress added addresses.")
+	}
+}
+
+func TestNewWithDB(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	appBucket := []byte("app")
+	if err := walletdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		_, err := tx.CreateTopLevelBucket(appBucket)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, err := NewWithDB(nil, "", &chaincfg.RegressionNetParams, tmpDir, db)
+	if err != nil {
+		t.Fatalf("NewWithDB: %v.", err)
+	}
+	if err := watcher.AddAddresses("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "wallet.db")); !os.IsNotExist(err) {
+		t.Errorf("wallet.db was created in the directory.")
+	}
+
+	// The database is still open and reset keeps everything but neutrino.
+	if err := deleteNeutrinoBuckets(db); err != nil {
+		t.Fatalf("deleteNeutrinoBuckets: %v.", err)
+	}
+	if err := walletdb.View(db, func(tx walletdb.ReadTx) error {
+		for _, name := range [][]byte{appBucket, addressActivityBucket} {
+			if tx.ReadBucket(name) == nil {
+				t.Errorf("bucket %s was deleted.", name)
+			}
+		}
+		for _, name := range neutrinoBuckets[:1] {
+			if tx.ReadBucket(name) != nil {
+				t.Errorf("bucket %s was not deleted.", name)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("View after Close: %v.", err)
+	}
+}
+
+func TestAddAddressesAfterClose(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watcher, err := NewWithDB(nil, "", &chaincfg.RegressionNetParams, tmpDir, db)
+	if err != nil {
+		t.Fatalf("NewWithDB: %v.", err)
+	}
+	if err := watcher.StartWatching(0, rpcclient.NotificationHandlers{}); err != nil {
+		t.Fatalf("StartWatching: %v.", err)
+	}
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if err := watcher.AddAddresses("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"); !errors.Is(err, ErrClosed) {
+		t.Errorf("AddAddresses after Close returned %v, want ErrClosed.", err)
+	}
+	if watcher.Stats().Watching {
+		t.Errorf("Stats reports watching after Close.")
+	}
+	if _, err := watcher.GetBlock(watcher.params.GenesisHash); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetBlock after Close returned %v, want ErrClosed.", err)
+	}
+	if _, err := watcher.CacheStats(); !errors.Is(err, ErrClosed) {
+		t.Errorf("CacheStats after Close returned %v, want ErrClosed.", err)
+	}
+}
+
+func TestRestartDuringClose(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	if err := watcher.StartWatching(0, rpcclient.NotificationHandlers{}); err != nil {
+		t.Fatalf("StartWatching: %v.", err)
+	}
+	restarted := make(chan error, 1)
+	go func() {
+		restarted <- watcher.restart(context.Background(), errors.New("sync stalled"), false)
+	}()
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close: %v.", err)
+	}
+	if err := <-restarted; !errors.Is(err, ErrClosed) {
+		t.Errorf("restart during Close returned %v, want ErrClosed.", err)
+	}
+}
+
+func TestStartWatchingFromTip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+
+	started := make(chan error, 1)
+	go func() {
+		started <- watcher.StartWatching(StartFromTip, rpcclient.NotificationHandlers{})
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("StartWatching: %v.", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("StartWatching(StartFromTip) did not return.")
+	}
+	if got := atomic.LoadInt32(&watcher.scannedHeight); got != 0 {
+		t.Errorf("Scanned height is %d, want the tip 0.", got)
+	}
+}
+
+func TestWaitForFiltersClock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}), WithSyncPollInterval(250*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &cancelClock{cancel: cancel}
+	watcher.opts.clock = clock
+
+	// Without peers filter headers never reach the height.
+	if err := watcher.WaitForFilters(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForFilters returned %v, want context.Canceled.", err)
+	}
+	if len(clock.delays) == 0 || clock.delays[0] != 250*time.Millisecond {
+		t.Errorf("WaitForFilters poll delays are %v, want 250ms.", clock.delays)
+	}
+}
+
+func TestRebuildDoesNotPublishRescanExit(t *testing.T) {
+	const addr = "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir)
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+	if err := watcher.StartWatching(0, rpcclient.NotificationHandlers{}); err != nil {
+		t.Fatalf("StartWatching: %v.", err)
+	}
+	if err := watcher.AddAddresses(addr); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	if err := watcher.RemoveAddresses(addr); err != nil {
+		t.Fatalf("RemoveAddresses: %v.", err)
+	}
+	select {
+	case err := <-watcher.Errors():
+		t.Errorf("Rebuilding the rescan published %v.", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStallPolls(t *testing.T) {
+	for _, polls := range []int{3, 0} {
+		tmpDir, err := ioutil.TempDir("", "watch_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+		watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}), WithDisableAutoRestart(true), WithStallPolls(polls))
+		if err != nil {
+			t.Fatalf("NewForNetwork: %v.", err)
+		}
+		defer watcher.Close()
+		clock := &fakeClock{}
+		watcher.opts.clock = clock
+
+		// Without peers the headers never progress.
+		if err := watcher.WaitForSyncContext(context.Background()); !errors.Is(err, ErrNeedsRestart) {
+			t.Errorf("WaitForSyncContext returned %v, want ErrNeedsRestart.", err)
+		}
+		want := polls
+		if want == 0 {
+			want = defaultStallPolls
+		}
+		if len(clock.delays) != want {
+			t.Errorf("With %d stall polls restarted after %d polls, want %d.", polls, len(clock.delays), want)
+		}
+	}
+}
+
+func TestRestartContext(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}), WithSyncPollInterval(10*time.Millisecond), WithStallPolls(1000))
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+
+	// Without peers the restarted service never syncs, so only ctx ends
+	// the restart.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := watcher.restart(ctx, errors.New("sync stalled"), false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("restart returned %v, want context.DeadlineExceeded.", err)
+	}
+	if err := watcher.AddAddresses("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"); err != nil {
+		t.Errorf("AddAddresses after an interrupted restart: %v.", err)
+	}
+}
+
+func TestRestartWipe(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}), WithSyncPollInterval(10*time.Millisecond), WithStallPolls(1000))
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := watcher.restart(ctx, errChainReset, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("restart returned %v, want context.DeadlineExceeded.", err)
+	}
+	var event *RestartEvent
+	if err := <-watcher.Errors(); !errors.As(err, &event) || !event.Wipe {
+		t.Errorf("First event is %v, want a wiping restart without soft restarts before.", err)
+	}
+	if n := watcher.Stats().Restarts; n != 1 {
+		t.Errorf("Restarted %d times, want 1.", n)
+	}
+}
+
+func TestScannedHeightAfterDelivery(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	sink := &flakySink{failures: 1 << 30}
+	watcher := &Watcher{
+		db:     db,
+		params: &chaincfg.MainNetParams,
+		opts:   newOptions([]Option{WithLogger(&testLogger{}), WithSinks(sink)}),
+		blocks: newBlockCache(10),
+	}
+	watcher.opts.clock = &fakeClock{}
+	header := &wire.BlockHeader{Nonce: 1}
+	txs := []*btcutil.Tx{makeTestTx(1)}
+
+	// The sink never accepts the event, so the block is not processed
+	// until the rescan is stopped.
+	quit := make(chan struct{})
+	close(quit)
+	ntfn := watcher.rescanHandlers(rpcclient.NotificationHandlers{}, quit)
+	ntfn.OnFilteredBlockConnected(5, header, txs)
+	if h := atomic.LoadInt32(&watcher.scannedHeight); h != 0 {
+		t.Errorf("Scanned height is %d after failed delivery, want 0.", h)
+	}
+
+	sink.failures = 0
+	ntfn = watcher.rescanHandlers(rpcclient.NotificationHandlers{}, make(chan struct{}))
+	ntfn.OnFilteredBlockConnected(5, header, txs)
+	if h := atomic.LoadInt32(&watcher.scannedHeight); h != 5 {
+		t.Errorf("Scanned height is %d after delivery, want 5.", h)
+	}
+}
+
+func TestAddScriptMatchesError(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watcher, err := NewForNetwork(nil, "", Regtest, tmpDir, WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatalf("NewForNetwork: %v.", err)
+	}
+	defer watcher.Close()
+	if err := watcher.AddScripts([]byte{0x51}); err != nil {
+		t.Fatalf("AddScripts: %v.", err)
+	}
+
+	// The block is unknown, so its filter can not be fetched.
+	txs := []*btcutil.Tx{makeTestTx(1)}
+	if _, err := watcher.addScriptMatches(&wire.BlockHeader{Nonce: 1}, txs); err == nil {
+		t.Errorf("addScriptMatches of an unknown block succeeded, want error.")
+	}
+}
+
+func TestCheckpointMismatchHandler(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	header := wire.BlockHeader{Nonce: 1}
+	watcher := &Watcher{
+		db:      db,
+		params:  &chaincfg.MainNetParams,
+		opts:    newOptions([]Option{WithLogger(&testLogger{}), WithCheckpoint(10, header.BlockHash())}),
+		blocks:  newBlockCache(10),
+		gate:    &confirmationGate{minConfirmations: 1},
+		started: true,
+	}
+
+	var connected []int32
+	quit := make(chan struct{})
+	close(quit)
+	ntfn := watcher.rescanHandlers(rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			connected = append(connected, height)
+		},
+	}, quit)
+
+	ntfn.OnFilteredBlockConnected(9, &wire.BlockHeader{Nonce: 2}, nil)
+	ntfn.OnFilteredBlockConnected(10, &header, nil)
+	if _, err := watcher.CaughtUp(); err != nil {
+		t.Fatalf("CaughtUp after the checkpoint returned %v, want nil.", err)
+	}
+	ntfn.OnFilteredBlockConnected(10, &wire.BlockHeader{Nonce: 2}, nil)
+	if _, err := watcher.CaughtUp(); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("CaughtUp after another block at the checkpoint returned %v, want ErrCheckpointMismatch.", err)
+	}
+	if !reflect.DeepEqual(connected, []int32{9, 10}) {
+		t.Errorf("Handler got heights %v, want [9 10].", connected)
+	}
+}
@@ -14,22 +14,31 @@ import (
 
 var (
 	testnet      = flag.Bool("testnet", false, "Use testnet instead of mainnet")
+	signet       = flag.Bool("signet", false, "Use signet instead of mainnet")
+	testnet4     = flag.Bool("testnet4", false, "Use testnet4 instead of mainnet")
 	torSocksAddr = flag.String("tor-socks", "127.0.0.1:9050", "Tor address for neutrino")
 	addr         = flag.String("address", "", "Address to follow")
 	startBlock   = flag.Int("start-block", 0, "Start block")
 	dir          = flag.String("dir", ".", "Directory with neutrino data")
+	dnsSeeds     = flag.Bool("dns-seeds", false, "Discover more peers through DNS seeds")
 )
 
 func main() {
 	flag.Parse()
 
-	peers := watch.MainNetPeers
+	network := watch.Mainnet
 	if *testnet {
-		peers = watch.TestNet3Peers
+		network = watch.Testnet3
+	}
+	if *signet {
+		network = watch.Signet
+	}
+	if *testnet4 {
+		network = watch.Testnet4
 	}
 
 	log.Println("Creating watcher.")
-	watcher, err := watch.New(peers, *torSocksAddr, *testnet, *dir)
+	watcher, err := watch.NewForNetwork(watch.DefaultPeers(network), *torSocksAddr, network, *dir, watch.WithDNSSeeds(*dnsSeeds))
 	if err != nil {
 		log.Fatalf("New: %v.", err)
 	}
@@ -66,7 +75,7 @@ func main() {
 	log.Printf("Following %s. Incomes only.", *addr)
 	handler := func(height int32, header *wire.BlockHeader, relevantTxs []*btcutil.Tx) {
 		for _, tx := range relevantTxs {
-			outputs := watch.PrepareTxOutputs(tx, *testnet)
+			outputs := watcher.PrepareTxOutputs(tx)
 			amount, has := outputs[*addr]
 			if !has {
 				return
@@ -77,7 +86,9 @@ func main() {
 	handlers := rpcclient.NotificationHandlers{
 		OnFilteredBlockConnected: handler,
 	}
-	watcher.StartWatching(int32(*startBlock), handlers)
+	if err := watcher.StartWatching(int32(*startBlock), handlers); err != nil {
+		log.Fatalf("StartWatching: %v.", err)
+	}
 	if err := watcher.AddAddresses(*addr); err != nil {
 		log.Fatalf("AddAddresses: %v.", err)
 	}
@@ -0,0 +1,213 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// derivedType is the type of addresses derived from an extended key.
+type derivedType int
+
+const (
+	derivedP2PKH derivedType = iota
+	derivedP2SHP2WPKH
+	derivedP2WPKH
+)
+
+// slip132Versions maps SLIP-132 version bytes of extended public keys to the
+// address type and whether the key is for mainnet.
+var slip132Versions = map[[4]byte]struct {
+	addrType derivedType
+	mainnet  bool
+}{
+	{0x04, 0x88, 0xb2, 0x1e}: {derivedP2PKH, true},       // xpub
+	{0x04, 0x9d, 0x7c, 0xb2}: {derivedP2SHP2WPKH, true},  // ypub
+	{0x04, 0xb2, 0x47, 0x46}: {derivedP2WPKH, true},      // zpub
+	{0x04, 0x35, 0x87, 0xcf}: {derivedP2PKH, false},      // tpub
+	{0x04, 0x4a, 0x52, 0x62}: {derivedP2SHP2WPKH, false}, // upub
+	{0x04, 0x5f, 0x1c, 0xf6}: {derivedP2WPKH, false},     // vpub
+}
+
+// derivedChain is the external (0) or internal (1) chain of an extended key.
+type derivedChain struct {
+	key      *hdkeychain.ExtendedKey
+	branch   uint32
+	addrType derivedType
+	gapLimit uint32
+
+	// derived is the number of addresses derived so far. stopped is set
+	// if onDeriveAddress vetoed an address.
+	derived uint32
+	stopped bool
+}
+
+// derivedAddress is the position of a derived address.
+type derivedAddress struct {
+	chain *derivedChain
+	index uint32
+}
+
+// parseExtendedKey parses an extended public key for the network and returns
+// the key and the type of addresses to derive.
+func parseExtendedKey(xpub string, params *chaincfg.Params) (*hdkeychain.ExtendedKey, derivedType, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, 0, fmt.Errorf("hdkeychain.NewKeyFromString: %w", err)
+	}
+	if key.IsPrivate() {
+		return nil, 0, errors.New("private extended keys are not accepted, pass the public one")
+	}
+	var version [4]byte
+	copy(version[:], base58.Decode(xpub))
+	if version == params.HDPublicKeyID {
+		return key, derivedP2PKH, nil
+	}
+	v, has := slip132Versions[version]
+	if !has {
+		return nil, 0, fmt.Errorf("unknown extended key version %x", version)
+	}
+	if v.mainnet != (params.Net == chaincfg.MainNetParams.Net) {
+		return nil, 0, fmt.Errorf("extended key is not for %s", params.Name)
+	}
+	return key, v.addrType, nil
+}
+
+// address derives the address at the index of the chain.
+func (c *derivedChain) address(index uint32, params *chaincfg.Params) (string, error) {
+	child, err := c.key.Child(index)
+	if err != nil {
+		return "", fmt.Errorf("Child(%d): %w", index, err)
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return "", fmt.Errorf("ECPubKey: %w", err)
+	}
+	addr, err := pubKeyAddress(pubKey.SerializeCompressed(), c.addrType, params)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// pubKeyAddress returns the address of the type paying to the serialized
+// public key.
+func pubKeyAddress(pubKey []byte, addrType derivedType, params *chaincfg.Params) (btcutil.Address, error) {
+	hash := btcutil.Hash160(pubKey)
+	switch addrType {
+	case derivedP2SHP2WPKH:
+		return btcutil.NewAddressScriptHash(append([]byte{0x00, 0x14}, hash...), params)
+	case derivedP2WPKH:
+		return btcutil.NewAddressWitnessPubKeyHash(hash, params)
+	default:
+		return btcutil.NewAddressPubKeyHash(hash, params)
+	}
+}
+
+// AddExtendedKey watches addresses derived from an extended public key at
+// paths 0/i (receiving) and 1/i (change), gapLimit addresses of each chain.
+// When a watched block pays to a derived address, more addresses are derived
+// so that gapLimit unused addresses follow it, see WithOnDeriveAddress. The
+// rescan is then rewound to scan the block again for the new addresses, so
+// the block and the ones processed since are delivered again.
+//
+// The address type follows the SLIP-132 version of the key: xpub and tpub
+// give P2PKH, ypub and upub P2SH-P2WPKH, zpub and vpub P2WPKH addresses.
+//
+// Derivation progress is not stored. After reopening, call AddExtendedKey
+// again and start watching from a block before the first use of the key, so
+// the gap limit is extended again as blocks are scanned.
+func (w *Watcher) AddExtendedKey(xpub string, gapLimit int) error {
+	if gapLimit <= 0 {
+		return fmt.Errorf("gap limit %d is not positive", gapLimit)
+	}
+	key, addrType, err := parseExtendedKey(xpub, w.params)
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	var positions []derivedAddress
+	for i := uint32(0); i < 2; i++ {
+		chainKey, err := key.Child(i)
+		if err != nil {
+			return fmt.Errorf("Child(%d): %w", i, err)
+		}
+		chain := &derivedChain{key: chainKey, branch: i, addrType: addrType, gapLimit: uint32(gapLimit)}
+		for index := uint32(0); index < chain.gapLimit; index++ {
+			addr, err := chain.address(index, w.params)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, addr)
+			positions = append(positions, derivedAddress{chain: chain, index: index})
+		}
+		chain.derived = chain.gapLimit
+	}
+
+	w.mu.Lock()
+	if w.derived == nil {
+		w.derived = make(map[string]derivedAddress)
+	}
+	for i, addr := range addrs {
+		w.derived[addr] = positions[i]
+	}
+	w.mu.Unlock()
+
+	return w.AddAddresses(addrs...)
+}
+
+// extendDerived derives addresses to keep the gap limit after received
+// addresses and returns the new ones. It is called by the rescan goroutine.
+func (w *Watcher) extendDerived(received []string) []string {
+	type job struct {
+		chain    *derivedChain
+		from, to uint32
+	}
+	var jobs []job
+	w.mu.Lock()
+	for _, addr := range received {
+		pos, has := w.derived[addr]
+		if !has || pos.chain.stopped {
+			continue
+		}
+		if need := pos.index + 1 + pos.chain.gapLimit; need > pos.chain.derived {
+			jobs = append(jobs, job{chain: pos.chain, from: pos.chain.derived, to: need})
+			pos.chain.derived = need
+		}
+	}
+	w.mu.Unlock()
+
+	// onDeriveAddress is called without the lock.
+	var addrs []string
+	var positions []derivedAddress
+	for _, j := range jobs {
+		for index := j.from; index < j.to; index++ {
+			addr, err := j.chain.address(index, w.params)
+			if err == nil && !w.opts.approveDerived(j.chain.branch, index, addr) {
+				err = errors.New("vetoed by onDeriveAddress")
+			}
+			if err != nil {
+				w.opts.logWarn("Stopped deriving addresses", "chain", j.chain.branch, "index", index, "err", err)
+				w.mu.Lock()
+				j.chain.stopped = true
+				j.chain.derived = index
+				w.mu.Unlock()
+				break
+			}
+			addrs = append(addrs, addr)
+			positions = append(positions, derivedAddress{chain: j.chain, index: index})
+		}
+	}
+
+	w.mu.Lock()
+	for i, addr := range addrs {
+		w.derived[addr] = positions[i]
+	}
+	w.mu.Unlock()
+	return addrs
+}
@@ -0,0 +1,80 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// BIP84 test vector, account 0.
+const (
+	testZpub          = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	testZpubReceiving = "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"
+	testZpubChange    = "bc1q8c6fshw2dlwun7ekn9qwf37cu2rn755upcp6el"
+)
+
+func TestAddExtendedKey(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	const vetoAt = 4
+	w := &Watcher{
+		db:     db,
+		params: &chaincfg.MainNetParams,
+		opts: newOptions([]Option{WithOnDeriveAddress(func(chain, index uint32, addr string) bool {
+			return chain != 0 || index < vetoAt
+		})}),
+	}
+	if err := w.AddExtendedKey(testZpub, 2); err != nil {
+		t.Fatalf("AddExtendedKey: %v.", err)
+	}
+	addrs := w.ListAddresses()
+	if len(addrs) != 4 || addrs[0] != testZpubReceiving || addrs[2] != testZpubChange {
+		t.Fatalf("Derived %v, want 2 receiving addresses starting with %s and 2 change addresses starting with %s.", addrs, testZpubReceiving, testZpubChange)
+	}
+
+	got := w.extendDerived([]string{addrs[1]})
+	if len(got) != 2 {
+		t.Fatalf("Payment to the last address derived %v, want 2 addresses.", got)
+	}
+	if got := w.extendDerived([]string{addrs[0]}); len(got) != 0 {
+		t.Errorf("Payment to the first address derived %v, want nothing.", got)
+	}
+	if err := w.AddAddresses(got...); err != nil {
+		t.Fatalf("AddAddresses: %v.", err)
+	}
+	// Index 4 of the receiving chain is vetoed, so the chain stops at
+	// index 3.
+	got = w.extendDerived([]string{got[1]})
+	if len(got) != 0 {
+		t.Errorf("Payment to index 3 derived %v, want nothing after the veto.", got)
+	}
+	got = w.extendDerived([]string{addrs[3]})
+	if len(got) != 2 {
+		t.Fatalf("Payment to the last change address derived %v, want 2 addresses.", got)
+	}
+	if got := w.extendDerived([]string{got[1]}); len(got) != 2 {
+		t.Errorf("Payment to index 3 of the change chain derived %v, want 2 addresses.", got)
+	}
+}
+
+func TestParseExtendedKey(t *testing.T) {
+	if _, _, err := parseExtendedKey(testZpub, &chaincfg.TestNet3Params); err == nil {
+		t.Errorf("Mainnet zpub was accepted for testnet.")
+	}
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parseExtendedKey(master.String(), &chaincfg.MainNetParams); err == nil {
+		t.Errorf("Private key was accepted.")
+	}
+	_, addrType, err := parseExtendedKey(testZpub, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("parseExtendedKey: %v.", err)
+	}
+	if addrType != derivedP2WPKH {
+		t.Errorf("zpub derives type %d, want P2WPKH.", addrType)
+	}
+}